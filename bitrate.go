@@ -0,0 +1,185 @@
+package mediaResize
+
+import (
+	"math"
+	"strings"
+)
+
+// BitrateModel 按分辨率和帧率计算码率: 宽×高×帧率×BitsPerPixel×编码效率
+type BitrateModel struct {
+	BitsPerPixel float64 `json:"bitsPerPixel,omitempty" yaml:"bitsPerPixel,omitempty"` //h264每像素每帧的比特数,默认为0.1
+	MinBitrate   int     `json:"minBitrate,omitempty" yaml:"minBitrate,omitempty"`     //最低码率(k),默认为150
+	MaxBitrate   int     `json:"maxBitrate,omitempty" yaml:"maxBitrate,omitempty"`     //最高码率(k),0为不限制
+}
+
+// codecEfficiency 相同画质下相对h264所需的码率比例
+var codecEfficiency = map[string]float64{
+	"hevc": 0.6,
+	"vp9":  0.65,
+	"av1":  0.5,
+}
+
+// ========================
+//
+//	计算指定分辨率和帧率的码率
+//	width		int		宽
+//	height		int		高
+//	fps			float64		帧率,0为30
+//	codec		string		视频编码,用于按编码效率调整
+//	返回值		int		码率(k)
+func (m *BitrateModel) Bitrate(width int, height int, fps float64, codec string) int {
+	bpp := m.BitsPerPixel
+	if bpp <= 0 {
+		bpp = 0.1
+	}
+	if fps <= 0 {
+		fps = 30
+	}
+	if e, ok := codecEfficiency[strings.ToLower(codec)]; ok {
+		bpp *= e
+	}
+	rate := int(math.Round(float64(width) * float64(height) * fps * bpp / 1000))
+	min := m.MinBitrate
+	if min <= 0 {
+		min = 150
+	}
+	if rate < min {
+		rate = min
+	}
+	if m.MaxBitrate > 0 && rate > m.MaxBitrate {
+		rate = m.MaxBitrate
+	}
+	return rate
+}
+
+// bitrateSelector 选择各尺寸的视频码率
+type bitrateSelector struct {
+	bitrates []int         //各尺寸指定的码率(k)
+	model    *BitrateModel //未指定码率时按分辨率计算
+	fallback int           //没有model时使用的码率(k)
+	codec    string        //视频编码
+	fps      float64       //原视频帧率
+	source   int           //原视频码率(k),0为未知
+}
+
+// ========================
+//
+//	创建码率选择器,优先级: 指定码率 > 按分辨率计算 > 编码参数中的码率 > codeRate
+//	bitrates	[]int		各尺寸指定的码率(k),与maxWHs顺序相同
+//	model		*BitrateModel	按分辨率计算码率,可以为nil
+//	enc			*VideoEncoding	编码参数,可以为nil
+//	codeRate	int		视频码率,-1为默认值:1500k
+//	media		*MediaInfo	原视频信息,可以为nil
+//	返回值		*bitrateSelector	码率选择器
+func newBitrateSelector(bitrates []int, model *BitrateModel, enc *VideoEncoding, codeRate int, media *MediaInfo) *bitrateSelector {
+	s := &bitrateSelector{bitrates: bitrates, model: model, fallback: codeRate}
+	if s.fallback < 0 {
+		s.fallback = 1500
+	}
+	if enc != nil {
+		s.codec = enc.Codec
+		if enc.Bitrate > 0 {
+			s.fallback = enc.Bitrate
+		}
+	}
+	if media != nil {
+		if v := media.VideoStream(); v != nil {
+			s.fps = v.FPS
+		}
+		s.source = sourceVideoBitrate(media)
+	}
+	return s
+}
+
+// ========================
+//
+//	第i个尺寸的码率,不超过原视频码率
+//	i			int		尺寸序号
+//	width		int		输出宽度
+//	height		int		输出高度
+//	返回值		int		码率(k)
+func (s *bitrateSelector) bitrate(i int, width int, height int) int {
+	rate := s.fallback
+	if i < len(s.bitrates) && s.bitrates[i] > 0 {
+		rate = s.bitrates[i]
+	} else if s.model != nil {
+		rate = s.model.Bitrate(width, height, s.fps, s.codec)
+	}
+	if s.source > 0 && rate > s.source {
+		rate = s.source
+	}
+	return rate
+}
+
+// ========================
+//
+//	原视频流的码率,视频流没有码率时使用总码率减去音频码率
+//	media		*MediaInfo	原视频信息
+//	返回值		int		码率(k),0为未知
+func sourceVideoBitrate(media *MediaInfo) int {
+	v := media.VideoStream()
+	if v == nil {
+		return 0
+	}
+	if v.Bitrate > 0 {
+		return int(v.Bitrate / 1000)
+	}
+	total := media.Format.Bitrate
+	for _, a := range media.Audio {
+		total -= a.Bitrate
+	}
+	if total <= 0 {
+		return 0
+	}
+	return int(total / 1000)
+}
+
+// ========================
+//
+//	原视频的尺寸、编码和码率均满足要求时,只重新封装不重新编码
+//	media		*MediaInfo	原视频信息
+//	width		int		输出宽度
+//	height		int		输出高度
+//	container	string		容器格式
+//	enc			*VideoEncoding	编码参数,可以为nil
+//	bitrate		int		输出码率(k)
+//	返回值		bool		是否可以只重新封装
+func canRemux(media *MediaInfo, width int, height int, container string, enc *VideoEncoding, bitrate int) bool {
+	if media == nil {
+		return false
+	}
+	v := media.VideoStream()
+	wh, err := media.DisplaySize()
+	if err != nil || wh.Width != width || wh.Height != height {
+		return false
+	}
+	source := sourceVideoBitrate(media)
+	if source <= 0 || source > bitrate {
+		return false
+	}
+	if enc != nil {
+		if enc.Codec != "" && !strings.EqualFold(enc.Codec, v.Codec) {
+			return false
+		}
+		if enc.Encoder != "" || (enc.PixFmt != "" && enc.PixFmt != v.PixFmt) {
+			return false
+		}
+	}
+	c, known := videoContainers[strings.ToLower(container)]
+	return !known || c.video == nil || containsString(c.video, v.Codec)
+}
+
+// ========================
+//
+//	只重新封装时的ffmpeg参数
+//	enc			*VideoEncoding	编码参数,可以为nil
+//	container	string		容器格式
+//	media		*MediaInfo	原视频信息
+//	返回值		[]string	ffmpeg参数
+func remuxArgs(enc *VideoEncoding, container string, media *MediaInfo) []string {
+	args := append([]string{"-c:v", "copy"}, audioCodecArgs(enc, container, media)...)
+	if enc != nil && enc.FastStart {
+		args = append(args, "-movflags", "+faststart")
+	}
+	return args
+}
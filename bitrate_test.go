@@ -0,0 +1,126 @@
+package mediaResize
+
+import (
+	"context"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestBitrateModel(t *testing.T) {
+	m := &BitrateModel{}
+	cases := []struct {
+		w, h  int
+		fps   float64
+		codec string
+		want  int
+	}{
+		{1280, 720, 30, "h264", 2765},
+		{1920, 1080, 30, "", 6221},
+		{1920, 1080, 30, "av1", 3110},
+		{1280, 720, 0, "hevc", 1659},
+		// 不低于最低码率
+		{160, 90, 25, "h264", 150},
+	}
+	for _, c := range cases {
+		if got := m.Bitrate(c.w, c.h, c.fps, c.codec); got != c.want {
+			t.Errorf("Bitrate(%d, %d, %v, %q) = %d, want %d", c.w, c.h, c.fps, c.codec, got, c.want)
+		}
+	}
+	m = &BitrateModel{BitsPerPixel: 0.05, MinBitrate: 300, MaxBitrate: 2000}
+	if got := m.Bitrate(1920, 1080, 60, "h264"); got != 2000 {
+		t.Error("max:", got)
+	}
+	if got := m.Bitrate(320, 180, 25, "h264"); got != 300 {
+		t.Error("min:", got)
+	}
+}
+
+func TestBitrateSelector(t *testing.T) {
+	media := &MediaInfo{
+		Format: MediaFormat{Bitrate: 2128000},
+		Video:  []VideoStream{{Codec: "h264", Width: 1280, Height: 720, FPS: 30}},
+		Audio:  []AudioStream{{Codec: "aac", Bitrate: 128000}},
+	}
+	// 视频流没有码率时使用总码率减去音频码率
+	if got := sourceVideoBitrate(media); got != 2000 {
+		t.Fatal("sourceVideoBitrate:", got)
+	}
+	s := newBitrateSelector([]int{300, 0, 5000}, &BitrateModel{}, nil, -1, media)
+	if got := s.bitrate(0, 320, 180); got != 300 {
+		t.Error("explicit:", got)
+	}
+	if got := s.bitrate(1, 640, 360); got != 691 {
+		t.Error("auto:", got)
+	}
+	// 不超过原视频码率
+	if got := s.bitrate(2, 1280, 720); got != 2000 {
+		t.Error("capped:", got)
+	}
+	s = newBitrateSelector(nil, nil, &VideoEncoding{Bitrate: 800}, 1500, nil)
+	if got := s.bitrate(0, 1280, 720); got != 800 {
+		t.Error("encoding bitrate:", got)
+	}
+	s = newBitrateSelector(nil, nil, nil, -1, nil)
+	if got := s.bitrate(0, 1280, 720); got != 1500 {
+		t.Error("default:", got)
+	}
+}
+
+func TestCanRemux(t *testing.T) {
+	media := &MediaInfo{
+		Video: []VideoStream{{Codec: "h264", PixFmt: "yuv420p", Width: 1280, Height: 720, Bitrate: 1000000}},
+		Audio: []AudioStream{{Codec: "aac"}},
+	}
+	cases := []struct {
+		w, h      int
+		container string
+		enc       *VideoEncoding
+		bitrate   int
+		want      bool
+	}{
+		{1280, 720, "mp4", nil, 1500, true},
+		{1280, 720, "mkv", &VideoEncoding{Codec: "h264", PixFmt: "yuv420p"}, 1000, true},
+		{640, 360, "mp4", nil, 1500, false},
+		{1280, 720, "mp4", nil, 800, false},
+		{1280, 720, "webm", nil, 1500, false},
+		{1280, 720, "mp4", &VideoEncoding{Codec: "hevc"}, 1500, false},
+		{1280, 720, "mp4", &VideoEncoding{PixFmt: "yuv444p"}, 1500, false},
+	}
+	for i, c := range cases {
+		if got := canRemux(media, c.w, c.h, c.container, c.enc, c.bitrate); got != c.want {
+			t.Errorf("case %d: got %v", i, got)
+		}
+	}
+	if got := strings.Join(remuxArgs(&VideoEncoding{FastStart: true}, "mp4", media), " "); got != "-c:v copy -acodec copy -movflags +faststart" {
+		t.Error("remuxArgs:", got)
+	}
+}
+
+func TestVideoResizeBitrates(t *testing.T) {
+	dir := t.TempDir()
+	src := filepath.Join(dir, "a.mp4")
+	newTestVideo(t, src, 320, 240, 2)
+
+	// 原尺寸的码率高于原视频,按原视频码率限制后可以只重新封装
+	opts := &VideoOptions{Bitrates: []int{0, 100000}, AutoBitrate: &BitrateModel{}, Remux: true}
+	res, err := VideoResizeWithOptions(src, filepath.Join(dir, "b.mp4"), []string{"mp4"}, []MediaWH{{Width: 160, Height: 160}, {Width: -1, Height: -1}}, -1, false, opts)
+	if err != nil {
+		t.Fatal(err)
+	}
+	small, err := ProbeMedia(context.Background(), res.Paths[0])
+	if err != nil {
+		t.Fatal(err)
+	}
+	if v := small.VideoStream(); v.Width != 160 {
+		t.Errorf("small: %+v", v)
+	}
+	orig, _ := ProbeMedia(context.Background(), src)
+	remuxed, err := ProbeMedia(context.Background(), res.Paths[1])
+	if err != nil {
+		t.Fatal(err)
+	}
+	if a, b := orig.VideoStream(), remuxed.VideoStream(); a.Codec != b.Codec || a.Frames != b.Frames || a.Bitrate != b.Bitrate {
+		t.Errorf("remuxed: %+v, source: %+v", b, a)
+	}
+}
@@ -0,0 +1,599 @@
+package mediaResize
+
+import (
+	"bytes"
+	"container/list"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"image"
+	"io"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"sync"
+	"time"
+)
+
+// ErrCacheMiss 缓存中没有对应的key
+var ErrCacheMiss = errors.New("mediaResize: cache miss")
+
+// Cache 处理结果缓存,key由原文件内容和处理参数计算
+type Cache interface {
+	Get(ctx context.Context, key string) ([]byte, error) //不存在时返回ErrCacheMiss
+	Set(ctx context.Context, key string, data []byte) error
+}
+
+// ========================
+//
+//	根据原文件内容和处理参数计算缓存key
+//	path		string		原文件路径
+//	params		interface{}	处理参数,使用JSON序列化
+//	返回值		string		缓存key
+//	返回值		error		错误信息,参数无法序列化时不可缓存
+func CacheKey(path string, params interface{}) (string, error) {
+	data, err := json.Marshal(params)
+	if err != nil {
+		return "", err
+	}
+	file, err := os.Open(path)
+	if err != nil {
+		return "", err
+	}
+	defer file.Close()
+	h := sha256.New()
+	if _, err = io.Copy(h, file); err != nil {
+		return "", err
+	}
+	h.Write([]byte{0})
+	h.Write(data)
+	return hex.EncodeToString(h.Sum(nil)), nil
+}
+
+// ========================
+//
+//	计算图片处理的缓存key,水印、字体、级联分类器按内容计算
+//	path		string		原图片路径
+//	formats		[]string	图片格式
+//	maxWHs		[]MediaWH	图片宽高
+//	quality		int		图片质量
+//	opts		*ImgOptions	可选参数
+//	返回值		string		缓存key
+//	返回值		error		错误信息,使用未实现CacheKeyer的自定义处理器或内存中的水印图片时不可缓存
+func imgCacheKey(path string, formats []string, maxWHs []MediaWH, quality int, opts *ImgOptions) (string, error) {
+	params, err := imgParams(formats, maxWHs, quality, opts)
+	if err != nil {
+		return "", err
+	}
+	return CacheKey(path, params)
+}
+
+// ========================
+//
+//	影响图片处理结果的参数
+//	formats		[]string	图片格式
+//	maxWHs		[]MediaWH	图片宽高
+//	quality		int		图片质量
+//	opts		*ImgOptions	可选参数
+//	返回值		interface{}	可JSON序列化的参数
+//	返回值		error		错误信息,使用未实现CacheKeyer的自定义处理器或内存中的水印图片时无法序列化
+func imgParams(formats []string, maxWHs []MediaWH, quality int, opts *ImgOptions) (interface{}, error) {
+	assets, err := imgAssetHashes(opts)
+	if err != nil {
+		return nil, err
+	}
+	source, err := processorKeys(opts.SourceProcessors)
+	if err != nil {
+		return nil, err
+	}
+	processors, err := processorKeys(opts.Processors)
+	if err != nil {
+		return nil, err
+	}
+	// 命名模板只影响保存路径,不同命名可共用缓存
+	normalized := *opts
+	normalized.Naming = ""
+	return struct {
+		Kind             string
+		Formats          []string
+		MaxWHs           []MediaWH
+		Quality          int
+		Options          *ImgOptions
+		SourceProcessors []string
+		Processors       []string
+		Assets           []string
+	}{"image", formats, maxWHs, quality, &normalized, source, processors, assets}, nil
+}
+
+// ========================
+//
+//	水印图片、字体和级联分类器的内容hash,这些数据不在JSON参数中
+//	opts		*ImgOptions	可选参数
+//	返回值		[]string	按参数顺序的hash,未使用外部数据时为空字符串
+//	返回值		error		错误信息,使用内存中的水印图片时不可缓存
+func imgAssetHashes(opts *ImgOptions) ([]string, error) {
+	items := []interface{}{opts.Watermark, opts.Face}
+	for _, text := range opts.Texts {
+		items = append(items, text)
+	}
+	for _, p := range append(append([]Processor{}, opts.SourceProcessors...), opts.Processors...) {
+		if vp, ok := p.(*VariantProcessor); ok {
+			p = vp.Processor
+		}
+		items = append(items, p)
+	}
+	hashes := []string{}
+	for _, item := range items {
+		h, err := assetHash(item)
+		if err != nil {
+			return nil, err
+		}
+		hashes = append(hashes, h)
+	}
+	return hashes, nil
+}
+
+// ========================
+//
+//	单个参数引用的外部数据的内容hash
+//	item		interface{}	水印、文字、人脸检测参数或处理器
+//	返回值		string		内容hash,未使用外部数据时为空字符串
+//	返回值		error		错误信息,使用内存中的水印图片时不可缓存
+func assetHash(item interface{}) (string, error) {
+	switch v := item.(type) {
+	case *WatermarkOptions:
+		if v == nil {
+			return "", nil
+		}
+		if v.Image != nil {
+			return "", errors.New("watermark image is not cacheable")
+		}
+		if v.Path != "" {
+			return fileHash(v.Path)
+		}
+	case *TextOptions:
+		if v == nil {
+			return "", nil
+		}
+		if v.FontData != nil {
+			return bytesHash(v.FontData), nil
+		}
+		if v.FontPath != "" {
+			return fileHash(v.FontPath)
+		}
+	case *FaceOptions:
+		if v != nil && v.Cascade != nil {
+			return bytesHash(v.Cascade), nil
+		}
+	}
+	return "", nil
+}
+
+func bytesHash(data []byte) string {
+	sum := sha256.Sum256(data)
+	return hex.EncodeToString(sum[:])
+}
+
+// ========================
+//
+//	计算视频处理的缓存key
+//	path		string		原视频路径
+//	formats		[]string	视频格式
+//	maxWHs		[]MediaWH	视频宽高
+//	codeRate	int		视频码率
+//	opts		*VideoOptions	可选参数
+//	返回值		string		缓存key
+//	返回值		error		错误信息,使用内存中的水印图片时不可缓存
+func videoCacheKey(path string, formats []string, maxWHs []MediaWH, codeRate int, opts *VideoOptions) (string, error) {
+	watermark, err := assetHash(opts.Watermark)
+	if err != nil {
+		return "", err
+	}
+	// 命名模板只影响保存路径,命中缓存时按模板重新生成
+	normalized := *opts
+	normalized.Naming = ""
+	return CacheKey(path, struct {
+		Kind      string
+		Formats   []string
+		MaxWHs    []MediaWH
+		CodeRate  int
+		Options   *VideoOptions
+		Watermark string
+	}{"video", formats, maxWHs, codeRate, &normalized, watermark})
+}
+
+// CacheKeyer 自定义处理器实现CacheKey后才会使用缓存,返回值需包含所有影响处理结果的参数
+type CacheKeyer interface {
+	CacheKey() string
+}
+
+// ========================
+//
+//	将处理器转换为可比较的字符串,内置处理器使用JSON参数,
+//	自定义处理器的未导出字段无法序列化,需要实现CacheKeyer
+//	ps			[]Processor	处理器
+//	返回值		[]string	类型和参数
+//	返回值		error		处理器没有稳定的key时的错误
+func processorKeys(ps []Processor) ([]string, error) {
+	keys := []string{}
+	for _, p := range ps {
+		key, err := processorKey(p)
+		if err != nil {
+			return nil, err
+		}
+		keys = append(keys, key)
+	}
+	return keys, nil
+}
+
+func processorKey(p Processor) (string, error) {
+	if k, ok := p.(CacheKeyer); ok {
+		return fmt.Sprintf("%T:%s", p, k.CacheKey()), nil
+	}
+	switch v := p.(type) {
+	case *VariantProcessor:
+		inner, err := processorKey(v.Processor)
+		if err != nil {
+			return "", err
+		}
+		filter, err := json.Marshal(v.VariantFilter)
+		if err != nil {
+			return "", err
+		}
+		return fmt.Sprintf("%T%s%s", p, filter, inner), nil
+	case *RotateProcessor, *FlipProcessor, *CropProcessor, *ResizeProcessor, *AdjustProcessor,
+		*SharpenProcessor, *BlurProcessor, *WatermarkOptions, *TrimOptions, *TextOptions:
+		data, err := json.Marshal(p)
+		if err != nil {
+			return "", fmt.Errorf("processor %T is not cacheable: %w", p, err)
+		}
+		return fmt.Sprintf("%T%s", p, data), nil
+	}
+	return "", fmt.Errorf("processor %T is not cacheable: no CacheKey method", p)
+}
+
+// cacheEntry 缓存的处理结果,文件内容按序号单独缓存
+type cacheEntry struct {
+	Sizes   []string          `json:"sizes"`
+	Formats []string          `json:"formats"`
+	Files   []ResizeResult    `json:"files"`
+	Faces   []image.Rectangle `json:"faces,omitempty"`
+	Trim    *image.Rectangle  `json:"trim,omitempty"`
+}
+
+func cacheFileKey(key string, i int) string {
+	return key + "-" + strconv.Itoa(i)
+}
+
+// ========================
+//
+//	从缓存读取处理结果并写入文件
+//	cache		Cache		缓存
+//	key			string		缓存key
+//	pathOf		func		根据尺寸和格式计算文件路径
+//	返回值		*cacheEntry	处理结果
+//	返回值		[]string	写入的文件路径
+//	返回值		error		错误信息,未命中时为ErrCacheMiss
+func loadCacheEntry(cache Cache, key string, pathOf func(size string, format string) string) (*cacheEntry, []string, error) {
+	ctx := context.Background()
+	data, err := cache.Get(ctx, key)
+	if err != nil {
+		return nil, nil, err
+	}
+	entry := &cacheEntry{}
+	if err = json.Unmarshal(data, entry); err != nil {
+		return nil, nil, err
+	}
+	paths := []string{}
+	for i, f := range entry.Files {
+		data, err := cache.Get(ctx, cacheFileKey(key, i))
+		if err != nil {
+			return nil, nil, err
+		}
+		path := pathOf(f.Size, f.Format)
+		if err = os.MkdirAll(filepath.Dir(path), os.ModePerm); err != nil {
+			return nil, nil, err
+		}
+		if err = os.WriteFile(path, data, 0o644); err != nil {
+			return nil, nil, err
+		}
+		entry.Files[i].Path = path
+		paths = append(paths, path)
+	}
+	return entry, paths, nil
+}
+
+// ========================
+//
+//	缓存处理结果,最后写入结果信息,避免读取到不完整的缓存
+//	cache		Cache		缓存
+//	key			string		缓存key
+//	entry		*cacheEntry	处理结果
+//	返回值		error		错误信息
+func storeCacheEntry(cache Cache, key string, entry *cacheEntry) error {
+	ctx := context.Background()
+	for i, f := range entry.Files {
+		data, err := os.ReadFile(f.Path)
+		if err != nil {
+			return err
+		}
+		if err = cache.Set(ctx, cacheFileKey(key, i), data); err != nil {
+			return err
+		}
+	}
+	data, err := json.Marshal(entry)
+	if err != nil {
+		return err
+	}
+	return cache.Set(ctx, key, data)
+}
+
+// MemoryCache 内存LRU缓存
+type MemoryCache struct {
+	MaxBytes int64 //最大缓存字节数,0为不限制
+
+	mu    sync.Mutex
+	size  int64
+	ll    *list.List
+	items map[string]*list.Element
+}
+
+// memoryItem 内存缓存项
+type memoryItem struct {
+	key  string
+	data []byte
+}
+
+// ========================
+//
+//	创建内存LRU缓存
+//	maxBytes	int64		最大缓存字节数,0为不限制
+//	返回值		*MemoryCache	缓存
+func NewMemoryCache(maxBytes int64) *MemoryCache {
+	return &MemoryCache{MaxBytes: maxBytes, ll: list.New(), items: map[string]*list.Element{}}
+}
+
+func (c *MemoryCache) Get(ctx context.Context, key string) ([]byte, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	e, ok := c.items[key]
+	if !ok {
+		return nil, ErrCacheMiss
+	}
+	c.ll.MoveToFront(e)
+	return e.Value.(*memoryItem).data, nil
+}
+
+func (c *MemoryCache) Set(ctx context.Context, key string, data []byte) error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.MaxBytes > 0 && int64(len(data)) > c.MaxBytes {
+		return nil
+	}
+	if c.items == nil {
+		c.ll, c.items = list.New(), map[string]*list.Element{}
+	}
+	if e, ok := c.items[key]; ok {
+		item := e.Value.(*memoryItem)
+		c.size += int64(len(data) - len(item.data))
+		item.data = data
+		c.ll.MoveToFront(e)
+	} else {
+		c.items[key] = c.ll.PushFront(&memoryItem{key: key, data: data})
+		c.size += int64(len(data))
+	}
+	for c.MaxBytes > 0 && c.size > c.MaxBytes {
+		e := c.ll.Back()
+		item := e.Value.(*memoryItem)
+		c.ll.Remove(e)
+		delete(c.items, item.key)
+		c.size -= int64(len(item.data))
+	}
+	return nil
+}
+
+// ========================
+//
+//	缓存的总字节数
+//	返回值		int64		字节数
+func (c *MemoryCache) Size() int64 {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.size
+}
+
+// DiskCache 本地目录缓存,超过大小限制时按最近访问时间淘汰
+type DiskCache struct {
+	Dir      string //缓存目录
+	MaxBytes int64  //最大缓存字节数,0为不限制
+
+	mu     sync.Mutex
+	size   int64
+	loaded bool
+}
+
+// ========================
+//
+//	创建本地目录缓存
+//	dir			string		缓存目录
+//	maxBytes	int64		最大缓存字节数,0为不限制
+//	返回值		*DiskCache	缓存
+func NewDiskCache(dir string, maxBytes int64) *DiskCache {
+	return &DiskCache{Dir: dir, MaxBytes: maxBytes}
+}
+
+func (c *DiskCache) path(key string) string {
+	if len(key) > 2 {
+		return filepath.Join(c.Dir, key[:2], key)
+	}
+	return filepath.Join(c.Dir, key)
+}
+
+func (c *DiskCache) Get(ctx context.Context, key string) ([]byte, error) {
+	path := c.path(key)
+	data, err := os.ReadFile(path)
+	if errors.Is(err, fs.ErrNotExist) {
+		return nil, ErrCacheMiss
+	}
+	if err != nil {
+		return nil, err
+	}
+	// 修改时间作为最近访问时间
+	now := time.Now()
+	os.Chtimes(path, now, now)
+	return data, nil
+}
+
+func (c *DiskCache) Set(ctx context.Context, key string, data []byte) error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if err := c.load(); err != nil {
+		return err
+	}
+	path := c.path(key)
+	if err := os.MkdirAll(filepath.Dir(path), os.ModePerm); err != nil {
+		return err
+	}
+	var old int64
+	if info, err := os.Stat(path); err == nil {
+		old = info.Size()
+	}
+	tmp, err := os.CreateTemp(filepath.Dir(path), ".set-*")
+	if err != nil {
+		return err
+	}
+	_, err = tmp.Write(data)
+	if cerr := tmp.Close(); err == nil {
+		err = cerr
+	}
+	if err == nil {
+		err = os.Rename(tmp.Name(), path)
+	}
+	if err != nil {
+		os.Remove(tmp.Name())
+		return err
+	}
+	c.size += int64(len(data)) - old
+	if c.MaxBytes > 0 && c.size > c.MaxBytes {
+		return c.evict()
+	}
+	return nil
+}
+
+// ========================
+//
+//	缓存的总字节数
+//	返回值		int64		字节数
+//	返回值		error		错误信息
+func (c *DiskCache) Size() (int64, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	err := c.load()
+	return c.size, err
+}
+
+// 首次使用时统计缓存目录大小
+func (c *DiskCache) load() error {
+	if c.loaded {
+		return nil
+	}
+	files, err := c.files()
+	if err != nil {
+		return err
+	}
+	c.size = 0
+	for _, f := range files {
+		c.size += f.size
+	}
+	c.loaded = true
+	return nil
+}
+
+// diskFile 缓存文件信息
+type diskFile struct {
+	path    string
+	size    int64
+	modTime time.Time
+}
+
+func (c *DiskCache) files() ([]diskFile, error) {
+	files := []diskFile{}
+	err := filepath.WalkDir(c.Dir, func(path string, d fs.DirEntry, err error) error {
+		if err != nil {
+			if errors.Is(err, fs.ErrNotExist) {
+				return nil
+			}
+			return err
+		}
+		if d.IsDir() {
+			return nil
+		}
+		info, err := d.Info()
+		if err != nil {
+			return nil
+		}
+		files = append(files, diskFile{path: path, size: info.Size(), modTime: info.ModTime()})
+		return nil
+	})
+	return files, err
+}
+
+// 删除最久未访问的文件,直到不超过大小限制
+func (c *DiskCache) evict() error {
+	files, err := c.files()
+	if err != nil {
+		return err
+	}
+	sort.Slice(files, func(i, j int) bool { return files[i].modTime.Before(files[j].modTime) })
+	c.size = 0
+	for _, f := range files {
+		c.size += f.size
+	}
+	for _, f := range files {
+		if c.size <= c.MaxBytes {
+			break
+		}
+		if err := os.Remove(f.path); err != nil && !errors.Is(err, fs.ErrNotExist) {
+			return err
+		}
+		c.size -= f.size
+	}
+	return nil
+}
+
+// StorageCache 使用Storage的缓存
+type StorageCache struct {
+	Storage Storage //存储
+	Prefix  string  //key前缀,如 cache/
+}
+
+// ========================
+//
+//	创建使用Storage的缓存
+//	storage		Storage		存储
+//	prefix		string		key前缀
+//	返回值		*StorageCache	缓存
+func NewStorageCache(storage Storage, prefix string) *StorageCache {
+	return &StorageCache{Storage: storage, Prefix: prefix}
+}
+
+func (c *StorageCache) Get(ctx context.Context, key string) ([]byte, error) {
+	rc, err := c.Storage.Get(ctx, c.Prefix+key)
+	if errors.Is(err, fs.ErrNotExist) {
+		return nil, ErrCacheMiss
+	}
+	if err != nil {
+		return nil, err
+	}
+	defer rc.Close()
+	return io.ReadAll(rc)
+}
+
+func (c *StorageCache) Set(ctx context.Context, key string, data []byte) error {
+	return c.Storage.Put(ctx, c.Prefix+key, bytes.NewReader(data))
+}
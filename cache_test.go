@@ -0,0 +1,253 @@
+package mediaResize
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"image"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+// countingProcessor 记录执行次数的处理器
+type countingProcessor struct {
+	n *int
+}
+
+func (p countingProcessor) Apply(img image.Image) (image.Image, error) {
+	*p.n++
+	return img, nil
+}
+
+func (p countingProcessor) CacheKey() string {
+	return "counting"
+}
+
+// levelProcessor 只有未导出字段的处理器
+type levelProcessor struct {
+	level int
+}
+
+func (p levelProcessor) Apply(img image.Image) (image.Image, error) {
+	return img, nil
+}
+
+func TestProcessorKeys(t *testing.T) {
+	if _, err := processorKeys([]Processor{levelProcessor{1}}); err == nil {
+		t.Fatal("processor without CacheKey should not be cacheable")
+	}
+	if _, err := processorKeys([]Processor{&VariantProcessor{Processor: levelProcessor{1}}}); err == nil {
+		t.Fatal("wrapped processor without CacheKey should not be cacheable")
+	}
+	a, err := processorKeys([]Processor{&BlurProcessor{Sigma: 1}, &VariantProcessor{Processor: countingProcessor{}, VariantFilter: VariantFilter{Sizes: []string{"S"}}}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	b, err := processorKeys([]Processor{&BlurProcessor{Sigma: 2}, &VariantProcessor{Processor: countingProcessor{}, VariantFilter: VariantFilter{Sizes: []string{"S"}}}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if a[0] == b[0] || a[1] != b[1] {
+		t.Fatalf("keys = %v, %v", a, b)
+	}
+}
+
+func TestMemoryCache(t *testing.T) {
+	ctx := context.Background()
+	c := NewMemoryCache(10)
+	c.Set(ctx, "a", []byte("1234"))
+	c.Set(ctx, "b", []byte("1234"))
+	c.Get(ctx, "a")
+	c.Set(ctx, "c", []byte("1234"))
+	if _, err := c.Get(ctx, "b"); !errors.Is(err, ErrCacheMiss) {
+		t.Error("least recently used key should be evicted")
+	}
+	if data, err := c.Get(ctx, "a"); err != nil || string(data) != "1234" {
+		t.Error("Get a:", string(data), err)
+	}
+	if c.Size() != 8 {
+		t.Error("size:", c.Size())
+	}
+}
+
+func TestDiskCache(t *testing.T) {
+	ctx := context.Background()
+	c := NewDiskCache(t.TempDir(), 10)
+	old := time.Now().Add(-time.Hour)
+	for i, key := range []string{"aaaa", "bbbb"} {
+		if err := c.Set(ctx, key, []byte("1234")); err != nil {
+			t.Fatal(err)
+		}
+		os.Chtimes(c.path(key), old.Add(time.Duration(i)*time.Minute), old.Add(time.Duration(i)*time.Minute))
+	}
+	c.Get(ctx, "aaaa")
+	if err := c.Set(ctx, "cccc", []byte("1234")); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := c.Get(ctx, "bbbb"); !errors.Is(err, ErrCacheMiss) {
+		t.Error("least recently used key should be evicted")
+	}
+	if _, err := c.Get(ctx, "aaaa"); err != nil {
+		t.Error("Get aaaa:", err)
+	}
+	if size, _ := c.Size(); size != 8 {
+		t.Error("size:", size)
+	}
+}
+
+func TestImgResizeCache(t *testing.T) {
+	dir := t.TempDir()
+	src := filepath.Join(dir, "a.png")
+	newTestImage(t, src, 200, 100)
+	n := 0
+	for name, cache := range map[string]Cache{
+		"memory":  NewMemoryCache(0),
+		"disk":    NewDiskCache(filepath.Join(dir, "cache"), 0),
+		"storage": NewStorageCache(NewDirStorage(filepath.Join(dir, "storage")), "cache/"),
+	} {
+		n = 0
+		opts := &ImgOptions{Cache: cache, Processors: []Processor{countingProcessor{&n}}, Naming: "{name}." + name + ".{size}.{format}"}
+		first, err := ImgResizeWithOptions(src, filepath.Join(dir, "a.png"), []string{"webp"}, []MediaWH{{Width: 50, Height: 50}}, -1, false, opts)
+		if err != nil {
+			t.Fatal(err)
+		}
+		data, _ := os.ReadFile(first.Paths[0])
+		for _, p := range first.Paths {
+			os.Remove(p)
+		}
+		second, err := ImgResizeWithOptions(src, filepath.Join(dir, "a.png"), []string{"webp"}, []MediaWH{{Width: 50, Height: 50}}, -1, false, opts)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if n != 1 {
+			t.Errorf("%s: processed %d times, want 1", name, n)
+		}
+		if fmt.Sprint(first) != fmt.Sprint(second) {
+			t.Errorf("%s: cached result %v, want %v", name, second, first)
+		}
+		if cached, _ := os.ReadFile(second.Paths[0]); !bytes.Equal(data, cached) {
+			t.Errorf("%s: cached file differs", name)
+		}
+	}
+
+	// 参数不同时不使用缓存
+	cache := NewMemoryCache(0)
+	n = 0
+	for _, q := range []int{50, 60} {
+		opts := &ImgOptions{Cache: cache, Processors: []Processor{countingProcessor{&n}}}
+		if _, err := ImgResizeWithOptions(src, filepath.Join(dir, "b.png"), []string{"jpg"}, []MediaWH{{Width: 50, Height: 50}}, q, false, opts); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if n != 2 {
+		t.Error("different quality should not hit cache")
+	}
+}
+
+func TestImgCacheKeyAssets(t *testing.T) {
+	dir := t.TempDir()
+	src := filepath.Join(dir, "a.png")
+	newTestImage(t, src, 100, 100)
+	mark := filepath.Join(dir, "mark.png")
+	font := filepath.Join(dir, "font.ttf")
+	key := func(opts *ImgOptions) string {
+		k, err := imgCacheKey(src, []string{"png"}, []MediaWH{{Width: 50, Height: 50}}, -1, opts)
+		if err != nil {
+			t.Fatal("imgCacheKey failed:", err)
+		}
+		return k
+	}
+
+	// 字体和级联分类器的数据不在JSON中,按内容计算
+	if key(&ImgOptions{Texts: []*TextOptions{{Text: "a", FontData: []byte("font-1")}}}) == key(&ImgOptions{Texts: []*TextOptions{{Text: "a", FontData: []byte("font-2")}}}) {
+		t.Error("FontData should change the key")
+	}
+	if key(&ImgOptions{Face: &FaceOptions{Cascade: []byte("cascade-1")}}) == key(&ImgOptions{Face: &FaceOptions{Cascade: []byte("cascade-2")}}) {
+		t.Error("Cascade should change the key")
+	}
+	if key(&ImgOptions{Face: &FaceOptions{Cascade: []byte("cascade-1")}}) != key(&ImgOptions{Face: &FaceOptions{Cascade: []byte("cascade-1")}}) {
+		t.Error("same Cascade should give the same key")
+	}
+
+	// 相同路径的文件内容变化时key变化
+	keys := map[string]bool{}
+	for _, content := range []string{"v1", "v2"} {
+		os.WriteFile(mark, []byte(content), 0o644)
+		os.WriteFile(font, []byte(content), 0o644)
+		keys[key(&ImgOptions{Watermark: &WatermarkOptions{Path: mark}})] = true
+		keys[key(&ImgOptions{Texts: []*TextOptions{{Text: "a", FontPath: font}}})] = true
+		keys[key(&ImgOptions{Processors: []Processor{&VariantProcessor{Processor: &WatermarkOptions{Path: mark}}}})] = true
+	}
+	if len(keys) != 6 {
+		t.Errorf("file contents should change the key, got %d distinct keys", len(keys))
+	}
+
+	if _, err := imgCacheKey(src, []string{"png"}, nil, -1, &ImgOptions{Processors: []Processor{&WatermarkOptions{Image: image.NewNRGBA(image.Rect(0, 0, 1, 1))}}}); err == nil {
+		t.Error("watermark processor with an in-memory image should not be cacheable")
+	}
+}
+
+func TestHandlerCache(t *testing.T) {
+	root := t.TempDir()
+	newTestImage(t, filepath.Join(root, "a.png"), 200, 100)
+	h := NewHandler(NewDirStorage(root))
+	cache := NewMemoryCache(0)
+	h.Cache = cache
+	srv := httptest.NewServer(h)
+	defer srv.Close()
+	bodies := [][]byte{}
+	for i := 0; i < 2; i++ {
+		resp, err := http.Get(srv.URL + "/w_50,f_webp/a.png")
+		if err != nil {
+			t.Fatal(err)
+		}
+		var buf bytes.Buffer
+		buf.ReadFrom(resp.Body)
+		resp.Body.Close()
+		bodies = append(bodies, buf.Bytes())
+	}
+	if cache.Size() == 0 || !bytes.Equal(bodies[0], bodies[1]) {
+		t.Error("handler should store and reuse cached variants")
+	}
+}
+
+func TestVideoResizeCacheStaleOutput(t *testing.T) {
+	dir := t.TempDir()
+	src := filepath.Join(dir, "a.mp4")
+	newTestVideo(t, src, 320, 240, 2)
+	out := filepath.Join(dir, "b.mp4")
+	variant := variantPath(out, "S", "mp4", "")
+
+	// 已存在的旧文件不会被当作结果,参数变化时重新编码且缓存不同的文件
+	os.WriteFile(variant, []byte("stale"), 0o644)
+	cache := NewMemoryCache(0)
+	outputs := [][]byte{}
+	for _, rate := range []int{100, 1000} {
+		if _, err := VideoResizeWithOptions(src, out, []string{"mp4"}, []MediaWH{{Width: 160, Height: 160}}, rate, false, &VideoOptions{Cache: cache}); err != nil {
+			t.Fatal(err)
+		}
+		data, err := os.ReadFile(variant)
+		if err != nil {
+			t.Fatal(err)
+		}
+		outputs = append(outputs, data)
+	}
+	if bytes.Equal(outputs[0], []byte("stale")) || bytes.Equal(outputs[0], outputs[1]) {
+		t.Error("existing output should not be reused for a different cache key")
+	}
+	if _, err := ProbeMedia(context.Background(), variant); err != nil {
+		t.Error("ProbeMedia:", err)
+	}
+	entries, _ := os.ReadDir(dir)
+	for _, e := range entries {
+		if strings.HasPrefix(e.Name(), ".") {
+			t.Error("temporary file left behind:", e.Name())
+		}
+	}
+}
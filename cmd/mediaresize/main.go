@@ -0,0 +1,457 @@
+// mediaresize 批量缩放图片和视频
+//
+//	mediaresize [flags] <文件|目录|通配符>...
+//
+// 退出码: 0 成功, 1 有文件处理失败, 2 参数或配置错误
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"io/fs"
+	"os"
+	"os/signal"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"syscall"
+
+	mediaResize "github.com/0wew0-gh/mediaResize"
+)
+
+const (
+	exitOK      = 0
+	exitFailed  = 1
+	exitUsage   = 2
+	defaultSize = "200x200,500x500,1000x1000"
+)
+
+// fileResult 单个输入文件的处理结果
+type fileResult struct {
+	Input    string   `json:"input"`
+	Type     string   `json:"type"`
+	NewPath  string   `json:"newPath"`
+	Paths    []string `json:"paths,omitempty"`
+	Sizes    []string `json:"sizes,omitempty"`
+	Formats  []string `json:"formats,omitempty"`
+	Skipped  bool     `json:"skipped,omitempty"`
+	UpToDate bool     `json:"upToDate,omitempty"`
+	Error    string   `json:"error,omitempty"`
+}
+
+type config struct {
+	recipe       string
+	sizes        string
+	formats      string
+	quality      int
+	out          string
+	naming       string
+	fit          string
+	crop         string
+	videoFormats string
+	codeRate     int
+	manifest     string
+	watch        bool
+	recursive    bool
+	dryRun       bool
+	jsonOutput   bool
+	verbose      bool
+}
+
+func main() {
+	os.Exit(run(os.Args[1:]))
+}
+
+func run(args []string) int {
+	var cfg config
+	fset := flag.NewFlagSet("mediaresize", flag.ContinueOnError)
+	fset.StringVar(&cfg.recipe, "recipe", "", "JSON/YAML配置文件,命令行参数会覆盖配置中的同名项")
+	fset.StringVar(&cfg.sizes, "sizes", "", "尺寸预设,如 S=200x200,M=500x500,R (R为原尺寸),默认为 "+defaultSize)
+	fset.StringVar(&cfg.formats, "formats", "", "图片格式,如 jpg,webp,默认为jpg")
+	fset.IntVar(&cfg.quality, "quality", 0, "图片质量(1-100),0为默认值")
+	fset.StringVar(&cfg.out, "out", "", "输出目录,默认为输入文件所在目录")
+	fset.StringVar(&cfg.naming, "naming", "", "命名模板,可使用{name} {ext} {size} {format},同时用于图片和视频")
+	fset.StringVar(&cfg.fit, "fit", "", "缩放方式: cover")
+	fset.StringVar(&cfg.crop, "crop", "", "裁剪策略: smart, face")
+	fset.StringVar(&cfg.videoFormats, "video-formats", "", "视频格式,如 mp4,为空时跳过视频")
+	fset.IntVar(&cfg.codeRate, "code-rate", 0, "视频码率(k),0为默认值")
+	fset.StringVar(&cfg.manifest, "manifest", "", "增量处理记录文件,跳过原图和参数均未变化的图片")
+	fset.BoolVar(&cfg.watch, "watch", false, "监听目录,处理新增或修改的文件,Ctrl+C退出")
+	fset.BoolVar(&cfg.recursive, "r", false, "递归处理目录")
+	fset.BoolVar(&cfg.dryRun, "dry-run", false, "只列出将要处理的文件")
+	fset.BoolVar(&cfg.jsonOutput, "json", false, "以JSON格式输出结果")
+	fset.BoolVar(&cfg.verbose, "v", false, "打印处理信息")
+	fset.Usage = func() {
+		fmt.Fprintln(fset.Output(), "usage: mediaresize [flags] <file|dir|glob>...")
+		fset.PrintDefaults()
+	}
+	if err := fset.Parse(args); err != nil {
+		return exitUsage
+	}
+
+	recipe, err := buildRecipe(cfg)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		return exitUsage
+	}
+	spec, err := recipe.Spec()
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		return exitUsage
+	}
+
+	patterns := fset.Args()
+	if len(patterns) == 0 {
+		patterns = recipe.Inputs
+	}
+	if len(patterns) == 0 {
+		fset.Usage()
+		return exitUsage
+	}
+
+	var manifest *mediaResize.Manifest
+	if cfg.manifest != "" && !cfg.dryRun {
+		if manifest, err = mediaResize.LoadManifest(cfg.manifest); err != nil {
+			fmt.Fprintln(os.Stderr, err)
+			return exitUsage
+		}
+	}
+
+	if cfg.watch {
+		return watch(patterns, cfg, spec, manifest)
+	}
+	inputs, err := expandInputs(patterns, cfg.recursive)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		return exitUsage
+	}
+
+	results := []*fileResult{}
+	failed := false
+	for _, in := range inputs {
+		res := process(in, cfg, spec, manifest)
+		if res.Error != "" {
+			failed = true
+		}
+		results = append(results, res)
+		if !cfg.jsonOutput {
+			printResult(res, cfg.dryRun)
+		}
+	}
+	if manifest != nil {
+		if err = manifest.Save(); err != nil {
+			fmt.Fprintln(os.Stderr, err)
+			failed = true
+		}
+	}
+	if cfg.jsonOutput {
+		enc := json.NewEncoder(os.Stdout)
+		enc.SetIndent("", "  ")
+		enc.Encode(results)
+	}
+	if failed {
+		return exitFailed
+	}
+	return exitOK
+}
+
+// ========================
+//
+//	读取配置文件并使用命令行参数覆盖
+//	cfg			config				命令行参数
+//	返回值		*mediaResize.Recipe	配置
+//	返回值		error				错误信息
+func buildRecipe(cfg config) (*mediaResize.Recipe, error) {
+	recipe := &mediaResize.Recipe{}
+	if cfg.recipe != "" {
+		r, err := mediaResize.LoadRecipeFile(cfg.recipe)
+		if err != nil {
+			return nil, err
+		}
+		recipe = r
+	}
+	if cfg.sizes != "" || len(recipe.Sizes) == 0 {
+		sizes := cfg.sizes
+		if sizes == "" {
+			sizes = defaultSize
+		}
+		presets, err := parseSizes(sizes)
+		if err != nil {
+			return nil, err
+		}
+		recipe.Sizes = presets
+	}
+	if cfg.formats != "" || len(recipe.Formats) == 0 {
+		formats := cfg.formats
+		if formats == "" {
+			formats = "jpg"
+		}
+		recipe.Formats = nil
+		for _, f := range splitList(formats) {
+			recipe.Formats = append(recipe.Formats, mediaResize.RecipeFormat{Format: f})
+		}
+	}
+	if cfg.quality != 0 {
+		recipe.Quality = cfg.quality
+	}
+	if cfg.naming != "" {
+		recipe.Naming = cfg.naming
+	}
+	if recipe.Naming == "" {
+		// 默认命名与ImgResize相同,但不受目录中"."的影响,图片和视频使用相同的模板
+		recipe.Naming = "{name}.{size}.{format}"
+	}
+	if cfg.fit != "" {
+		recipe.Fit = cfg.fit
+	}
+	if cfg.crop != "" {
+		recipe.Crop = cfg.crop
+	}
+	if cfg.videoFormats != "" {
+		if recipe.Video == nil {
+			recipe.Video = &mediaResize.RecipeVideo{}
+		}
+		recipe.Video.Formats = splitList(cfg.videoFormats)
+	}
+	if cfg.codeRate != 0 {
+		if recipe.Video == nil || len(recipe.Video.Formats) == 0 {
+			return nil, errors.New("-code-rate requires -video-formats or video.formats in the recipe")
+		}
+		recipe.Video.CodeRate = cfg.codeRate
+	}
+
+	// 重新校验命令行参数合并后的配置
+	data, err := recipe.JSON()
+	if err != nil {
+		return nil, err
+	}
+	merged, err := mediaResize.LoadRecipe(data)
+	if err != nil {
+		return nil, fmt.Errorf("invalid options:\n%w", err)
+	}
+	return merged, nil
+}
+
+// ========================
+//
+//	解析尺寸预设
+//	s			string				如 S=200x200,M=500x500,R
+//	返回值		[]mediaResize.SizePreset	尺寸预设
+//	返回值		error				错误信息
+func parseSizes(s string) ([]mediaResize.SizePreset, error) {
+	presets := []mediaResize.SizePreset{}
+	for _, item := range splitList(s) {
+		preset := mediaResize.SizePreset{}
+		wh := item
+		if name, v, ok := strings.Cut(item, "="); ok {
+			preset.Name, wh = name, v
+		}
+		if strings.EqualFold(wh, "R") {
+			preset.Width, preset.Height = -1, -1
+			presets = append(presets, preset)
+			continue
+		}
+		w, h, ok := strings.Cut(strings.ToLower(wh), "x")
+		if !ok {
+			return nil, fmt.Errorf("invalid size %q, use WxH", item)
+		}
+		var err error
+		if preset.Width, err = strconv.Atoi(w); err != nil {
+			return nil, fmt.Errorf("invalid size %q: %w", item, err)
+		}
+		if preset.Height, err = strconv.Atoi(h); err != nil {
+			return nil, fmt.Errorf("invalid size %q: %w", item, err)
+		}
+		presets = append(presets, preset)
+	}
+	return presets, nil
+}
+
+func splitList(s string) []string {
+	list := []string{}
+	for _, v := range strings.Split(s, ",") {
+		if v = strings.TrimSpace(v); v != "" {
+			list = append(list, v)
+		}
+	}
+	return list
+}
+
+// input 输入文件及其相对于输入目录的路径
+type input struct {
+	path string
+	rel  string
+}
+
+// ========================
+//
+//	展开文件、目录和通配符
+//	patterns	[]string	文件、目录或通配符
+//	recursive	bool		是否递归处理目录
+//	返回值		[]input		输入文件
+//	返回值		error		错误信息
+func expandInputs(patterns []string, recursive bool) ([]input, error) {
+	inputs := []input{}
+	seen := map[string]bool{}
+	add := func(path string, rel string) {
+		if !seen[path] {
+			seen[path] = true
+			inputs = append(inputs, input{path: path, rel: rel})
+		}
+	}
+	for _, pattern := range patterns {
+		matches, err := filepath.Glob(pattern)
+		if err != nil {
+			return nil, err
+		}
+		if len(matches) == 0 {
+			return nil, fmt.Errorf("no such file: %s", pattern)
+		}
+		for _, match := range matches {
+			info, err := os.Stat(match)
+			if err != nil {
+				return nil, err
+			}
+			if !info.IsDir() {
+				add(match, filepath.Base(match))
+				continue
+			}
+			err = filepath.WalkDir(match, func(path string, d fs.DirEntry, err error) error {
+				if err != nil {
+					return err
+				}
+				if d.IsDir() {
+					if path != match && !recursive {
+						return filepath.SkipDir
+					}
+					return nil
+				}
+				rel, err := filepath.Rel(match, path)
+				if err != nil {
+					return err
+				}
+				add(path, rel)
+				return nil
+			})
+			if err != nil {
+				return nil, err
+			}
+		}
+	}
+	return inputs, nil
+}
+
+// ========================
+//
+//	处理单个文件
+//	in			input				输入文件
+//	cfg			config				命令行参数
+//	spec		*mediaResize.ResizeSpec	处理参数
+//	manifest	*mediaResize.Manifest	增量处理记录,为nil时总是处理
+//	返回值		*fileResult			处理结果
+func process(in input, cfg config, spec *mediaResize.ResizeSpec, manifest *mediaResize.Manifest) *fileResult {
+	res := &fileResult{Input: in.path, NewPath: in.path}
+	if cfg.out != "" {
+		res.NewPath = filepath.Join(cfg.out, in.rel)
+	}
+	contentType, err := mediaResize.DetectContentType(in.path)
+	if err != nil {
+		res.Error = err.Error()
+		return res
+	}
+	res.Type = strings.SplitN(contentType, "/", 2)[0]
+	if (res.Type != "image" && res.Type != "video") || (res.Type == "video" && len(spec.VideoFormats) == 0) {
+		res.Skipped = true
+		return res
+	}
+	if cfg.dryRun {
+		return res
+	}
+	if err = os.MkdirAll(filepath.Dir(res.NewPath), os.ModePerm); err != nil {
+		res.Error = err.Error()
+		return res
+	}
+
+	if res.Type == "image" {
+		resize := mediaResize.ImgResizeWithOptions
+		if manifest != nil {
+			resize = manifest.ImgResize
+		}
+		r, err := resize(in.path, res.NewPath, spec.Formats, spec.MaxWHs, spec.Quality, cfg.verbose, spec.Image)
+		res.Paths, res.Sizes, res.Formats, res.UpToDate = r.Paths, r.Sizes, r.Formats, r.Skipped
+		if err != nil {
+			res.Error = err.Error()
+		}
+		return res
+	}
+	r, err := mediaResize.VideoResizeWithOptions(in.path, res.NewPath, spec.VideoFormats, spec.MaxWHs, spec.CodeRate, cfg.verbose, spec.Video)
+	res.Paths, res.Sizes, res.Formats = r.Paths, r.Sizes, r.Formats
+	if err != nil {
+		res.Error = err.Error()
+	}
+	return res
+}
+
+// ========================
+//
+//	监听目录并处理新增或修改的文件,收到中断信号时退出
+//	patterns	[]string			监听目录,只能有一个
+//	cfg			config				命令行参数
+//	spec		*mediaResize.ResizeSpec	处理参数
+//	manifest	*mediaResize.Manifest	增量处理记录
+//	返回值		int				退出码
+func watch(patterns []string, cfg config, spec *mediaResize.ResizeSpec, manifest *mediaResize.Manifest) int {
+	if len(patterns) != 1 {
+		fmt.Fprintln(os.Stderr, "-watch requires exactly one directory")
+		return exitUsage
+	}
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+	err := mediaResize.Watch(ctx, &mediaResize.WatchOptions{
+		Dir:       patterns[0],
+		OutDir:    cfg.out,
+		Recursive: cfg.recursive,
+		Spec:      spec,
+		Manifest:  manifest,
+		IsPrint:   cfg.verbose,
+		OnResult: func(r *mediaResize.WatchResult) {
+			res := &fileResult{Input: r.Path, Type: r.Type, NewPath: r.NewPath}
+			if r.Image != nil {
+				res.Paths, res.Sizes, res.Formats, res.UpToDate = r.Image.Paths, r.Image.Sizes, r.Image.Formats, r.Image.Skipped
+			} else if r.Video != nil {
+				res.Paths, res.Sizes, res.Formats = r.Video.Paths, r.Video.Sizes, r.Video.Formats
+			}
+			if r.Err != nil {
+				res.Error = r.Err.Error()
+			}
+			if cfg.jsonOutput {
+				json.NewEncoder(os.Stdout).Encode(res)
+			} else {
+				printResult(res, false)
+			}
+		},
+	})
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		return exitUsage
+	}
+	return exitOK
+}
+
+func printResult(res *fileResult, dryRun bool) {
+	switch {
+	case res.Error != "":
+		fmt.Fprintf(os.Stderr, "FAIL %s: %s\n", res.Input, res.Error)
+	case res.Skipped:
+		fmt.Printf("skip %s (%s)\n", res.Input, res.Type)
+	case res.UpToDate:
+		fmt.Printf("same %s\n", res.Input)
+	case dryRun:
+		fmt.Printf("plan %s -> %s\n", res.Input, res.NewPath)
+	default:
+		fmt.Printf("ok   %s -> %s\n", res.Input, strings.Join(res.Paths, ", "))
+	}
+}
@@ -0,0 +1,55 @@
+package main
+
+import (
+	"image/color"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/disintegration/imaging"
+)
+
+func TestRun(t *testing.T) {
+	dir := t.TempDir()
+	in := filepath.Join(dir, "in")
+	out := filepath.Join(dir, "out.d")
+	if err := os.MkdirAll(filepath.Join(in, "sub"), os.ModePerm); err != nil {
+		t.Fatal(err)
+	}
+	for _, p := range []string{"a.png", "sub/b.jpg"} {
+		if err := imaging.Save(imaging.New(600, 300, color.White), filepath.Join(in, p)); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := os.WriteFile(filepath.Join(in, "notes.txt"), []byte("hello"), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	if code := run([]string{"-sizes", "S=100x100,R", "-formats", "png", "-out", out, "-r", "-json", in}); code != exitOK {
+		t.Fatal("unexpected exit code:", code)
+	}
+	for _, p := range []string{"a.S.png", "a.R.png", "sub/b.S.png", "sub/b.R.png", "sub/b.R.jpg"} {
+		if _, err := os.Stat(filepath.Join(out, p)); err != nil {
+			t.Error("missing output:", err)
+		}
+	}
+
+	if code := run([]string{"-dry-run", "-out", filepath.Join(dir, "dry"), filepath.Join(in, "*.png")}); code != exitOK {
+		t.Error("unexpected exit code:", code)
+	}
+	if _, err := os.Stat(filepath.Join(dir, "dry")); !os.IsNotExist(err) {
+		t.Error("dry run wrote output")
+	}
+	if code := run([]string{"-sizes", "bad", in}); code != exitUsage {
+		t.Error("unexpected exit code for bad sizes:", code)
+	}
+	if code := run([]string{"-formats", "heic", in}); code != exitUsage {
+		t.Error("unexpected exit code for bad format:", code)
+	}
+	if code := run([]string{"-code-rate", "800", in}); code != exitUsage {
+		t.Error("unexpected exit code for -code-rate without video formats:", code)
+	}
+	if code := run([]string{"-out", filepath.Join(in, "a.png"), in}); code != exitFailed {
+		t.Error("unexpected exit code for unwritable output:", code)
+	}
+}
@@ -0,0 +1,70 @@
+package mediaResize
+
+import (
+	"errors"
+	"image"
+	"math"
+
+	"github.com/disintegration/imaging"
+)
+
+// ========================
+//
+//	按裁剪区域裁剪图片,并计算焦点在裁剪后图片中的坐标
+//	img			image.Image	图片
+//	region		*CropRegion	裁剪区域或焦点
+//	返回值		image.Image	裁剪后的图片
+//	返回值		*image.Point	焦点坐标,未设置焦点时为nil
+//	返回值		error		错误信息
+func applyCropRegion(img image.Image, region *CropRegion) (image.Image, *image.Point, error) {
+	b := img.Bounds()
+	var focus *image.Point
+	if region.Focus != nil {
+		if region.Focus.X < 0 || region.Focus.X > 1 || region.Focus.Y < 0 || region.Focus.Y > 1 {
+			return img, nil, errors.New("focal point out of range")
+		}
+		p := image.Pt(
+			b.Min.X+int(math.Round(region.Focus.X*float64(b.Dx()))),
+			b.Min.Y+int(math.Round(region.Focus.Y*float64(b.Dy()))),
+		)
+		focus = &p
+	}
+
+	var rect image.Rectangle
+	switch {
+	case region.Rect != nil:
+		r := region.Rect
+		rect = image.Rect(
+			int(math.Round(r.X)),
+			int(math.Round(r.Y)),
+			int(math.Round(r.X+r.Width)),
+			int(math.Round(r.Y+r.Height)),
+		).Add(b.Min)
+	case region.Relative != nil:
+		r := region.Relative
+		// 允许浮点数相加的误差,如 0.1+0.9
+		if r.X < 0 || r.Y < 0 || r.Width <= 0 || r.Height <= 0 || r.X+r.Width > 1+1e-9 || r.Y+r.Height > 1+1e-9 {
+			return img, nil, errors.New("relative crop region out of range")
+		}
+		rect = image.Rect(
+			int(math.Round(r.X*float64(b.Dx()))),
+			int(math.Round(r.Y*float64(b.Dy()))),
+			int(math.Round((r.X+r.Width)*float64(b.Dx()))),
+			int(math.Round((r.Y+r.Height)*float64(b.Dy()))),
+		).Add(b.Min)
+	default:
+		return img, focus, nil
+	}
+	rect = rect.Intersect(b)
+	if rect.Empty() {
+		return img, nil, errors.New("crop region is empty")
+	}
+
+	// imaging.Crop 返回的图片从(0,0)开始
+	newImage := imaging.Crop(img, rect)
+	if focus != nil {
+		p := focus.Sub(rect.Min)
+		focus = &p
+	}
+	return newImage, focus, nil
+}
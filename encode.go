@@ -0,0 +1,277 @@
+package mediaResize
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+// 视频码率控制方式
+const (
+	RateCRF     = "crf"     //固定质量
+	RateBitrate = "bitrate" //平均码率
+	RateVBR     = "vbr"     //受限的可变码率,需要MaxBitrate
+)
+
+// VideoEncoding 视频编码参数,为空的字段使用编码器默认值
+type VideoEncoding struct {
+	Codec           string  `json:"codec,omitempty" yaml:"codec,omitempty"`                     //视频编码: h264, hevc, vp9, av1, 为空时使用容器默认编码
+	Encoder         string  `json:"encoder,omitempty" yaml:"encoder,omitempty"`                 //ffmpeg编码器,如 libsvtav1, h264_nvenc, 为空时按Codec选择
+	RateControl     string  `json:"rateControl,omitempty" yaml:"rateControl,omitempty"`         //码率控制: crf, bitrate, vbr, 为空时设置了CRF为crf,否则为bitrate
+	CRF             int     `json:"crf,omitempty" yaml:"crf,omitempty"`                         //固定质量参数,h264/hevc为0-51,vp9/av1为0-63
+	Bitrate         int     `json:"bitrate,omitempty" yaml:"bitrate,omitempty"`                 //视频码率(k),为0时使用codeRate
+	MaxBitrate      int     `json:"maxBitrate,omitempty" yaml:"maxBitrate,omitempty"`           //最大码率(k),crf时限制峰值码率
+	BufSize         int     `json:"bufSize,omitempty" yaml:"bufSize,omitempty"`                 //码率控制缓冲区大小(k),为0时为MaxBitrate的2倍
+	Preset          string  `json:"preset,omitempty" yaml:"preset,omitempty"`                   //编码速度预设,如 medium, slow; vp9/libaom-av1为cpu-used数值
+	Profile         string  `json:"profile,omitempty" yaml:"profile,omitempty"`                 //编码profile,如 high, main
+	Level           string  `json:"level,omitempty" yaml:"level,omitempty"`                     //编码level,如 4.0
+	PixFmt          string  `json:"pixFmt,omitempty" yaml:"pixFmt,omitempty"`                   //像素格式,如 yuv420p
+	GOP             int     `json:"gop,omitempty" yaml:"gop,omitempty"`                         //关键帧最大间隔(帧)
+	KeyframeSeconds float64 `json:"keyframeSeconds,omitempty" yaml:"keyframeSeconds,omitempty"` //每隔多少秒强制插入关键帧
+	AudioCodec      string  `json:"audioCodec,omitempty" yaml:"audioCodec,omitempty"`           //音频编码: aac, opus, mp3, vorbis, copy, none, 为空时可复制则复制,否则使用容器默认编码
+	AudioBitrate    int     `json:"audioBitrate,omitempty" yaml:"audioBitrate,omitempty"`       //音频码率(k),为0时为128
+	FastStart       bool    `json:"fastStart,omitempty" yaml:"fastStart,omitempty"`             //mp4/mov将moov移到文件开头,便于边下载边播放
+}
+
+// videoContainer 容器支持的编码
+type videoContainer struct {
+	video        []string
+	audio        []string
+	defaultAudio string
+	fastStart    bool
+}
+
+// videoContainers 支持校验的容器,其他格式不校验编码
+var videoContainers = map[string]videoContainer{
+	"mp4":  {[]string{"h264", "hevc", "av1", "vp9"}, []string{"aac", "mp3", "opus", "ac3", "eac3", "alac", "flac"}, "aac", true},
+	"m4v":  {[]string{"h264", "hevc", "av1"}, []string{"aac", "mp3", "ac3", "eac3", "alac"}, "aac", true},
+	"mov":  {[]string{"h264", "hevc", "prores", "mjpeg"}, []string{"aac", "mp3", "ac3", "alac", "pcm_s16le"}, "aac", true},
+	"webm": {[]string{"vp8", "vp9", "av1"}, []string{"opus", "vorbis"}, "opus", false},
+	"mkv":  {nil, nil, "opus", false},
+}
+
+// videoEncoders 视频编码对应的默认ffmpeg编码器
+var videoEncoders = map[string]string{
+	"h264": "libx264",
+	"hevc": "libx265",
+	"vp9":  "libvpx-vp9",
+	"av1":  "libaom-av1",
+}
+
+// audioEncoders 音频编码对应的ffmpeg编码器
+var audioEncoders = map[string]string{
+	"aac":    "aac",
+	"opus":   "libopus",
+	"mp3":    "libmp3lame",
+	"vorbis": "libvorbis",
+}
+
+// ========================
+//
+//	校验编码参数及与容器的兼容性
+//	container	string		容器格式,即VideoResize的视频格式,如 mp4, webm
+//	返回值		error		错误信息
+func (e *VideoEncoding) Validate(container string) error {
+	container = strings.ToLower(container)
+	c, known := videoContainers[container]
+	codec := strings.ToLower(e.Codec)
+	if codec != "" {
+		if _, ok := videoEncoders[codec]; !ok && e.Encoder == "" {
+			return fmt.Errorf("unsupported video codec %q", e.Codec)
+		}
+		if known && c.video != nil && !containsString(c.video, codec) {
+			return fmt.Errorf("video codec %s is not supported in %s", codec, container)
+		}
+	}
+
+	switch e.rateControl() {
+	case RateCRF:
+		max := 51
+		if codec == "vp9" || codec == "av1" {
+			max = 63
+		}
+		if e.CRF < 0 || e.CRF > max {
+			return fmt.Errorf("crf must be between 0 and %d", max)
+		}
+	case RateBitrate:
+	case RateVBR:
+		if e.MaxBitrate <= 0 {
+			return fmt.Errorf("vbr requires maxBitrate")
+		}
+		if e.Bitrate > e.MaxBitrate {
+			return fmt.Errorf("bitrate %dk exceeds maxBitrate %dk", e.Bitrate, e.MaxBitrate)
+		}
+	default:
+		return fmt.Errorf("unknown rate control %q", e.RateControl)
+	}
+	if e.Bitrate < 0 || e.MaxBitrate < 0 || e.BufSize < 0 || e.AudioBitrate < 0 {
+		return fmt.Errorf("bitrates must be positive")
+	}
+	if e.GOP < 0 || e.KeyframeSeconds < 0 {
+		return fmt.Errorf("gop and keyframeSeconds must be positive")
+	}
+	if e.Preset != "" && e.Encoder == "" && (codec == "vp9" || codec == "av1") {
+		if _, err := strconv.Atoi(e.Preset); err != nil {
+			return fmt.Errorf("preset for %s must be a cpu-used number", codec)
+		}
+	}
+
+	audio := strings.ToLower(e.AudioCodec)
+	switch audio {
+	case "", "copy", "none":
+	default:
+		if _, ok := audioEncoders[audio]; !ok {
+			return fmt.Errorf("unsupported audio codec %q", e.AudioCodec)
+		}
+		if known && c.audio != nil && !containsString(c.audio, audio) {
+			return fmt.Errorf("audio codec %s is not supported in %s", audio, container)
+		}
+	}
+	if e.FastStart && known && !c.fastStart {
+		return fmt.Errorf("fastStart is not supported in %s", container)
+	}
+	return nil
+}
+
+// rateControl 码率控制方式,未设置时设置了CRF为crf,否则为bitrate
+func (e *VideoEncoding) rateControl() string {
+	if e.RateControl != "" {
+		return strings.ToLower(e.RateControl)
+	}
+	if e.CRF > 0 {
+		return RateCRF
+	}
+	return RateBitrate
+}
+
+// ========================
+//
+//	生成ffmpeg的视频和音频编码参数
+//	enc			*VideoEncoding	编码参数,为nil时只设置码率
+//	container	string		容器格式
+//	codeRate	int		视频码率,-1为默认值:1500k
+//	media		*MediaInfo	原视频信息,用于判断音频能否直接复制,为nil时复制音频
+//	返回值		[]string	ffmpeg参数
+//	返回值		error		错误信息
+func videoCodecArgs(enc *VideoEncoding, container string, codeRate int, media *MediaInfo) ([]string, error) {
+	if codeRate < 0 {
+		codeRate = 1500
+	}
+	if enc == nil {
+		return append([]string{"-b:v", fmt.Sprintf("%dk", codeRate)}, audioCodecArgs(nil, container, media)...), nil
+	}
+	if err := enc.Validate(container); err != nil {
+		return nil, err
+	}
+	args := []string{}
+	codec := strings.ToLower(enc.Codec)
+	encoder := enc.Encoder
+	if encoder == "" {
+		encoder = videoEncoders[codec]
+	}
+	if encoder != "" {
+		args = append(args, "-c:v", encoder)
+	}
+
+	bitrate := enc.Bitrate
+	if bitrate <= 0 {
+		bitrate = codeRate
+	}
+	bufSize := enc.BufSize
+	if bufSize <= 0 {
+		bufSize = enc.MaxBitrate * 2
+	}
+	switch enc.rateControl() {
+	case RateCRF:
+		args = append(args, "-crf", strconv.Itoa(enc.CRF))
+		if codec == "vp9" || codec == "av1" {
+			// libvpx/libaom使用固定质量时需要将码率设置为0
+			args = append(args, "-b:v", "0")
+		}
+		if enc.MaxBitrate > 0 {
+			args = append(args, "-maxrate", fmt.Sprintf("%dk", enc.MaxBitrate), "-bufsize", fmt.Sprintf("%dk", bufSize))
+		}
+	case RateBitrate:
+		args = append(args, "-b:v", fmt.Sprintf("%dk", bitrate))
+	case RateVBR:
+		if bitrate > enc.MaxBitrate {
+			bitrate = enc.MaxBitrate
+		}
+		args = append(args, "-b:v", fmt.Sprintf("%dk", bitrate), "-maxrate", fmt.Sprintf("%dk", enc.MaxBitrate), "-bufsize", fmt.Sprintf("%dk", bufSize))
+	}
+
+	if enc.Preset != "" {
+		if enc.Encoder == "" && (codec == "vp9" || codec == "av1") {
+			args = append(args, "-cpu-used", enc.Preset)
+		} else {
+			args = append(args, "-preset", enc.Preset)
+		}
+	}
+	if enc.Profile != "" {
+		args = append(args, "-profile:v", enc.Profile)
+	}
+	if enc.Level != "" {
+		args = append(args, "-level:v", enc.Level)
+	}
+	if enc.PixFmt != "" {
+		args = append(args, "-pix_fmt", enc.PixFmt)
+	}
+	if enc.GOP > 0 {
+		args = append(args, "-g", strconv.Itoa(enc.GOP))
+	}
+	if enc.KeyframeSeconds > 0 {
+		// 按时间对齐关键帧,不在场景切换处额外插入
+		args = append(args, "-force_key_frames", fmt.Sprintf("expr:gte(t,n_forced*%g)", enc.KeyframeSeconds), "-sc_threshold", "0")
+	}
+	if codec == "hevc" && (container == "mp4" || container == "m4v" || container == "mov") {
+		// Apple设备只播放hvc1标记的hevc
+		args = append(args, "-tag:v", "hvc1")
+	}
+	args = append(args, audioCodecArgs(enc, container, media)...)
+	if enc.FastStart {
+		args = append(args, "-movflags", "+faststart")
+	}
+	return args, nil
+}
+
+// ========================
+//
+//	生成ffmpeg的音频编码参数,未指定音频编码时原音频与容器兼容则复制,否则使用容器默认编码
+//	enc			*VideoEncoding	编码参数,可以为nil
+//	container	string		容器格式
+//	media		*MediaInfo	原视频信息,为nil时复制音频
+//	返回值		[]string	ffmpeg参数
+func audioCodecArgs(enc *VideoEncoding, container string, media *MediaInfo) []string {
+	audio, bitrate := "", 128
+	if enc != nil {
+		audio = strings.ToLower(enc.AudioCodec)
+		if enc.AudioBitrate > 0 {
+			bitrate = enc.AudioBitrate
+		}
+	}
+	if media != nil && len(media.Audio) == 0 {
+		return []string{"-an"}
+	}
+	switch audio {
+	case "none":
+		return []string{"-an"}
+	case "copy":
+		return []string{"-acodec", "copy"}
+	case "":
+		c, known := videoContainers[strings.ToLower(container)]
+		if media == nil || !known || c.audio == nil || containsString(c.audio, media.Audio[0].Codec) {
+			return []string{"-acodec", "copy"}
+		}
+		audio = c.defaultAudio
+	}
+	return []string{"-c:a", audioEncoders[audio], "-b:a", fmt.Sprintf("%dk", bitrate)}
+}
+
+func containsString(list []string, s string) bool {
+	for _, v := range list {
+		if v == s {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,103 @@
+package mediaResize
+
+import (
+	"context"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestVideoEncodingValidate(t *testing.T) {
+	cases := []struct {
+		enc       VideoEncoding
+		container string
+		err       string
+	}{
+		{VideoEncoding{Codec: "h264", CRF: 23, Preset: "slow", FastStart: true}, "mp4", ""},
+		{VideoEncoding{Codec: "vp9", CRF: 32, Preset: "4", AudioCodec: "opus"}, "webm", ""},
+		{VideoEncoding{Codec: "h264"}, "webm", "not supported in webm"},
+		{VideoEncoding{Codec: "vp9", AudioCodec: "aac"}, "webm", "audio codec aac"},
+		{VideoEncoding{Codec: "h265"}, "mp4", "unsupported video codec"},
+		{VideoEncoding{Codec: "h264", CRF: 60}, "mp4", "crf must be between 0 and 51"},
+		{VideoEncoding{Codec: "av1", CRF: 60}, "mkv", ""},
+		{VideoEncoding{RateControl: RateVBR, Bitrate: 2000}, "mp4", "requires maxBitrate"},
+		{VideoEncoding{RateControl: RateVBR, Bitrate: 2000, MaxBitrate: 1000}, "mp4", "exceeds maxBitrate"},
+		{VideoEncoding{RateControl: "cbr"}, "mp4", "unknown rate control"},
+		{VideoEncoding{Codec: "vp9", Preset: "slow"}, "webm", "cpu-used"},
+		{VideoEncoding{FastStart: true}, "webm", "fastStart"},
+		{VideoEncoding{Encoder: "h264_nvenc", Codec: "h264", Preset: "p5"}, "mp4", ""},
+	}
+	for i, c := range cases {
+		err := c.enc.Validate(c.container)
+		if c.err == "" && err != nil || c.err != "" && (err == nil || !strings.Contains(err.Error(), c.err)) {
+			t.Errorf("case %d: got %v, want %q", i, err, c.err)
+		}
+	}
+}
+
+func TestVideoCodecArgs(t *testing.T) {
+	aac := &MediaInfo{Audio: []AudioStream{{Codec: "aac"}}}
+	silent := &MediaInfo{}
+	cases := []struct {
+		enc       *VideoEncoding
+		container string
+		codeRate  int
+		media     *MediaInfo
+		want      string
+	}{
+		// 未设置编码参数时与原来相同
+		{nil, "mp4", -1, nil, "-b:v 1500k -acodec copy"},
+		{nil, "mp4", 800, aac, "-b:v 800k -acodec copy"},
+		// aac不能放入webm,改为opus
+		{nil, "webm", 800, aac, "-b:v 800k -c:a libopus -b:a 128k"},
+		{nil, "mp4", 800, silent, "-b:v 800k -an"},
+		{&VideoEncoding{Codec: "h264", CRF: 23, MaxBitrate: 3000, Preset: "slow", Profile: "high", Level: "4.0", PixFmt: "yuv420p", GOP: 48, FastStart: true}, "mp4", -1, aac,
+			"-c:v libx264 -crf 23 -maxrate 3000k -bufsize 6000k -preset slow -profile:v high -level:v 4.0 -pix_fmt yuv420p -g 48 -acodec copy -movflags +faststart"},
+		{&VideoEncoding{Codec: "vp9", CRF: 31, Preset: "4", AudioBitrate: 96}, "webm", -1, aac,
+			"-c:v libvpx-vp9 -crf 31 -b:v 0 -cpu-used 4 -c:a libopus -b:a 96k"},
+		{&VideoEncoding{Codec: "hevc", RateControl: RateVBR, MaxBitrate: 1000, KeyframeSeconds: 2, AudioCodec: "aac"}, "mp4", 1500, aac,
+			"-c:v libx265 -b:v 1000k -maxrate 1000k -bufsize 2000k -force_key_frames expr:gte(t,n_forced*2) -sc_threshold 0 -tag:v hvc1 -c:a aac -b:a 128k"},
+		{&VideoEncoding{Bitrate: 2500, AudioCodec: "none"}, "mkv", 1500, aac, "-b:v 2500k -an"},
+	}
+	for i, c := range cases {
+		args, err := videoCodecArgs(c.enc, c.container, c.codeRate, c.media)
+		if err != nil {
+			t.Errorf("case %d: %v", i, err)
+			continue
+		}
+		if got := strings.Join(args, " "); got != c.want {
+			t.Errorf("case %d:\n got %s\nwant %s", i, got, c.want)
+		}
+	}
+	if _, err := videoCodecArgs(&VideoEncoding{Codec: "h264"}, "webm", -1, nil); err == nil {
+		t.Error("expected container error")
+	}
+}
+
+func TestVideoResizeEncoding(t *testing.T) {
+	dir := t.TempDir()
+	src := filepath.Join(dir, "a.mp4")
+	newTestVideo(t, src, 320, 240, 1)
+
+	opts := &VideoOptions{Encoding: &VideoEncoding{Codec: "vp9", CRF: 40, Preset: "8"}}
+	res, err := VideoResizeWithOptions(src, filepath.Join(dir, "b.mp4"), []string{"webm"}, []MediaWH{{Width: 160, Height: 160}}, -1, false, opts)
+	if err != nil {
+		t.Fatal(err)
+	}
+	info, err := ProbeMedia(context.Background(), res.Paths[0])
+	if err != nil {
+		t.Fatal(err)
+	}
+	if v := info.VideoStream(); v == nil || v.Codec != "vp9" || v.Width != 160 || len(info.Audio) != 1 || info.Audio[0].Codec != "opus" {
+		t.Errorf("info: %+v", info)
+	}
+
+	// 编码参数与容器不兼容时不生成任何文件
+	opts.Encoding = &VideoEncoding{Codec: "h264"}
+	if _, err = VideoResizeWithOptions(src, filepath.Join(dir, "c.mp4"), []string{"mp4", "webm"}, []MediaWH{{Width: 160, Height: 160}}, -1, false, opts); err == nil {
+		t.Error("expected error")
+	}
+	if matches, _ := filepath.Glob(filepath.Join(dir, "c.*")); len(matches) != 0 {
+		t.Error("unexpected files:", matches)
+	}
+}
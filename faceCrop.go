@@ -0,0 +1,247 @@
+package mediaResize
+
+import (
+	_ "embed"
+	"image"
+	"math"
+	"sync"
+
+	"github.com/disintegration/imaging"
+	pigo "github.com/esimov/pigo/core"
+)
+
+// facefinder 级联分类器来自 https://github.com/esimov/pigo (MIT)
+//
+//go:embed cascade/facefinder
+var facefinderCascade []byte
+
+var (
+	faceClassifier     *pigo.Pigo
+	faceClassifierErr  error
+	faceClassifierOnce sync.Once
+)
+
+// 人脸检测时图片的最大边长,超过时先缩小再检测
+const faceDetectMaxSide = 1024
+
+// FaceOptions 人脸检测参数
+type FaceOptions struct {
+	MinSize    int     `json:"minSize,omitempty"`    //最小人脸尺寸(像素),默认为短边的1/20且不小于20
+	MaxSize    int     `json:"maxSize,omitempty"`    //最大人脸尺寸(像素),默认为短边
+	MinQuality float32 `json:"minQuality,omitempty"` //最低检测分数,默认为5
+	Cascade    []byte  `json:"-"`                    //自定义级联分类器,为nil时使用内置的facefinder
+	Fallback   string  `json:"fallback,omitempty"`   //未检测到人脸时的裁剪策略: CropCenter(默认), CropSmart
+}
+
+// ========================
+//
+//	检测图片中的人脸
+//	img			image.Image		图片
+//	opts		*FaceOptions		检测参数,为nil时使用默认值
+//	返回值		[]image.Rectangle	人脸区域(原图坐标)
+//	返回值		error			错误信息
+func DetectFaces(img image.Image, opts *FaceOptions) ([]image.Rectangle, error) {
+	if opts == nil {
+		opts = &FaceOptions{}
+	}
+	classifier, err := loadFaceClassifier(opts.Cascade)
+	if err != nil {
+		return nil, err
+	}
+
+	b := img.Bounds()
+	if b.Empty() {
+		return nil, nil
+	}
+	// 大图先缩小再检测,检测结果按比例还原
+	var src *image.NRGBA
+	if b.Dx() > faceDetectMaxSide || b.Dy() > faceDetectMaxSide {
+		src = imaging.Fit(img, faceDetectMaxSide, faceDetectMaxSide, imaging.Linear)
+	} else {
+		src = imaging.Clone(img)
+	}
+	cols, rows := src.Bounds().Dx(), src.Bounds().Dy()
+	scale := float64(b.Dx()) / float64(cols)
+
+	short := cols
+	if rows < short {
+		short = rows
+	}
+	minSize := int(float64(opts.MinSize) / scale)
+	if minSize <= 0 {
+		minSize = short / 20
+	}
+	if minSize < 20 {
+		minSize = 20
+	}
+	maxSize := int(float64(opts.MaxSize) / scale)
+	if maxSize <= 0 || maxSize > short {
+		maxSize = short
+	}
+	minQuality := opts.MinQuality
+	if minQuality <= 0 {
+		minQuality = 5
+	}
+	if minSize > maxSize {
+		return nil, nil
+	}
+
+	dets := classifier.RunCascade(pigo.CascadeParams{
+		MinSize:     minSize,
+		MaxSize:     maxSize,
+		ShiftFactor: 0.1,
+		ScaleFactor: 1.1,
+		ImageParams: pigo.ImageParams{
+			Pixels: pigo.RgbToGrayscale(src),
+			Rows:   rows,
+			Cols:   cols,
+			Dim:    cols,
+		},
+	}, 0.0)
+	dets = classifier.ClusterDetections(dets, 0.2)
+
+	faces := []image.Rectangle{}
+	for _, d := range dets {
+		if d.Q < minQuality {
+			continue
+		}
+		half := float64(d.Scale) / 2
+		r := image.Rect(
+			int(math.Round((float64(d.Col)-half)*scale)),
+			int(math.Round((float64(d.Row)-half)*scale)),
+			int(math.Round((float64(d.Col)+half)*scale)),
+			int(math.Round((float64(d.Row)+half)*scale)),
+		).Add(b.Min).Intersect(b)
+		if !r.Empty() {
+			faces = append(faces, r)
+		}
+	}
+	return faces, nil
+}
+
+func loadFaceClassifier(cascade []byte) (*pigo.Pigo, error) {
+	if cascade != nil {
+		return pigo.NewPigo().Unpack(cascade)
+	}
+	faceClassifierOnce.Do(func() {
+		faceClassifier, faceClassifierErr = pigo.NewPigo().Unpack(facefinderCascade)
+	})
+	return faceClassifier, faceClassifierErr
+}
+
+// ========================
+//
+//	根据裁剪策略计算裁剪中心,检测到的人脸写入res.Faces
+//	img			image.Image	图片
+//	opts		*ImgOptions	可选参数
+//	res			*ImgResult	处理结果
+//	返回值		image.Point	裁剪中心
+//	返回值		error		错误信息
+func cropFocus(img image.Image, opts *ImgOptions, res *ImgResult) (image.Point, error) {
+	b := img.Bounds()
+	strategy := opts.Crop
+	if strategy == CropFace {
+		faces, err := DetectFaces(img, opts.Face)
+		if err != nil {
+			return b.Min, err
+		}
+		if len(faces) > 0 {
+			res.Faces = faces
+			u := faces[0]
+			for _, f := range faces[1:] {
+				u = u.Union(f)
+			}
+			return rectCenter(u), nil
+		}
+		strategy = CropCenter
+		if opts.Face != nil {
+			strategy = opts.Face.Fallback
+		}
+	}
+	if strategy == CropSmart {
+		return smartFocus(img), nil
+	}
+	return rectCenter(b), nil
+}
+
+// ========================
+//
+//	按图片边缘能量的加权中心计算裁剪中心
+//	img			image.Image	图片
+//	返回值		image.Point	裁剪中心
+func smartFocus(img image.Image) image.Point {
+	b := img.Bounds()
+	small := imaging.Grayscale(imaging.Fit(img, 128, 128, imaging.Box))
+	w, h := small.Bounds().Dx(), small.Bounds().Dy()
+	if w < 3 || h < 3 {
+		return rectCenter(b)
+	}
+	var sum, sx, sy float64
+	for y := 1; y < h-1; y++ {
+		for x := 1; x < w-1; x++ {
+			gx := float64(small.Pix[small.PixOffset(x+1, y)]) - float64(small.Pix[small.PixOffset(x-1, y)])
+			gy := float64(small.Pix[small.PixOffset(x, y+1)]) - float64(small.Pix[small.PixOffset(x, y-1)])
+			e := math.Abs(gx) + math.Abs(gy)
+			sum += e
+			sx += e * float64(x)
+			sy += e * float64(y)
+		}
+	}
+	if sum == 0 {
+		return rectCenter(b)
+	}
+	return image.Pt(
+		b.Min.X+int(sx/sum*float64(b.Dx())/float64(w)),
+		b.Min.Y+int(sy/sum*float64(b.Dy())/float64(h)),
+	)
+}
+
+// ========================
+//
+//	以focus为中心按宽高比例裁剪,裁剪区域大于目标宽高时再缩放
+//	img			image.Image	图片
+//	width		int		目标宽度
+//	height		int		目标高度
+//	focus		image.Point	裁剪中心
+//	filter		imaging.ResampleFilter	重采样算法
+//	返回值		image.Image	新图片
+//	返回值		bool		图片是否缩小,与按比例缩放相同,原图小于目标宽高时不生成该尺寸
+func coverImage(img image.Image, width int, height int, focus image.Point, filter imaging.ResampleFilter) (image.Image, bool) {
+	b := img.Bounds()
+	cw, ch := b.Dx(), b.Dx()*height/width
+	if ch > b.Dy() {
+		ch = b.Dy()
+		cw = b.Dy() * width / height
+	}
+	if cw < 1 {
+		cw = 1
+	}
+	if ch < 1 {
+		ch = 1
+	}
+	x := clampInt(focus.X-cw/2, b.Min.X, b.Max.X-cw)
+	y := clampInt(focus.Y-ch/2, b.Min.Y, b.Max.Y-ch)
+
+	newImage := img
+	if cw != b.Dx() || ch != b.Dy() {
+		newImage = imaging.Crop(img, image.Rect(x, y, x+cw, y+ch))
+	}
+	if cw <= width && ch <= height {
+		return newImage, false
+	}
+	return imaging.Resize(newImage, width, height, filter), true
+}
+
+func rectCenter(r image.Rectangle) image.Point {
+	return image.Pt((r.Min.X+r.Max.X)/2, (r.Min.Y+r.Max.Y)/2)
+}
+
+func clampInt(v int, lo int, hi int) int {
+	if v > hi {
+		v = hi
+	}
+	if v < lo {
+		v = lo
+	}
+	return v
+}
@@ -8,3 +8,15 @@ require (
 )
 
 require github.com/chai2010/webp v1.1.1
+
+require (
+	github.com/esimov/pigo v1.4.6
+	github.com/fsnotify/fsnotify v1.8.0
+	go.etcd.io/bbolt v1.3.10
+	gopkg.in/yaml.v3 v3.0.1
+)
+
+require (
+	golang.org/x/sys v0.13.0 // indirect
+	golang.org/x/text v0.3.6 // indirect
+)
@@ -0,0 +1,623 @@
+package mediaResize
+
+import (
+	"bytes"
+	"context"
+	"encoding/binary"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"strings"
+	"sync"
+	"time"
+
+	bolt "go.etcd.io/bbolt"
+)
+
+const (
+	JobPending   = "pending"   // 等待执行或等待重试
+	JobRunning   = "running"   // 执行中
+	JobSucceeded = "succeeded" // 执行成功
+	JobFailed    = "failed"    // 重试次数用完后失败
+)
+
+// ErrJobNotFound 任务不存在
+var ErrJobNotFound = errors.New("mediaResize: job not found")
+
+var (
+	jobsBucket    = []byte("jobs")
+	pendingBucket = []byte("jobs-pending") //等待执行的任务索引,key为jobIndexKey(NextRunAt, ID)
+	doneBucket    = []byte("jobs-done")    //已结束的任务索引,key为jobIndexKey(UpdatedAt, ID)
+)
+
+// JobRequest 缩放任务参数,需要可以JSON序列化,ImgOptions中的Processors等不会保存
+type JobRequest struct {
+	Type     string        `json:"type,omitempty"`     //文件类型: image, video, 为空时按文件内容检测
+	Path     string        `json:"path"`               //原文件路径
+	NewPath  string        `json:"newPath"`            //新文件路径
+	Formats  []string      `json:"formats"`            //输出格式
+	MaxWHs   []MediaWH     `json:"maxWHs"`             //输出宽高
+	Quality  *int          `json:"quality,omitempty"`  //图片质量(1-100),为nil时使用默认值
+	CodeRate *int          `json:"codeRate,omitempty"` //视频码率(k),为nil时使用默认值
+	Image    *ImgOptions   `json:"image,omitempty"`    //图片可选参数
+	Video    *VideoOptions `json:"video,omitempty"`    //视频可选参数
+}
+
+// Job 缩放任务
+type Job struct {
+	ID          string         `json:"id"`                  //任务ID,按创建顺序递增
+	Request     JobRequest     `json:"request"`             //任务参数
+	Status      string         `json:"status"`              //状态: JobPending, JobRunning, JobSucceeded, JobFailed
+	Progress    float64        `json:"progress"`            //进度(0-1)
+	Attempts    int            `json:"attempts"`            //已执行次数
+	MaxAttempts int            `json:"maxAttempts"`         //最多执行次数
+	Error       string         `json:"error,omitempty"`     //最近一次的错误信息
+	Results     []ResizeResult `json:"results,omitempty"`   //生成的文件
+	CreatedAt   time.Time      `json:"createdAt"`           //创建时间
+	UpdatedAt   time.Time      `json:"updatedAt"`           //更新时间
+	NextRunAt   time.Time      `json:"nextRunAt,omitempty"` //下次执行时间
+}
+
+// JobQueue 持久化的缩放任务队列,使用bbolt保存任务,重启后继续执行未完成的任务
+type JobQueue struct {
+	Workers     int           //并发执行的任务数,默认为2
+	MaxAttempts int           //每个任务最多执行次数,默认为3
+	Backoff     time.Duration //首次重试的等待时间,之后每次加倍,默认为5秒
+	MaxBackoff  time.Duration //重试的最长等待时间,默认为10分钟
+	IsPrint     bool          //是否打印错误及提示信息
+	Hooks       []JobHook     //任务成功或最终失败后在单独的goroutine中按顺序调用
+	HookTimeout time.Duration //每个任务调用Hooks的超时时间,与worker的ctx无关,默认为1分钟
+	Retention   time.Duration //成功或失败的任务保留时间,超过后由Run定期删除,为0时不删除
+
+	db    *bolt.DB
+	wake  chan struct{}
+	hooks sync.WaitGroup //发送中的Hooks
+}
+
+// ========================
+//
+//	打开任务队列
+//	path		string		bbolt数据库文件路径
+//	返回值		*JobQueue	任务队列
+//	返回值		error		错误信息
+func OpenJobQueue(path string) (*JobQueue, error) {
+	db, err := bolt.Open(path, 0o600, &bolt.Options{Timeout: time.Second})
+	if err != nil {
+		return nil, err
+	}
+	err = db.Update(func(tx *bolt.Tx) error {
+		b, err := tx.CreateBucketIfNotExists(jobsBucket)
+		if err != nil {
+			return err
+		}
+		if _, err = tx.CreateBucketIfNotExists(doneBucket); err != nil {
+			return err
+		}
+		if tx.Bucket(pendingBucket) != nil {
+			return nil
+		}
+		// 没有索引的旧数据库,按现有任务建立索引
+		if _, err = tx.CreateBucket(pendingBucket); err != nil {
+			return err
+		}
+		return b.ForEach(func(k, v []byte) error {
+			job := &Job{}
+			if err := json.Unmarshal(v, job); err != nil {
+				return err
+			}
+			return indexJob(tx, nil, job)
+		})
+	})
+	if err != nil {
+		db.Close()
+		return nil, err
+	}
+	return &JobQueue{db: db, wake: make(chan struct{}, 1)}, nil
+}
+
+// ========================
+//
+//	关闭任务队列
+//	返回值		error		错误信息
+func (q *JobQueue) Close() error {
+	return q.db.Close()
+}
+
+// ========================
+//
+//	添加任务
+//	req			JobRequest	任务参数
+//	返回值		*Job		任务
+//	返回值		error		错误信息
+func (q *JobQueue) Enqueue(req JobRequest) (*Job, error) {
+	if req.Path == "" || req.NewPath == "" {
+		return nil, errors.New("job: path and newPath are required")
+	}
+	if len(req.Formats) == 0 || len(req.MaxWHs) == 0 {
+		return nil, errors.New("job: formats and maxWHs are required")
+	}
+	maxAttempts := q.MaxAttempts
+	if maxAttempts <= 0 {
+		maxAttempts = 3
+	}
+	now := time.Now()
+	job := &Job{Request: req, Status: JobPending, MaxAttempts: maxAttempts, CreatedAt: now, UpdatedAt: now}
+	err := q.db.Update(func(tx *bolt.Tx) error {
+		b := tx.Bucket(jobsBucket)
+		seq, err := b.NextSequence()
+		if err != nil {
+			return err
+		}
+		job.ID = fmt.Sprintf("%016x", seq)
+		return putJob(tx, nil, job)
+	})
+	if err != nil {
+		return nil, err
+	}
+	q.notify()
+	return job, nil
+}
+
+// ========================
+//
+//	获取任务
+//	id			string		任务ID
+//	返回值		*Job		任务
+//	返回值		error		错误信息,不存在时为ErrJobNotFound
+func (q *JobQueue) Job(id string) (*Job, error) {
+	var job *Job
+	err := q.db.View(func(tx *bolt.Tx) error {
+		var err error
+		job, err = getJob(tx.Bucket(jobsBucket), id)
+		return err
+	})
+	return job, err
+}
+
+// ========================
+//
+//	按创建顺序获取所有任务
+//	返回值		[]*Job		任务
+//	返回值		error		错误信息
+func (q *JobQueue) Jobs() ([]*Job, error) {
+	jobs := []*Job{}
+	err := q.db.View(func(tx *bolt.Tx) error {
+		return tx.Bucket(jobsBucket).ForEach(func(k, v []byte) error {
+			job := &Job{}
+			if err := json.Unmarshal(v, job); err != nil {
+				return err
+			}
+			jobs = append(jobs, job)
+			return nil
+		})
+	})
+	return jobs, err
+}
+
+// ========================
+//
+//	启动worker执行任务,上次未完成的任务会重新执行;ctx取消时结束执行中的视频编码,被中断的任务恢复为等待执行,
+//	等待worker退出和发送中的Hooks完成(最多HookTimeout)后返回
+//	ctx			context.Context	上下文
+//	返回值		error		错误信息
+func (q *JobQueue) Run(ctx context.Context) error {
+	// 上次进程退出时执行中的任务
+	err := q.db.Update(func(tx *bolt.Tx) error {
+		running := []*Job{}
+		err := tx.Bucket(jobsBucket).ForEach(func(k, v []byte) error {
+			job := &Job{}
+			if err := json.Unmarshal(v, job); err != nil {
+				return err
+			}
+			if job.Status == JobRunning {
+				running = append(running, job)
+			}
+			return nil
+		})
+		if err != nil {
+			return err
+		}
+		for _, job := range running {
+			old := *job
+			job.Status, job.Progress, job.UpdatedAt = JobPending, 0, time.Now()
+			if job.Attempts > 0 {
+				job.Attempts--
+			}
+			if err = putJob(tx, &old, job); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+	if _, err = q.Prune(); err != nil && q.IsPrint {
+		fmt.Println("job prune failed:", err)
+	}
+
+	workers := q.Workers
+	if workers <= 0 {
+		workers = 2
+	}
+	var wg sync.WaitGroup
+	if q.Retention > 0 {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			q.pruneLoop(ctx)
+		}()
+	}
+	for i := 0; i < workers; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			q.worker(ctx)
+		}()
+	}
+	wg.Wait()
+	q.hooks.Wait()
+	return nil
+}
+
+func (q *JobQueue) worker(ctx context.Context) {
+	for ctx.Err() == nil {
+		job, next, err := q.claim()
+		if err != nil && q.IsPrint {
+			fmt.Println("job claim failed:", err)
+		}
+		if job == nil {
+			wait := time.Second
+			if !next.IsZero() {
+				if d := time.Until(next); d < wait {
+					wait = d
+				}
+			}
+			timer := time.NewTimer(wait)
+			select {
+			case <-ctx.Done():
+			case <-q.wake:
+			case <-timer.C:
+			}
+			timer.Stop()
+			continue
+		}
+		q.execute(ctx, job)
+		// 可能还有其他等待执行的任务
+		q.notify()
+	}
+}
+
+// ========================
+//
+//	定期删除超过保留时间的任务
+//	ctx			context.Context	上下文
+func (q *JobQueue) pruneLoop(ctx context.Context) {
+	interval := q.Retention / 10
+	if interval < time.Second {
+		interval = time.Second
+	}
+	if interval > time.Hour {
+		interval = time.Hour
+	}
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			if _, err := q.Prune(); err != nil && q.IsPrint {
+				fmt.Println("job prune failed:", err)
+			}
+		}
+	}
+}
+
+// ========================
+//
+//	删除结束时间早于Retention的成功或失败任务,Retention为0时不删除
+//	返回值		int		删除的任务数
+//	返回值		error		错误信息
+func (q *JobQueue) Prune() (int, error) {
+	if q.Retention <= 0 {
+		return 0, nil
+	}
+	cutoff := jobIndexKey(time.Now().Add(-q.Retention), "")
+	n := 0
+	err := q.db.Update(func(tx *bolt.Tx) error {
+		b, done := tx.Bucket(jobsBucket), tx.Bucket(doneBucket)
+		keys := [][]byte{}
+		c := done.Cursor()
+		for k, _ := c.First(); k != nil && bytes.Compare(k[:8], cutoff) < 0; k, _ = c.Next() {
+			keys = append(keys, k)
+		}
+		for _, k := range keys {
+			if err := b.Delete(k[8:]); err != nil {
+				return err
+			}
+			if err := done.Delete(k); err != nil {
+				return err
+			}
+		}
+		n = len(keys)
+		return nil
+	})
+	return n, err
+}
+
+func (q *JobQueue) notify() {
+	select {
+	case q.wake <- struct{}{}:
+	default:
+	}
+}
+
+// ========================
+//
+//	取出一个到期的任务并标记为执行中
+//	返回值		*Job		任务,没有到期任务时为nil
+//	返回值		time.Time	最早的重试时间,没有等待重试的任务时为零值
+//	返回值		error		错误信息
+func (q *JobQueue) claim() (*Job, time.Time, error) {
+	var (
+		claimed *Job
+		next    time.Time
+	)
+	now := time.Now()
+	err := q.db.Update(func(tx *bolt.Tx) error {
+		// 索引按执行时间排序,只需要读取第一个
+		k, _ := tx.Bucket(pendingBucket).Cursor().First()
+		if k == nil {
+			return nil
+		}
+		if runAt := int64(binary.BigEndian.Uint64(k)); runAt > now.UnixNano() {
+			next = time.Unix(0, runAt)
+			return nil
+		}
+		job, err := getJob(tx.Bucket(jobsBucket), string(k[8:]))
+		if err != nil {
+			return err
+		}
+		old := *job
+		job.Status, job.Progress, job.UpdatedAt = JobRunning, 0, now
+		job.Attempts++
+		claimed = job
+		return putJob(tx, &old, job)
+	})
+	return claimed, next, err
+}
+
+// ========================
+//
+//	执行任务并保存结果,失败时按Backoff等待后重试,结束后在单独的goroutine中调用Hooks
+//	ctx			context.Context	上下文,取消时结束视频编码
+//	job			*Job		任务
+func (q *JobQueue) execute(ctx context.Context, job *Job) {
+	if q.IsPrint {
+		fmt.Println("job start:", job.ID, job.Request.Path)
+	}
+	// 视频任务的编码进度最多每秒保存一次
+	var lastSave time.Time
+	results, err := runJobRequest(ctx, &job.Request, func(progress float64) {
+		if time.Since(lastSave) < time.Second {
+			return
+		}
+		lastSave = time.Now()
+		q.update(job.ID, func(j *Job) {
+			if j.Status == JobRunning {
+				j.Progress = progress
+			}
+		})
+	})
+	var final Job
+	uerr := q.update(job.ID, func(j *Job) {
+		defer func() { final = *j }()
+		j.NextRunAt = time.Time{}
+		if err == nil {
+			j.Status, j.Progress, j.Error, j.Results = JobSucceeded, 1, "", results
+			return
+		}
+		if ctx.Err() != nil {
+			// 被关闭中断的任务不计入执行次数,下次启动时重新执行
+			j.Status, j.Progress = JobPending, 0
+			if j.Attempts > 0 {
+				j.Attempts--
+			}
+			return
+		}
+		j.Error = err.Error()
+		if j.Attempts >= j.MaxAttempts {
+			j.Status = JobFailed
+			return
+		}
+		j.Status = JobPending
+		j.NextRunAt = time.Now().Add(q.backoff(j.Attempts))
+	})
+	if q.IsPrint {
+		fmt.Println("job done:", job.ID, err)
+	}
+	if uerr != nil {
+		if q.IsPrint {
+			fmt.Println("job update failed:", uerr)
+		}
+		return
+	}
+	if len(q.Hooks) == 0 || (final.Status != JobSucceeded && final.Status != JobFailed) {
+		return
+	}
+	q.hooks.Add(1)
+	go func() {
+		defer q.hooks.Done()
+		q.runHooks(&final)
+	}()
+}
+
+// ========================
+//
+//	按顺序调用Hooks,使用HookTimeout超时的ctx,不阻塞worker,关闭队列时也不会被取消
+//	job			*Job		已结束的任务
+func (q *JobQueue) runHooks(job *Job) {
+	timeout := q.HookTimeout
+	if timeout <= 0 {
+		timeout = time.Minute
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), timeout)
+	defer cancel()
+	for _, hook := range q.Hooks {
+		if err := hook.JobDone(ctx, job); err != nil && q.IsPrint {
+			fmt.Println("job hook failed:", job.ID, err)
+		}
+	}
+}
+
+// ========================
+//
+//	第attempts次执行失败后的等待时间
+//	attempts	int		已执行次数
+//	返回值		time.Duration	等待时间
+func (q *JobQueue) backoff(attempts int) time.Duration {
+	d, max := q.Backoff, q.MaxBackoff
+	if d <= 0 {
+		d = 5 * time.Second
+	}
+	if max <= 0 {
+		max = 10 * time.Minute
+	}
+	for i := 1; i < attempts && d < max; i++ {
+		d *= 2
+	}
+	if d > max {
+		d = max
+	}
+	return d
+}
+
+// ========================
+//
+//	修改并保存任务
+//	id			string		任务ID
+//	fn			func(*Job)	修改任务的函数
+//	返回值		error		错误信息
+func (q *JobQueue) update(id string, fn func(*Job)) error {
+	return q.db.Update(func(tx *bolt.Tx) error {
+		b := tx.Bucket(jobsBucket)
+		job, err := getJob(b, id)
+		if err != nil {
+			return err
+		}
+		old := *job
+		fn(job)
+		job.UpdatedAt = time.Now()
+		return putJob(tx, &old, job)
+	})
+}
+
+// ========================
+//
+//	执行缩放任务
+//	ctx			context.Context	上下文,取消时结束视频编码
+//	req			*JobRequest		任务参数
+//	onProgress	func(float64)	视频编码进度(0-1)回调
+//	返回值		[]ResizeResult	生成的文件
+//	返回值		error			错误信息
+func runJobRequest(ctx context.Context, req *JobRequest, onProgress func(float64)) ([]ResizeResult, error) {
+	mediaType := req.Type
+	if mediaType == "" {
+		contentType, err := DetectContentType(req.Path)
+		if err != nil {
+			return nil, err
+		}
+		mediaType = strings.SplitN(contentType, "/", 2)[0]
+	}
+	switch mediaType {
+	case "image":
+		quality := -1
+		if req.Quality != nil {
+			quality = *req.Quality
+		}
+		res, err := ImgResizeWithOptions(req.Path, req.NewPath, req.Formats, req.MaxWHs, quality, false, req.Image)
+		return res.Files, err
+	case "video":
+		opts := VideoOptions{}
+		if req.Video != nil {
+			opts = *req.Video
+		}
+		opts.OnProgress = func(p *VideoProgress) { onProgress(p.Overall()) }
+		opts.Context = ctx
+		codeRate := -1
+		if req.CodeRate != nil {
+			codeRate = *req.CodeRate
+		}
+		res, err := VideoResizeWithOptions(req.Path, req.NewPath, req.Formats, req.MaxWHs, codeRate, false, &opts)
+		return res.Files, err
+	}
+	return nil, fmt.Errorf("job: unsupported media type: %s", mediaType)
+}
+
+func getJob(b *bolt.Bucket, id string) (*Job, error) {
+	data := b.Get([]byte(id))
+	if data == nil {
+		return nil, ErrJobNotFound
+	}
+	job := &Job{}
+	return job, json.Unmarshal(data, job)
+}
+
+// ========================
+//
+//	保存任务并更新索引
+//	tx			*bolt.Tx	事务
+//	old			*Job		修改前的任务,新任务为nil
+//	job			*Job		任务
+//	返回值		error		错误信息
+func putJob(tx *bolt.Tx, old *Job, job *Job) error {
+	data, err := json.Marshal(job)
+	if err != nil {
+		return err
+	}
+	if err = tx.Bucket(jobsBucket).Put([]byte(job.ID), data); err != nil {
+		return err
+	}
+	return indexJob(tx, old, job)
+}
+
+// ========================
+//
+//	将任务从旧索引移到新索引,等待执行的任务按NextRunAt、结束的任务按UpdatedAt排序
+//	tx			*bolt.Tx	事务
+//	old			*Job		修改前的任务,为nil时只添加索引
+//	job			*Job		任务
+//	返回值		error		错误信息
+func indexJob(tx *bolt.Tx, old *Job, job *Job) error {
+	if old != nil {
+		if bucket, key := jobIndex(old); bucket != nil {
+			if err := tx.Bucket(bucket).Delete(key); err != nil {
+				return err
+			}
+		}
+	}
+	if bucket, key := jobIndex(job); bucket != nil {
+		return tx.Bucket(bucket).Put(key, nil)
+	}
+	return nil
+}
+
+func jobIndex(job *Job) ([]byte, []byte) {
+	switch job.Status {
+	case JobPending:
+		return pendingBucket, jobIndexKey(job.NextRunAt, job.ID)
+	case JobSucceeded, JobFailed:
+		return doneBucket, jobIndexKey(job.UpdatedAt, job.ID)
+	}
+	return nil, nil
+}
+
+// jobIndexKey 索引key: 8字节大端序的纳秒时间+任务ID,零值时间排在最前
+func jobIndexKey(t time.Time, id string) []byte {
+	key := make([]byte, 8, 8+len(id))
+	if !t.IsZero() {
+		binary.BigEndian.PutUint64(key, uint64(t.UnixNano()))
+	}
+	return append(key, id...)
+}
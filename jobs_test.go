@@ -0,0 +1,217 @@
+package mediaResize
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"path/filepath"
+	"testing"
+	"time"
+
+	bolt "go.etcd.io/bbolt"
+)
+
+// waitJob 等待任务结束
+func waitJob(t *testing.T, q *JobQueue, id string) *Job {
+	deadline := time.Now().Add(5 * time.Second)
+	for time.Now().Before(deadline) {
+		job, err := q.Job(id)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if job.Status == JobSucceeded || job.Status == JobFailed {
+			return job
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	t.Fatal("timeout waiting for job", id)
+	return nil
+}
+
+func TestJobQueue(t *testing.T) {
+	dir := t.TempDir()
+	src := filepath.Join(dir, "a.png")
+	newTestImage(t, src, 200, 100)
+	dbPath := filepath.Join(dir, "jobs.db")
+
+	q, err := OpenJobQueue(dbPath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	q.Backoff = 10 * time.Millisecond
+	ok, err := q.Enqueue(JobRequest{Path: src, NewPath: filepath.Join(dir, "a.png"), Formats: []string{"jpg"}, MaxWHs: []MediaWH{{Width: 50, Height: 50}}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	bad, err := q.Enqueue(JobRequest{Type: "image", Path: filepath.Join(dir, "missing.png"), NewPath: filepath.Join(dir, "m.png"), Formats: []string{"jpg"}, MaxWHs: []MediaWH{{Width: 50, Height: 50}}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err = q.Job("nope"); !errors.Is(err, ErrJobNotFound) {
+		t.Error("Job(nope):", err)
+	}
+	// 模拟进程在执行任务时退出
+	if err = q.update(ok.ID, func(j *Job) { j.Status, j.Attempts = JobRunning, 1 }); err != nil {
+		t.Fatal(err)
+	}
+	q.Close()
+
+	// 重新打开后继续执行未完成的任务
+	q, err = OpenJobQueue(dbPath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer q.Close()
+	q.Backoff = 10 * time.Millisecond
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan error, 1)
+	go func() { done <- q.Run(ctx) }()
+
+	job := waitJob(t, q, ok.ID)
+	if job.Status != JobSucceeded || job.Progress != 1 || job.Attempts != 1 {
+		t.Errorf("job: %+v", job)
+	}
+	if len(job.Results) != 2 || job.Results[0].Size != "S" || job.Results[0].Format != "jpg" {
+		t.Errorf("results: %+v", job.Results)
+	}
+
+	job = waitJob(t, q, bad.ID)
+	if job.Status != JobFailed || job.Attempts != 3 || job.Error == "" {
+		t.Errorf("failed job: %+v", job)
+	}
+
+	jobs, err := q.Jobs()
+	if err != nil || len(jobs) != 2 || jobs[0].ID != ok.ID {
+		t.Error("Jobs:", jobs, err)
+	}
+	cancel()
+	if err := <-done; err != nil {
+		t.Error("Run:", err)
+	}
+}
+
+func TestJobBackoff(t *testing.T) {
+	q := &JobQueue{Backoff: time.Second, MaxBackoff: 5 * time.Second}
+	for attempts, want := range map[int]time.Duration{1: time.Second, 2: 2 * time.Second, 3: 4 * time.Second, 4: 5 * time.Second, 10: 5 * time.Second} {
+		if got := q.backoff(attempts); got != want {
+			t.Errorf("backoff(%d) = %v, want %v", attempts, got, want)
+		}
+	}
+}
+
+func TestJobQueueCancel(t *testing.T) {
+	dir := t.TempDir()
+	src := filepath.Join(dir, "a.mp4")
+	newTestVideo(t, src, 640, 480, 20)
+
+	q, err := OpenJobQueue(filepath.Join(dir, "jobs.db"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer q.Close()
+	job, err := q.Enqueue(JobRequest{Path: src, NewPath: filepath.Join(dir, "b.mp4"), Formats: []string{"mp4"}, MaxWHs: []MediaWH{{Width: 320, Height: 320}}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan error, 1)
+	go func() { done <- q.Run(ctx) }()
+	for deadline := time.Now().Add(10 * time.Second); ; time.Sleep(10 * time.Millisecond) {
+		if j, _ := q.Job(job.ID); j != nil && j.Status == JobRunning {
+			break
+		}
+		if time.Now().After(deadline) {
+			t.Fatal("job did not start")
+		}
+	}
+	// 关闭时结束编码,任务恢复为等待执行且不计入执行次数
+	start := time.Now()
+	cancel()
+	if err := <-done; err != nil {
+		t.Error("Run:", err)
+	}
+	if time.Since(start) > 5*time.Second {
+		t.Error("cancel did not stop the encode")
+	}
+	j, err := q.Job(job.ID)
+	if err != nil || j.Status != JobPending || j.Attempts != 0 {
+		t.Errorf("job after cancel: %+v %v", j, err)
+	}
+}
+
+func TestJobQueueIndex(t *testing.T) {
+	dir := t.TempDir()
+	dbPath := filepath.Join(dir, "jobs.db")
+	q, err := OpenJobQueue(dbPath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	req := JobRequest{Path: "a.png", NewPath: "b.png", Formats: []string{"jpg"}, MaxWHs: []MediaWH{{Width: 50, Height: 50}}}
+	ids := []string{}
+	for i := 0; i < 3; i++ {
+		job, err := q.Enqueue(req)
+		if err != nil {
+			t.Fatal(err)
+		}
+		ids = append(ids, job.ID)
+	}
+	later := time.Now().Add(time.Hour)
+	if err = q.update(ids[0], func(j *Job) { j.NextRunAt = later }); err != nil {
+		t.Fatal(err)
+	}
+	// 删除索引模拟旧数据库,重新打开时重建
+	q.db.Update(func(tx *bolt.Tx) error { return tx.DeleteBucket(pendingBucket) })
+	q.Close()
+	if q, err = OpenJobQueue(dbPath); err != nil {
+		t.Fatal(err)
+	}
+	defer q.Close()
+
+	// 按执行时间取出,未到期的任务只返回执行时间
+	for _, want := range ids[1:] {
+		job, _, err := q.claim()
+		if err != nil || job == nil || job.ID != want || job.Status != JobRunning || job.Attempts != 1 {
+			t.Fatalf("claim: %+v %v, want %s", job, err, want)
+		}
+	}
+	job, next, err := q.claim()
+	if err != nil || job != nil || next.UnixNano() != later.UnixNano() {
+		t.Errorf("claim: %+v %v %v, want next %v", job, next, err, later)
+	}
+
+	// 超过保留时间的已结束任务被删除
+	q.Retention = time.Minute
+	q.update(ids[1], func(j *Job) { j.Status = JobSucceeded })
+	q.db.Update(func(tx *bolt.Tx) error {
+		job, err := getJob(tx.Bucket(jobsBucket), ids[2])
+		if err != nil {
+			return err
+		}
+		old := *job
+		job.Status, job.UpdatedAt = JobFailed, time.Now().Add(-time.Hour)
+		return putJob(tx, &old, job)
+	})
+	if n, err := q.Prune(); err != nil || n != 1 {
+		t.Errorf("Prune: %d %v", n, err)
+	}
+	if _, err = q.Job(ids[2]); !errors.Is(err, ErrJobNotFound) {
+		t.Error("expired job should be pruned:", err)
+	}
+	if jobs, _ := q.Jobs(); len(jobs) != 2 {
+		t.Errorf("jobs after prune: %d", len(jobs))
+	}
+}
+
+func TestJobRequestDefaults(t *testing.T) {
+	req := JobRequest{}
+	if err := json.Unmarshal([]byte(`{"path":"a.png"}`), &req); err != nil {
+		t.Fatal(err)
+	}
+	// 未设置时使用默认值而不是0
+	if req.Quality != nil || req.CodeRate != nil {
+		t.Errorf("quality %v, codeRate %v", req.Quality, req.CodeRate)
+	}
+	if err := json.Unmarshal([]byte(`{"quality":0}`), &req); err != nil || req.Quality == nil || *req.Quality != 0 {
+		t.Errorf("explicit quality: %v %v", req.Quality, err)
+	}
+}
@@ -0,0 +1,246 @@
+package mediaResize
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"sync"
+	"time"
+)
+
+// Manifest 增量处理记录,保存每个原图的内容hash、参数hash和生成的文件
+type Manifest struct {
+	Path    string                    `json:"-"`       //记录文件路径
+	Entries map[string]*ManifestEntry `json:"entries"` //按原图路径保存的记录
+
+	mu sync.Mutex
+}
+
+// ManifestEntry 单个原图的处理记录
+type ManifestEntry struct {
+	SourceHash  string         `json:"sourceHash"`  //原图内容的sha256
+	Size        int64          `json:"size"`        //原图大小,与修改时间均未变化时不重新计算hash
+	ModTime     time.Time      `json:"modTime"`     //原图修改时间
+	OptionsHash string         `json:"optionsHash"` //处理参数和新图片路径的sha256
+	Paths       []string       `json:"paths"`       //生成的图片路径
+	Sizes       []string       `json:"sizes"`       //生成的尺寸名称
+	Formats     []string       `json:"formats"`     //生成的图片格式
+	Files       []ResizeResult `json:"files"`       //生成的文件
+}
+
+// ========================
+//
+//	读取增量处理记录,文件不存在时返回空记录
+//	path		string		记录文件路径
+//	返回值		*Manifest	处理记录
+//	返回值		error		错误信息
+func LoadManifest(path string) (*Manifest, error) {
+	m := &Manifest{Path: path, Entries: map[string]*ManifestEntry{}}
+	data, err := os.ReadFile(path)
+	if errors.Is(err, fs.ErrNotExist) {
+		return m, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	if err = json.Unmarshal(data, m); err != nil {
+		return nil, err
+	}
+	if m.Entries == nil {
+		m.Entries = map[string]*ManifestEntry{}
+	}
+	return m, nil
+}
+
+// ========================
+//
+//	保存增量处理记录
+//	返回值		error		错误信息
+func (m *Manifest) Save() error {
+	m.mu.Lock()
+	data, err := json.MarshalIndent(m, "", "  ")
+	m.mu.Unlock()
+	if err != nil {
+		return err
+	}
+	dir := filepath.Dir(m.Path)
+	if err = os.MkdirAll(dir, os.ModePerm); err != nil {
+		return err
+	}
+	// 先写入临时文件再重命名,中断时不会损坏原记录
+	tmp, err := os.CreateTemp(dir, ".manifest-*")
+	if err != nil {
+		return err
+	}
+	_, err = tmp.Write(data)
+	if cerr := tmp.Close(); err == nil {
+		err = cerr
+	}
+	if err == nil {
+		err = os.Rename(tmp.Name(), m.Path)
+	}
+	if err != nil {
+		os.Remove(tmp.Name())
+	}
+	return err
+}
+
+// ========================
+//
+//	增量处理图片,原图、参数未变化且生成的文件都存在时跳过
+//	path		string		原图片路径
+//	newPath		string		新图片路径
+//	formats		[]string	图片格式
+//	maxWHs		[]MediaWH	图片宽高
+//	quality		int		图片质量
+//	isPrint		bool		是否打印错误及提示信息
+//	opts		*ImgOptions	可选参数,使用未实现CacheKeyer的自定义处理器等无法计算缓存key的参数时总是重新处理
+//	返回值		*ImgResult	处理结果,跳过时Skipped为true
+//	返回值		error		错误信息
+func (m *Manifest) ImgResize(path string, newPath string, formats []string, maxWHs []MediaWH, quality int, isPrint bool, opts *ImgOptions) (*ImgResult, error) {
+	if opts == nil {
+		opts = &ImgOptions{}
+	}
+	params, err := imgParams(formats, maxWHs, quality, opts)
+	if err != nil {
+		if isPrint {
+			fmt.Println("manifest disabled:", err)
+		}
+		return ImgResizeWithOptions(path, newPath, formats, maxWHs, quality, isPrint, opts)
+	}
+	optionsData, err := json.Marshal(struct {
+		NewPath string
+		Naming  string
+		Params  interface{}
+	}{newPath, opts.Naming, params})
+	if err != nil {
+		return ImgResizeWithOptions(path, newPath, formats, maxWHs, quality, isPrint, opts)
+	}
+	optionsSum := sha256.Sum256(optionsData)
+	optionsHash := hex.EncodeToString(optionsSum[:])
+
+	info, err := os.Stat(path)
+	if err != nil {
+		return &ImgResult{Paths: []string{}, Sizes: []string{}, Formats: []string{}, Files: []ResizeResult{}}, err
+	}
+	key := filepath.Clean(path)
+	m.mu.Lock()
+	entry := m.Entries[key]
+	m.mu.Unlock()
+
+	sourceHash := ""
+	if entry != nil && entry.OptionsHash == optionsHash && filesExist(entry.Paths) {
+		same := entry.Size == info.Size() && entry.ModTime.Equal(info.ModTime())
+		if !same {
+			// 修改时间变化但内容相同时只更新记录
+			if sourceHash, err = fileHash(path); err == nil && sourceHash == entry.SourceHash {
+				same = true
+				m.mu.Lock()
+				entry.Size, entry.ModTime = info.Size(), info.ModTime()
+				m.mu.Unlock()
+			}
+		}
+		if same {
+			if isPrint {
+				fmt.Println("up to date:", path)
+			}
+			return &ImgResult{Paths: entry.Paths, Sizes: entry.Sizes, Formats: entry.Formats, Files: entry.Files, Skipped: true}, nil
+		}
+	}
+	if sourceHash == "" {
+		if sourceHash, err = fileHash(path); err != nil {
+			return &ImgResult{Paths: []string{}, Sizes: []string{}, Formats: []string{}, Files: []ResizeResult{}}, err
+		}
+	}
+
+	res, err := ImgResizeWithOptions(path, newPath, formats, maxWHs, quality, isPrint, opts)
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if err != nil {
+		delete(m.Entries, key)
+		return res, err
+	}
+	m.Entries[key] = &ManifestEntry{
+		SourceHash:  sourceHash,
+		Size:        info.Size(),
+		ModTime:     info.ModTime(),
+		OptionsHash: optionsHash,
+		Paths:       res.Paths,
+		Sizes:       res.Sizes,
+		Formats:     res.Formats,
+		Files:       res.Files,
+	}
+	return res, nil
+}
+
+// ========================
+//
+//	使用增量处理记录批量处理图片,只重新生成原图或参数变化、或生成的文件缺失的图片
+//	manifestPath	string		记录文件路径
+//	paths		[]string	原图片路径
+//	newPaths	[]string	新图片路径
+//	formats		[]string	图片格式
+//	maxWHs		[]MediaWH	图片宽高
+//	quality		int		图片质量
+//	isPrint		bool		是否打印错误及提示信息
+//	opts		[]*ImgOptions	每张图片的可选参数,长度为1时所有图片共用
+//	返回值		[]*ImgResult	处理结果
+//	返回值		error		错误信息
+func ImgResizesIncremental(manifestPath string, paths []string, newPaths []string, formats []string, maxWHs []MediaWH, quality int, isPrint bool, opts []*ImgOptions) ([]*ImgResult, error) {
+	results := []*ImgResult{}
+	m, err := LoadManifest(manifestPath)
+	if err != nil {
+		return results, err
+	}
+	for i := 0; i < len(paths); i++ {
+		var opt *ImgOptions
+		if len(opts) == 1 {
+			opt = opts[0]
+		} else if i < len(opts) {
+			opt = opts[i]
+		}
+		res, err := m.ImgResize(paths[i], newPaths[i], formats, maxWHs, quality, isPrint, opt)
+		if err != nil {
+			// 保存已完成的记录,下次运行时跳过
+			m.Save()
+			return results, err
+		}
+		results = append(results, res)
+	}
+	return results, m.Save()
+}
+
+func filesExist(paths []string) bool {
+	for _, p := range paths {
+		info, err := os.Stat(p)
+		if err != nil || info.Size() == 0 {
+			return false
+		}
+	}
+	return true
+}
+
+// ========================
+//
+//	计算文件内容的sha256
+//	path		string		文件路径
+//	返回值		string		sha256
+//	返回值		error		错误信息
+func fileHash(path string) (string, error) {
+	file, err := os.Open(path)
+	if err != nil {
+		return "", err
+	}
+	defer file.Close()
+	h := sha256.New()
+	if _, err = io.Copy(h, file); err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(h.Sum(nil)), nil
+}
@@ -0,0 +1,58 @@
+package mediaResize
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func TestImgResizesIncremental(t *testing.T) {
+	dir := t.TempDir()
+	manifest := filepath.Join(dir, "manifest.json")
+	paths := []string{filepath.Join(dir, "a.png"), filepath.Join(dir, "b.png")}
+	newPaths := []string{filepath.Join(dir, "out", "a.png"), filepath.Join(dir, "out", "b.png")}
+	for _, p := range paths {
+		newTestImage(t, p, 200, 100)
+	}
+	os.MkdirAll(filepath.Join(dir, "out"), os.ModePerm)
+	maxWHs := []MediaWH{{Width: 100, Height: 100}}
+	run := func(quality int) []bool {
+		results, err := ImgResizesIncremental(manifest, paths, newPaths, []string{"jpg"}, maxWHs, quality, false, nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+		skipped := []bool{}
+		for _, res := range results {
+			if len(res.Paths) == 0 {
+				t.Fatal("missing paths in result")
+			}
+			skipped = append(skipped, res.Skipped)
+		}
+		return skipped
+	}
+	check := func(name string, got []bool, want ...bool) {
+		for i := range want {
+			if got[i] != want[i] {
+				t.Errorf("%s: skipped %v, want %v", name, got, want)
+				return
+			}
+		}
+	}
+
+	check("first run", run(80), false, false)
+	check("unchanged", run(80), true, true)
+
+	// 只修改时间变化时不重新处理
+	later := time.Now().Add(time.Minute)
+	os.Chtimes(paths[0], later, later)
+	check("touched", run(80), true, true)
+
+	newTestImage(t, paths[0], 300, 100)
+	check("changed source", run(80), false, true)
+
+	os.Remove(filepath.Join(dir, "out", "b.S.png"))
+	check("missing output", run(80), true, false)
+
+	check("changed options", run(60), false, false)
+}
@@ -0,0 +1,141 @@
+package mediaResize
+
+import (
+	"net/http"
+	"strconv"
+	"strings"
+)
+
+// FormatAuto 根据Accept请求头选择输出格式
+const FormatAuto = "auto"
+
+// DefaultFormatPreference 默认的格式优先级,Accept中权重相同时按此顺序选择
+var DefaultFormatPreference = []string{"avif", "webp", "jpg", "png", "gif"}
+
+// 所有客户端都支持的格式,Accept中只有通配符时也可以使用
+var baselineFormats = map[string]bool{"jpg": true, "png": true, "gif": true}
+
+// acceptRange Accept请求头中的一项
+type acceptRange struct {
+	mediaType string  //如 image/webp, image/*, */*
+	q         float64 //权重
+}
+
+// ========================
+//
+//	根据Accept请求头从已生成的格式中选择输出格式
+//	webp、avif等格式只有在Accept中明确列出时才会选择,通配符只匹配jpg、png、gif
+//	accept		string		Accept请求头
+//	available	[]string	已生成的格式,如 ImgResult.Formats
+//	preference	[]string	格式优先级,为空时使用DefaultFormatPreference
+//	返回值		string		选择的格式,没有可接受的格式时为空
+func NegotiateFormat(accept string, available []string, preference []string) string {
+	if len(preference) == 0 {
+		preference = DefaultFormatPreference
+	}
+	ranges := parseAccept(accept)
+	best, bestQ, bestRank := "", 0.0, 0
+	for _, format := range available {
+		format = normalizeFormat(format)
+		q := acceptQuality(ranges, format)
+		if q <= 0 {
+			continue
+		}
+		rank := len(preference)
+		for i, p := range preference {
+			if normalizeFormat(p) == format {
+				rank = i
+				break
+			}
+		}
+		if best == "" || q > bestQ || (q == bestQ && rank < bestRank) {
+			best, bestQ, bestRank = format, q, rank
+		}
+	}
+	return best
+}
+
+// ========================
+//
+//	在Vary响应头中添加Accept,已存在时不重复添加
+//	header		http.Header	响应头
+func SetVary(header http.Header) {
+	for _, v := range header.Values("Vary") {
+		for _, item := range strings.Split(v, ",") {
+			item = strings.TrimSpace(item)
+			if item == "*" || strings.EqualFold(item, "Accept") {
+				return
+			}
+		}
+	}
+	header.Add("Vary", "Accept")
+}
+
+// ========================
+//
+//	解析Accept请求头,为空时视为 */*
+//	accept		string		Accept请求头
+//	返回值		[]acceptRange	解析结果
+func parseAccept(accept string) []acceptRange {
+	if strings.TrimSpace(accept) == "" {
+		return []acceptRange{{mediaType: "*/*", q: 1}}
+	}
+	ranges := []acceptRange{}
+	for _, item := range strings.Split(accept, ",") {
+		parts := strings.Split(item, ";")
+		r := acceptRange{mediaType: strings.ToLower(strings.TrimSpace(parts[0])), q: 1}
+		if r.mediaType == "" {
+			continue
+		}
+		for _, param := range parts[1:] {
+			k, v, _ := strings.Cut(strings.TrimSpace(param), "=")
+			if strings.EqualFold(k, "q") {
+				if q, err := strconv.ParseFloat(v, 64); err == nil {
+					r.q = q
+				}
+			}
+		}
+		ranges = append(ranges, r)
+	}
+	return ranges
+}
+
+// ========================
+//
+//	格式在Accept中的权重,使用最具体的匹配项
+//	ranges		[]acceptRange	Accept请求头
+//	format		string		格式
+//	返回值		float64		权重,0为不可接受
+func acceptQuality(ranges []acceptRange, format string) float64 {
+	mediaType := formatContentType(format)
+	if mediaType == "" {
+		return 0
+	}
+	q, specificity := 0.0, -1
+	for _, r := range ranges {
+		s := -1
+		switch {
+		case r.mediaType == mediaType:
+			s = 2
+		case !baselineFormats[format]:
+		case r.mediaType == "image/*":
+			s = 1
+		case r.mediaType == "*/*":
+			s = 0
+		}
+		if s > specificity {
+			q, specificity = r.q, s
+		}
+	}
+	return q
+}
+
+func normalizeFormat(format string) string {
+	switch format = strings.ToLower(format); format {
+	case "jpeg":
+		return "jpg"
+	case "tiff":
+		return "tif"
+	}
+	return format
+}
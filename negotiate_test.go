@@ -0,0 +1,71 @@
+package mediaResize
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"testing"
+)
+
+func TestNegotiateFormat(t *testing.T) {
+	generated := []string{"jpg", "webp", "avif"}
+	for _, c := range []struct {
+		accept     string
+		preference []string
+		want       string
+	}{
+		{"", nil, "jpg"},
+		{"*/*", nil, "jpg"},
+		{"image/avif,image/webp,image/apng,image/*,*/*;q=0.8", nil, "avif"},
+		{"image/webp,*/*", nil, "webp"},
+		{"image/avif,image/webp,*/*", []string{"webp", "avif"}, "webp"},
+		{"image/webp;q=0.5,image/jpeg", nil, "jpg"},
+		{"image/avif;q=0,image/webp", nil, "webp"},
+		{"text/html", nil, ""},
+	} {
+		if got := NegotiateFormat(c.accept, generated, c.preference); got != c.want {
+			t.Errorf("NegotiateFormat(%q, %v) = %q, want %q", c.accept, c.preference, got, c.want)
+		}
+	}
+}
+
+func TestSetVary(t *testing.T) {
+	h := http.Header{}
+	h.Set("Vary", "Accept-Encoding")
+	SetVary(h)
+	SetVary(h)
+	if v := h.Values("Vary"); len(v) != 2 || v[1] != "Accept" {
+		t.Error("Vary:", v)
+	}
+}
+
+func TestHandlerAutoFormat(t *testing.T) {
+	root := t.TempDir()
+	newTestImage(t, filepath.Join(root, "a.png"), 100, 100)
+	srv := httptest.NewServer(NewHandler(NewDirStorage(root)))
+	defer srv.Close()
+
+	etags := map[string]bool{}
+	for accept, want := range map[string]string{
+		"image/webp,*/*": "image/webp",
+		"*/*":            "image/jpeg",
+	} {
+		req, _ := http.NewRequest(http.MethodGet, srv.URL+"/w_50,f_auto/a.png", nil)
+		req.Header.Set("Accept", accept)
+		resp, err := http.DefaultClient.Do(req)
+		if err != nil {
+			t.Fatal(err)
+		}
+		resp.Body.Close()
+		if ct := resp.Header.Get("Content-Type"); ct != want {
+			t.Errorf("Accept %q: content type %q, want %q", accept, ct, want)
+		}
+		if resp.Header.Get("Vary") != "Accept" {
+			t.Error("missing Vary: Accept")
+		}
+		etags[resp.Header.Get("ETag")] = true
+	}
+	if len(etags) != 2 {
+		t.Error("ETag should differ per negotiated format")
+	}
+}
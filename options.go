@@ -0,0 +1,166 @@
+package mediaResize
+
+import (
+	"context"
+	"image"
+)
+
+const (
+	FitInside = ""      // 按最长边等比缩放(默认)
+	FitCover  = "cover" // 按预设宽高裁剪后填充
+
+	CropCenter = ""      // 居中裁剪(默认)
+	CropSmart  = "smart" // 按图片细节分布裁剪
+	CropFace   = "face"  // 按人脸位置裁剪,未检测到人脸时使用 FaceOptions.Fallback
+)
+
+// ImgOptions 图片处理的可选参数
+type ImgOptions struct {
+	Fit  string       `json:"fit,omitempty"`  //缩放方式: FitInside, FitCover
+	Crop string       `json:"crop,omitempty"` //裁剪策略: CropCenter, CropSmart, CropFace, 仅在 FitCover 时生效
+	Face *FaceOptions `json:"face,omitempty"` //人脸检测参数, 为nil时使用默认值
+
+	AutoOrient bool        `json:"autoOrient,omitempty"` //按EXIF方向信息旋转图片, 设置Region时总是启用
+	Region     *CropRegion `json:"region,omitempty"`     //生成各尺寸前的裁剪区域或焦点
+
+	Trim *TrimOptions `json:"trim,omitempty"` //去除纯色边框, 在Region裁剪之后进行
+
+	Watermark *WatermarkOptions `json:"watermark,omitempty"` //水印
+	Texts     []*TextOptions    `json:"texts,omitempty"`     //文字叠加, 按顺序绘制在水印之后
+
+	SourceProcessors []Processor `json:"-"` //打开原图后执行一次的处理器, 在Region裁剪之前执行
+	Processors       []Processor `json:"-"` //每个输出尺寸缩放后按顺序执行的处理器, 在水印和文字之前执行
+
+	Presets  []SizePreset              `json:"presets,omitempty"`  //尺寸预设, 不为空时代替maxWHs
+	Encoders map[string]EncoderOptions `json:"encoders,omitempty"` //各图片格式的编码参数
+	Naming   string                    `json:"naming,omitempty"`   //命名模板, 可使用{name} {ext} {size} {format}, 相对路径基于新图片路径所在目录
+
+	SkipSourceFormat bool `json:"skipSourceFormat,omitempty"` //formats不包含原图格式时不额外保存原图格式
+
+	Cache Cache `json:"-"` //处理结果缓存, 原图和参数相同时直接使用缓存的文件
+}
+
+// SizePreset 尺寸预设
+type SizePreset struct {
+	Name   string `json:"name,omitempty" yaml:"name,omitempty"`     //尺寸名称, 为空时按顺序使用 S M L XL..., 原尺寸为R
+	Width  int    `json:"width" yaml:"width"`                       //最大宽度, -1为不缩放
+	Height int    `json:"height" yaml:"height"`                     //最大高度, -1为不缩放
+	Fit    string `json:"fit,omitempty" yaml:"fit,omitempty"`       //缩放方式, 为空时使用ImgOptions.Fit
+	Filter string `json:"filter,omitempty" yaml:"filter,omitempty"` //重采样算法, 默认为lanczos
+}
+
+// EncoderOptions 图片编码参数
+type EncoderOptions struct {
+	Quality  int   `json:"quality,omitempty"`  //图片质量(1-100), 0为使用quality参数
+	Lossless *bool `json:"lossless,omitempty"` //webp是否使用无损压缩, 默认为true
+}
+
+// ========================
+//
+//	获取指定格式的编码参数
+//	format		string		图片格式
+//	quality		int		默认图片质量
+//	返回值		int		图片质量
+//	返回值		bool		webp是否使用无损压缩
+func (opts *ImgOptions) encoder(format string, quality int) (int, bool) {
+	enc, ok := opts.Encoders[format]
+	if !ok {
+		return quality, true
+	}
+	if enc.Quality != 0 {
+		quality = enc.Quality
+	}
+	lossless := true
+	if enc.Lossless != nil {
+		lossless = *enc.Lossless
+	}
+	return quality, lossless
+}
+
+// CropRegion 裁剪区域或焦点,坐标均相对于按EXIF旋转并执行SourceProcessors后的图片
+type CropRegion struct {
+	Rect     *CropRect   `json:"rect,omitempty"`     //裁剪区域,像素坐标
+	Relative *CropRect   `json:"relative,omitempty"` //裁剪区域,相对坐标(0-1)
+	Focus    *FocalPoint `json:"focus,omitempty"`    //焦点,相对坐标(0-1),作为FitCover的裁剪中心
+}
+
+// CropRect 裁剪区域
+type CropRect struct {
+	X      float64 `json:"x"`
+	Y      float64 `json:"y"`
+	Width  float64 `json:"width"`
+	Height float64 `json:"height"`
+}
+
+// FocalPoint 焦点
+type FocalPoint struct {
+	X float64 `json:"x"`
+	Y float64 `json:"y"`
+}
+
+// ImgResult 图片处理结果
+type ImgResult struct {
+	Paths   []string          `json:"paths"`             //新图片路径
+	Sizes   []string          `json:"sizes"`             //生成的尺寸名称
+	Formats []string          `json:"formats"`           //生成的图片格式
+	Faces   []image.Rectangle `json:"faces,omitempty"`   //检测到的人脸区域(按Region裁剪及去除边框后的坐标)
+	Trim    *image.Rectangle  `json:"trim,omitempty"`    //去除边框后保留的区域(按Region裁剪后的坐标)
+	Skipped bool              `json:"skipped,omitempty"` //增量处理时原图和参数均未变化而跳过
+	Files   []ResizeResult    `json:"files"`             //生成的文件,与Paths顺序相同
+}
+
+// ResizeResult 生成的单个文件
+type ResizeResult struct {
+	Path   string `json:"path"`   //文件路径
+	Size   string `json:"size"`   //尺寸名称
+	Format string `json:"format"` //格式
+}
+
+// VideoOptions 视频处理的可选参数
+type VideoOptions struct {
+	Watermark   *WatermarkOptions `json:"watermark,omitempty"`   //水印, 使用ffmpeg的overlay滤镜叠加
+	Encoding    *VideoEncoding    `json:"encoding,omitempty"`    //编码参数, 为nil时按codeRate编码并复制兼容的音频
+	Bitrates    []int             `json:"bitrates,omitempty"`    //各尺寸的视频码率(k), 与maxWHs顺序相同, 为0时使用AutoBitrate或codeRate
+	AutoBitrate *BitrateModel     `json:"autoBitrate,omitempty"` //按分辨率和帧率计算未指定尺寸的码率
+	Remux       bool              `json:"remux,omitempty"`       //原视频尺寸、编码相同且码率不超过目标码率时只重新封装
+
+	Cache      Cache                `json:"-"`                //处理结果缓存, 原视频和参数相同时直接使用缓存的文件
+	OnProgress func(*VideoProgress) `json:"-"`                //编码进度回调, 使用ffmpeg的 -progress 输出
+	Context    context.Context      `json:"-"`                //上下文, 取消时结束ffmpeg, 为nil时使用context.Background()
+	Naming     string               `json:"naming,omitempty"` //命名模板, 见ImgOptions.Naming, 为空时在文件名的每个"."前插入尺寸名称
+}
+
+// VideoResult 视频处理结果
+type VideoResult struct {
+	Paths   []string       `json:"paths"`   //新视频路径
+	Sizes   []string       `json:"sizes"`   //生成的尺寸名称
+	Formats []string       `json:"formats"` //生成的视频格式
+	Files   []ResizeResult `json:"files"`   //生成的文件,与Paths顺序相同
+}
+
+// VariantFilter 按尺寸筛选需要处理的输出
+type VariantFilter struct {
+	Sizes    []string `json:"sizes,omitempty"`    //只处理这些尺寸,如 {"L","XL","R"},为空时处理所有尺寸
+	MinWidth int      `json:"minWidth,omitempty"` //输出宽度小于该值时不处理
+}
+
+// ========================
+//
+//	判断指定尺寸是否需要处理
+//	imgSize		string		尺寸名称
+//	width		int		输出宽度
+//	返回值		bool		是否处理
+func (f VariantFilter) appliesTo(imgSize string, width int) bool {
+	if width < f.MinWidth {
+		return false
+	}
+	if len(f.Sizes) == 0 {
+		return true
+	}
+	for _, v := range f.Sizes {
+		if v == imgSize {
+			return true
+		}
+	}
+	return false
+}
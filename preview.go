@@ -0,0 +1,309 @@
+package mediaResize
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"os"
+	"os/exec"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// ErrPreviewTooLarge 降低帧率后预览仍超过MaxBytes
+var ErrPreviewTooLarge = errors.New("preview exceeds max bytes")
+
+// PreviewSegment 预览片段
+type PreviewSegment struct {
+	Start    time.Duration `json:"start"`    //开始时间
+	Duration time.Duration `json:"duration"` //时长
+}
+
+// PreviewOptions 动态预览参数
+type PreviewOptions struct {
+	Segments        []PreviewSegment `json:"segments,omitempty"`        //截取的片段,为空时按场景变化自动选择
+	Highlights      int              `json:"highlights,omitempty"`      //自动选择的片段数量,默认为3
+	SegmentDuration time.Duration    `json:"segmentDuration,omitempty"` //自动选择的片段时长,默认为1.5秒
+	SceneThreshold  float64          `json:"sceneThreshold,omitempty"`  //场景变化阈值(0-1),默认为0.3
+	FPS             float64          `json:"fps,omitempty"`             //最大帧率,gif/webp默认为10,mp4默认为24,不超过原视频帧率
+	MaxBytes        int64            `json:"maxBytes,omitempty"`        //单个文件最大字节数,超过时降低帧率重新编码,0为不限制
+	Quality         int              `json:"quality,omitempty"`         //webp质量(1-100),默认为75; mp4为crf,默认为28
+}
+
+// previewFormats 支持的预览格式
+var previewFormats = []string{"webp", "gif", "mp4"}
+
+// ========================
+//
+//	截取视频片段,按maxWHs缩放后生成无声循环的动态webp、gif(使用palettegen生成调色板)或mp4
+//	ctx			context.Context	上下文
+//	path		string		视频路径
+//	newPath		string		预览路径,与VideoResize相同在"."前插入尺寸名称
+//	formats		[]string	预览格式: webp, gif, mp4
+//	maxWHs		[]MediaWH	预览宽高
+//	opts		*PreviewOptions	参数,可以为nil
+//	返回值		*VideoResult	生成的文件
+//	返回值		error		错误信息
+func VideoPreview(ctx context.Context, path string, newPath string, formats []string, maxWHs []MediaWH, opts *PreviewOptions) (*VideoResult, error) {
+	res := &VideoResult{Paths: []string{}, Sizes: []string{}, Formats: []string{}, Files: []ResizeResult{}}
+	if opts == nil {
+		opts = &PreviewOptions{}
+	}
+	for _, f := range formats {
+		if !containsString(previewFormats, strings.ToLower(f)) {
+			return res, fmt.Errorf("unsupported preview format %q", f)
+		}
+	}
+	media, err := ProbeMedia(ctx, path)
+	if err != nil {
+		return res, err
+	}
+	src, err := media.DisplaySize()
+	if err != nil {
+		return res, err
+	}
+	duration := media.Duration()
+	segments := opts.Segments
+	if len(segments) == 0 {
+		if duration <= 0 {
+			return res, errors.New("unknown video duration")
+		}
+		segments, err = detectHighlights(ctx, path, opts, duration)
+		if err != nil {
+			return res, err
+		}
+	}
+	for _, s := range segments {
+		if s.Start < 0 || s.Duration <= 0 || (duration > 0 && s.Start >= duration) {
+			return res, fmt.Errorf("invalid preview segment %v+%v", s.Start, s.Duration)
+		}
+	}
+	srcFPS := 0.0
+	if v := media.VideoStream(); v != nil {
+		srcFPS = v.FPS
+	}
+
+	exists := map[string]bool{}
+	sizeNamei := 0
+	for _, wh := range maxWHs {
+		size := "R"
+		w, h := src.Width, src.Height
+		if wh.Width >= 0 && wh.Height >= 0 {
+			size = sizeName(sizeNamei)
+			sizeNamei++
+			w, h = calcResolutionRatio(src.Width, src.Height, wh.Width, wh.Height)
+		}
+		// yuv420p要求宽高为偶数
+		w, h = w&^1, h&^1
+		res.Sizes = append(res.Sizes, size)
+		for _, f := range formats {
+			f = strings.ToLower(f)
+			out := variantPath(newPath, size, f, "")
+			if err = encodePreview(ctx, path, out, segments, f, w, h, previewFPS(opts.FPS, f, srcFPS), opts); err != nil {
+				return res, err
+			}
+			res.Paths = append(res.Paths, out)
+			res.Files = append(res.Files, ResizeResult{Path: out, Size: size, Format: f})
+			if !exists[f] {
+				res.Formats = append(res.Formats, f)
+				exists[f] = true
+			}
+		}
+	}
+	return res, nil
+}
+
+// ========================
+//
+//	编码一个预览文件,超过MaxBytes时将帧率减半重新编码,最低为5
+//	ctx			context.Context	上下文
+//	path		string		视频路径
+//	out			string		预览路径
+//	segments	[]PreviewSegment	片段
+//	format		string		预览格式
+//	width		int		宽
+//	height		int		高
+//	fps			float64		帧率
+//	opts		*PreviewOptions	参数
+//	返回值		error		错误信息
+func encodePreview(ctx context.Context, path string, out string, segments []PreviewSegment, format string, width int, height int, fps float64, opts *PreviewOptions) error {
+	for {
+		args := previewArgs(path, out, segments, format, width, height, fps, opts.Quality)
+		if err := runFFmpeg(ctx, args, nil, nil); err != nil {
+			return err
+		}
+		if opts.MaxBytes <= 0 {
+			return nil
+		}
+		info, err := os.Stat(out)
+		if err != nil {
+			return err
+		}
+		if info.Size() <= opts.MaxBytes {
+			return nil
+		}
+		if fps <= 5 {
+			os.Remove(out)
+			return fmt.Errorf("%s: %w (%d > %d)", out, ErrPreviewTooLarge, info.Size(), opts.MaxBytes)
+		}
+		fps /= 2
+		if fps < 5 {
+			fps = 5
+		}
+	}
+}
+
+// ========================
+//
+//	预览的帧率,不超过原视频帧率
+//	max			float64		最大帧率,0为默认值
+//	format		string		预览格式
+//	src			float64		原视频帧率,0为未知
+//	返回值		float64		帧率
+func previewFPS(max float64, format string, src float64) float64 {
+	if max <= 0 {
+		max = 10
+		if format == "mp4" {
+			max = 24
+		}
+	}
+	if src > 0 && src < max {
+		return src
+	}
+	return max
+}
+
+// ========================
+//
+//	生成预览的ffmpeg参数,每个片段使用 -ss -t 作为单独的输入后拼接
+//	path		string		视频路径
+//	out			string		预览路径
+//	segments	[]PreviewSegment	片段
+//	format		string		预览格式
+//	width		int		宽
+//	height		int		高
+//	fps			float64		帧率
+//	quality		int		webp质量或mp4的crf,0为默认值
+//	返回值		[]string	ffmpeg参数
+func previewArgs(path string, out string, segments []PreviewSegment, format string, width int, height int, fps float64, quality int) []string {
+	args := []string{"-y"}
+	filter := ""
+	for i, s := range segments {
+		args = append(args, "-ss", seconds(s.Start), "-t", seconds(s.Duration), "-i", path)
+		filter += fmt.Sprintf("[%d:v]setpts=PTS-STARTPTS[s%d];", i, i)
+	}
+	for i := range segments {
+		filter += fmt.Sprintf("[s%d]", i)
+	}
+	filter += fmt.Sprintf("concat=n=%d:v=1:a=0,fps=%g,scale=%d:%d:flags=lanczos", len(segments), fps, width, height)
+
+	switch format {
+	case "gif":
+		// 使用片段生成的调色板,比默认的256色调色板颜色更准确
+		filter += ",split[a][b];[a]palettegen=stats_mode=diff[p];[b][p]paletteuse=dither=bayer:bayer_scale=5"
+		args = append(args, "-filter_complex", filter, "-loop", "0")
+	case "webp":
+		if quality <= 0 {
+			quality = 75
+		}
+		args = append(args, "-filter_complex", filter, "-c:v", "libwebp", "-lossless", "0", "-q:v", strconv.Itoa(quality), "-loop", "0")
+	case "mp4":
+		if quality <= 0 {
+			quality = 28
+		}
+		args = append(args, "-filter_complex", filter+",format=yuv420p", "-c:v", "libx264", "-crf", strconv.Itoa(quality), "-movflags", "+faststart")
+	}
+	return append(args, "-an", out)
+}
+
+// ========================
+//
+//	使用scene滤镜找出场景变化的时间,选择分布在整个视频中的片段
+//	ctx			context.Context	上下文
+//	path		string		视频路径
+//	opts		*PreviewOptions	参数
+//	duration	time.Duration	视频时长
+//	返回值		[]PreviewSegment	片段
+//	返回值		error		错误信息
+func detectHighlights(ctx context.Context, path string, opts *PreviewOptions, duration time.Duration) ([]PreviewSegment, error) {
+	threshold := opts.SceneThreshold
+	if threshold <= 0 {
+		threshold = 0.3
+	}
+	filter := fmt.Sprintf("select='gt(scene,%g)',showinfo", threshold)
+	cmd := exec.CommandContext(ctx, "ffmpeg", "-i", path, "-vf", filter, "-an", "-f", "null", "-")
+	output, err := cmd.CombinedOutput()
+	if err != nil {
+		return nil, fmt.Errorf("ffmpeg: %w: %s", err, lastLines(output, 10))
+	}
+	scenes := []time.Duration{}
+	for _, m := range showinfoTime.FindAllSubmatch(output, -1) {
+		if t, err := parseSeconds(string(m[1])); err == nil {
+			scenes = append(scenes, t)
+		}
+	}
+	return pickHighlights(scenes, opts.Highlights, opts.SegmentDuration, duration), nil
+}
+
+// ========================
+//
+//	将视频平均分为n段,每段使用第一个场景变化处作为片段开始,没有场景变化时使用该段中间;
+//	片段不重叠且不超过视频结尾,放不下的片段被丢弃
+//	scenes		[]time.Duration	场景变化的时间
+//	n			int		片段数量,默认为3
+//	segment		time.Duration	片段时长,默认为1.5秒
+//	duration	time.Duration	视频时长
+//	返回值		[]PreviewSegment	片段
+func pickHighlights(scenes []time.Duration, n int, segment time.Duration, duration time.Duration) []PreviewSegment {
+	if n <= 0 {
+		n = 3
+	}
+	if segment <= 0 {
+		segment = 1500 * time.Millisecond
+	}
+	// 视频太短时减少片段数量
+	if max := int(duration / segment); n > max {
+		n = max
+	}
+	if n <= 0 {
+		return []PreviewSegment{{Start: 0, Duration: duration}}
+	}
+	sort.Slice(scenes, func(i, j int) bool { return scenes[i] < scenes[j] })
+	window := duration / time.Duration(n)
+	segments := []PreviewSegment{}
+	for i := 0; i < n; i++ {
+		from, to := window*time.Duration(i), window*time.Duration(i+1)
+		start := from + (window-segment)/2
+		for _, t := range scenes {
+			if t >= from && t < to {
+				start = t
+				break
+			}
+		}
+		// 片段不超过视频结尾,也不与上一个片段重叠
+		if start+segment > duration {
+			start = duration - segment
+		}
+		if len(segments) > 0 {
+			if prev := segments[len(segments)-1]; start < prev.Start+prev.Duration {
+				start = prev.Start + prev.Duration
+			}
+		}
+		if start < 0 {
+			start = 0
+		}
+		// 避开上一个片段后超过视频结尾时不再生成片段
+		if start+segment > duration {
+			break
+		}
+		segments = append(segments, PreviewSegment{Start: start, Duration: segment})
+	}
+	return segments
+}
+
+// seconds ffmpeg使用的秒数
+func seconds(d time.Duration) string {
+	return strconv.FormatFloat(d.Seconds(), 'f', 3, 64)
+}
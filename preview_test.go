@@ -0,0 +1,121 @@
+package mediaResize
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestPickHighlights(t *testing.T) {
+	s := time.Second
+	cases := []struct {
+		name     string
+		scenes   []time.Duration
+		n        int
+		segment  time.Duration
+		duration time.Duration
+		want     []time.Duration
+	}{
+		{"scenes", []time.Duration{25 * s, 3 * s, 12 * s}, 3, 2 * s, 30 * s, []time.Duration{3 * s, 12 * s, 25 * s}},
+		{"no scenes", nil, 3, 2 * s, 30 * s, []time.Duration{4 * s, 14 * s, 24 * s}},
+		// 结尾的场景变化不超过视频时长
+		{"end", []time.Duration{29500 * time.Millisecond}, 1, 2 * s, 30 * s, []time.Duration{28 * s}},
+		// 不与上一个片段重叠
+		{"overlap", []time.Duration{9500 * time.Millisecond, 10500 * time.Millisecond}, 2, 2 * s, 20 * s, []time.Duration{9500 * time.Millisecond, 11500 * time.Millisecond}},
+		{"too short", nil, 3, 2 * s, 5 * s, []time.Duration{250 * time.Millisecond, 2750 * time.Millisecond}},
+		// 避开重叠后超过结尾的片段被丢弃
+		{"overlap end", []time.Duration{1400 * time.Millisecond, 2900 * time.Millisecond, 3 * s}, 3, 1500 * time.Millisecond, 4500 * time.Millisecond, []time.Duration{1400 * time.Millisecond, 2900 * time.Millisecond}},
+	}
+	for _, c := range cases {
+		got := pickHighlights(c.scenes, c.n, c.segment, c.duration)
+		if len(got) != len(c.want) {
+			t.Errorf("%s: got %+v", c.name, got)
+			continue
+		}
+		for i := range got {
+			if got[i].Start+got[i].Duration > c.duration {
+				t.Errorf("%s: segment %d = %+v ends after %v", c.name, i, got[i], c.duration)
+			}
+			if got[i].Start != c.want[i] || got[i].Duration != c.segment {
+				t.Errorf("%s: segment %d = %+v, want start %v", c.name, i, got[i], c.want[i])
+			}
+		}
+	}
+	if got := pickHighlights(nil, 0, 0, s); len(got) != 1 || got[0].Start != 0 || got[0].Duration != s {
+		t.Error("shorter than segment:", got)
+	}
+}
+
+func TestPreviewArgs(t *testing.T) {
+	segments := []PreviewSegment{{Start: 2 * time.Second, Duration: 1500 * time.Millisecond}, {Start: 10 * time.Second, Duration: time.Second}}
+	args := strings.Join(previewArgs("in.mp4", "out.gif", segments, "gif", 320, 180, 10, 0), " ")
+	for _, want := range []string{
+		"-ss 2.000 -t 1.500 -i in.mp4 -ss 10.000 -t 1.000 -i in.mp4",
+		"[0:v]setpts=PTS-STARTPTS[s0];[1:v]setpts=PTS-STARTPTS[s1];[s0][s1]concat=n=2:v=1:a=0,fps=10,scale=320:180:flags=lanczos",
+		"palettegen=stats_mode=diff[p];[b][p]paletteuse",
+		"-loop 0 -an out.gif",
+	} {
+		if !strings.Contains(args, want) {
+			t.Errorf("missing %q in\n%s", want, args)
+		}
+	}
+	args = strings.Join(previewArgs("in.mp4", "out.webp", segments, "webp", 320, 180, 10, 0), " ")
+	if !strings.Contains(args, "-c:v libwebp -lossless 0 -q:v 75 -loop 0") || strings.Contains(args, "palettegen") {
+		t.Error("webp args:", args)
+	}
+	args = strings.Join(previewArgs("in.mp4", "out.mp4", segments, "mp4", 320, 180, 24, 30), " ")
+	if !strings.Contains(args, "format=yuv420p -c:v libx264 -crf 30 -movflags +faststart -an out.mp4") {
+		t.Error("mp4 args:", args)
+	}
+}
+
+func TestPreviewFPS(t *testing.T) {
+	if fps := previewFPS(0, "gif", 30); fps != 10 {
+		t.Error("gif:", fps)
+	}
+	if fps := previewFPS(0, "mp4", 0); fps != 24 {
+		t.Error("mp4:", fps)
+	}
+	if fps := previewFPS(15, "webp", 12); fps != 12 {
+		t.Error("source cap:", fps)
+	}
+}
+
+func TestVideoPreview(t *testing.T) {
+	dir := t.TempDir()
+	src := filepath.Join(dir, "in.mp4")
+	newTestVideo(t, src, 320, 180, 6)
+
+	if _, err := VideoPreview(context.Background(), src, filepath.Join(dir, "p.gif"), []string{"avi"}, nil, nil); err == nil {
+		t.Error("expected unsupported format error")
+	}
+	res, err := VideoPreview(context.Background(), src, filepath.Join(dir, "p.gif"), []string{"gif", "webp", "mp4"},
+		[]MediaWH{{Width: 160, Height: 160}}, &PreviewOptions{Highlights: 2, SegmentDuration: time.Second})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(res.Paths) != 3 || strings.Join(res.Formats, ",") != "gif,webp,mp4" || res.Sizes[0] != "S" {
+		t.Fatalf("res: %+v", res)
+	}
+	for _, p := range res.Paths {
+		if info, err := os.Stat(p); err != nil || info.Size() == 0 {
+			t.Errorf("%s: %v", p, err)
+		}
+	}
+	media, err := ProbeMedia(context.Background(), res.Paths[2])
+	if err != nil {
+		t.Fatal(err)
+	}
+	if wh, _ := media.DisplaySize(); wh.Width != 160 || wh.Height != 90 || len(media.Audio) != 0 {
+		t.Errorf("mp4: %+v audio %d", wh, len(media.Audio))
+	}
+
+	_, err = VideoPreview(context.Background(), src, filepath.Join(dir, "big.gif"), []string{"gif"}, []MediaWH{{Width: -1, Height: -1}},
+		&PreviewOptions{Segments: []PreviewSegment{{Start: 0, Duration: 3 * time.Second}}, MaxBytes: 1})
+	if err == nil || !strings.Contains(err.Error(), ErrPreviewTooLarge.Error()) {
+		t.Error("expected too large error:", err)
+	}
+}
@@ -0,0 +1,315 @@
+package mediaResize
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"math"
+	"os/exec"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// ErrNoVideoStream 媒体文件中没有视频流
+var ErrNoVideoStream = errors.New("no video stream")
+
+// MediaInfo ffprobe解析的媒体文件信息
+type MediaInfo struct {
+	Format    MediaFormat      `json:"format"`    //容器信息
+	Video     []VideoStream    `json:"video"`     //视频流
+	Audio     []AudioStream    `json:"audio"`     //音频流
+	Subtitles []SubtitleStream `json:"subtitles"` //字幕流
+}
+
+// MediaFormat 容器信息
+type MediaFormat struct {
+	Name     string            `json:"name"`           //容器格式,如 mov,mp4,m4a,3gp,3g2,mj2
+	LongName string            `json:"longName"`       //容器格式全称
+	Duration time.Duration     `json:"duration"`       //时长
+	Size     int64             `json:"size"`           //文件大小
+	Bitrate  int64             `json:"bitrate"`        //总码率(bit/s)
+	Streams  int               `json:"streams"`        //流数量
+	Tags     map[string]string `json:"tags,omitempty"` //元数据,如 title,creation_time
+}
+
+// VideoStream 视频流
+type VideoStream struct {
+	Index    int               `json:"index"`              //流序号
+	Codec    string            `json:"codec"`              //编码,如 h264,hevc,vp9
+	Profile  string            `json:"profile,omitempty"`  //编码profile,如 High
+	Level    int               `json:"level,omitempty"`    //编码level,如 40
+	PixFmt   string            `json:"pixFmt,omitempty"`   //像素格式,如 yuv420p
+	Width    int               `json:"width"`              //编码宽度
+	Height   int               `json:"height"`             //编码高度
+	FPS      float64           `json:"fps"`                //平均帧率
+	Bitrate  int64             `json:"bitrate,omitempty"`  //码率(bit/s)
+	Duration time.Duration     `json:"duration,omitempty"` //时长
+	Frames   int64             `json:"frames,omitempty"`   //帧数
+	Rotation int               `json:"rotation"`           //播放时顺时针旋转的角度: 0,90,180,270
+	Language string            `json:"language,omitempty"` //语言
+	Default  bool              `json:"default"`            //是否默认流
+	Tags     map[string]string `json:"tags,omitempty"`     //元数据
+}
+
+// AudioStream 音频流
+type AudioStream struct {
+	Index         int               `json:"index"`                   //流序号
+	Codec         string            `json:"codec"`                   //编码,如 aac,opus
+	Profile       string            `json:"profile,omitempty"`       //编码profile,如 LC
+	SampleRate    int               `json:"sampleRate"`              //采样率
+	Channels      int               `json:"channels"`                //声道数
+	ChannelLayout string            `json:"channelLayout,omitempty"` //声道布局,如 stereo
+	Bitrate       int64             `json:"bitrate,omitempty"`       //码率(bit/s)
+	Duration      time.Duration     `json:"duration,omitempty"`      //时长
+	Language      string            `json:"language,omitempty"`      //语言
+	Default       bool              `json:"default"`                 //是否默认流
+	Tags          map[string]string `json:"tags,omitempty"`          //元数据
+}
+
+// SubtitleStream 字幕流
+type SubtitleStream struct {
+	Index    int               `json:"index"`              //流序号
+	Codec    string            `json:"codec"`              //编码,如 mov_text,subrip
+	Language string            `json:"language,omitempty"` //语言
+	Title    string            `json:"title,omitempty"`    //标题
+	Default  bool              `json:"default"`            //是否默认流
+	Tags     map[string]string `json:"tags,omitempty"`     //元数据
+}
+
+// probeOutput ffprobe -show_format -show_streams 的JSON输出
+type probeOutput struct {
+	Format struct {
+		FormatName     string            `json:"format_name"`
+		FormatLongName string            `json:"format_long_name"`
+		Duration       string            `json:"duration"`
+		Size           string            `json:"size"`
+		BitRate        string            `json:"bit_rate"`
+		NbStreams      int               `json:"nb_streams"`
+		Tags           map[string]string `json:"tags"`
+	} `json:"format"`
+	Streams []struct {
+		Index         int               `json:"index"`
+		CodecName     string            `json:"codec_name"`
+		CodecType     string            `json:"codec_type"`
+		Profile       string            `json:"profile"`
+		Level         int               `json:"level"`
+		PixFmt        string            `json:"pix_fmt"`
+		Width         int               `json:"width"`
+		Height        int               `json:"height"`
+		AvgFrameRate  string            `json:"avg_frame_rate"`
+		RFrameRate    string            `json:"r_frame_rate"`
+		BitRate       string            `json:"bit_rate"`
+		Duration      string            `json:"duration"`
+		NbFrames      string            `json:"nb_frames"`
+		SampleRate    string            `json:"sample_rate"`
+		Channels      int               `json:"channels"`
+		ChannelLayout string            `json:"channel_layout"`
+		Disposition   map[string]int    `json:"disposition"`
+		Tags          map[string]string `json:"tags"`
+		SideDataList  []struct {
+			Rotation *float64 `json:"rotation"`
+		} `json:"side_data_list"`
+	} `json:"streams"`
+}
+
+// ========================
+//
+//	使用ffprobe解析媒体文件的容器、视频、音频和字幕信息
+//	ctx			context.Context	上下文
+//	path		string		媒体文件路径
+//	返回值		*MediaInfo	媒体文件信息
+//	返回值		error		错误信息
+func ProbeMedia(ctx context.Context, path string) (*MediaInfo, error) {
+	cmd := exec.CommandContext(ctx, "ffprobe", "-v", "error", "-print_format", "json", "-show_format", "-show_streams", path)
+	output, err := cmd.Output()
+	if err != nil {
+		var exitErr *exec.ExitError
+		if errors.As(err, &exitErr) && len(exitErr.Stderr) > 0 {
+			return nil, errors.New("ffprobe: " + lastLines(exitErr.Stderr, 5))
+		}
+		return nil, err
+	}
+	return parseProbe(output)
+}
+
+// ========================
+//
+//	将ffprobe的JSON输出转换为MediaInfo
+//	output		[]byte		ffprobe输出
+//	返回值		*MediaInfo	媒体文件信息
+//	返回值		error		错误信息
+func parseProbe(output []byte) (*MediaInfo, error) {
+	var data probeOutput
+	if err := json.Unmarshal(output, &data); err != nil {
+		return nil, err
+	}
+	f := data.Format
+	info := &MediaInfo{
+		Format: MediaFormat{
+			Name:     f.FormatName,
+			LongName: f.FormatLongName,
+			Duration: probeSeconds(f.Duration),
+			Size:     probeInt(f.Size),
+			Bitrate:  probeInt(f.BitRate),
+			Streams:  f.NbStreams,
+			Tags:     f.Tags,
+		},
+		Video:     []VideoStream{},
+		Audio:     []AudioStream{},
+		Subtitles: []SubtitleStream{},
+	}
+	for _, s := range data.Streams {
+		isDefault := s.Disposition["default"] == 1
+		switch s.CodecType {
+		case "video":
+			// 封面图片以视频流的形式保存,不作为视频处理
+			if s.Disposition["attached_pic"] == 1 {
+				continue
+			}
+			fps := probeRate(s.AvgFrameRate)
+			if fps == 0 {
+				fps = probeRate(s.RFrameRate)
+			}
+			// 旧版本使用rotate标签,新版本使用displaymatrix(逆时针为正)
+			rotation := 0
+			if v, err := strconv.Atoi(s.Tags["rotate"]); err == nil {
+				rotation = v
+			}
+			for _, sd := range s.SideDataList {
+				if sd.Rotation != nil {
+					rotation = -int(math.Round(*sd.Rotation))
+				}
+			}
+			rotation = ((rotation % 360) + 360) % 360
+			info.Video = append(info.Video, VideoStream{
+				Index:    s.Index,
+				Codec:    s.CodecName,
+				Profile:  s.Profile,
+				Level:    s.Level,
+				PixFmt:   s.PixFmt,
+				Width:    s.Width,
+				Height:   s.Height,
+				FPS:      fps,
+				Bitrate:  probeInt(s.BitRate),
+				Duration: probeSeconds(s.Duration),
+				Frames:   probeInt(s.NbFrames),
+				Rotation: rotation,
+				Language: s.Tags["language"],
+				Default:  isDefault,
+				Tags:     s.Tags,
+			})
+		case "audio":
+			info.Audio = append(info.Audio, AudioStream{
+				Index:         s.Index,
+				Codec:         s.CodecName,
+				Profile:       s.Profile,
+				SampleRate:    int(probeInt(s.SampleRate)),
+				Channels:      s.Channels,
+				ChannelLayout: s.ChannelLayout,
+				Bitrate:       probeInt(s.BitRate),
+				Duration:      probeSeconds(s.Duration),
+				Language:      s.Tags["language"],
+				Default:       isDefault,
+				Tags:          s.Tags,
+			})
+		case "subtitle":
+			info.Subtitles = append(info.Subtitles, SubtitleStream{
+				Index:    s.Index,
+				Codec:    s.CodecName,
+				Language: s.Tags["language"],
+				Title:    s.Tags["title"],
+				Default:  isDefault,
+				Tags:     s.Tags,
+			})
+		}
+	}
+	return info, nil
+}
+
+// ========================
+//
+//	第一个视频流,有默认流时使用默认流
+//	返回值		*VideoStream	视频流,没有视频流时为nil
+func (m *MediaInfo) VideoStream() *VideoStream {
+	if len(m.Video) == 0 {
+		return nil
+	}
+	for i := range m.Video {
+		if m.Video[i].Default {
+			return &m.Video[i]
+		}
+	}
+	return &m.Video[0]
+}
+
+// ========================
+//
+//	视频按Rotation旋转后的显示宽高,与ffmpeg自动旋转后的输出方向一致
+//	返回值		*MediaWH	显示宽高
+//	返回值		error		没有视频流时返回ErrNoVideoStream
+func (m *MediaInfo) DisplaySize() (*MediaWH, error) {
+	v := m.VideoStream()
+	if v == nil {
+		return nil, ErrNoVideoStream
+	}
+	if v.Rotation == 90 || v.Rotation == 270 {
+		return &MediaWH{Width: v.Height, Height: v.Width}, nil
+	}
+	return &MediaWH{Width: v.Width, Height: v.Height}, nil
+}
+
+// ========================
+//
+//	媒体时长,容器中没有时长时使用视频流或音频流的时长
+//	返回值		time.Duration	时长
+func (m *MediaInfo) Duration() time.Duration {
+	if m.Format.Duration > 0 {
+		return m.Format.Duration
+	}
+	if v := m.VideoStream(); v != nil && v.Duration > 0 {
+		return v.Duration
+	}
+	for _, a := range m.Audio {
+		if a.Duration > 0 {
+			return a.Duration
+		}
+	}
+	return 0
+}
+
+// probeSeconds 解析ffprobe输出的秒数,N/A或为空时返回0
+func probeSeconds(s string) time.Duration {
+	d, err := parseSeconds(s)
+	if err != nil {
+		return 0
+	}
+	return d
+}
+
+// probeInt 解析ffprobe以字符串输出的整数,N/A或为空时返回0
+func probeInt(s string) int64 {
+	v, _ := strconv.ParseInt(s, 10, 64)
+	return v
+}
+
+// ========================
+//
+//	解析ffprobe输出的帧率
+//	s			string		帧率,如 30000/1001
+//	返回值		float64		帧率,无法解析时为0
+func probeRate(s string) float64 {
+	num, den, ok := strings.Cut(s, "/")
+	n, err := strconv.ParseFloat(num, 64)
+	if err != nil {
+		return 0
+	}
+	if !ok {
+		return n
+	}
+	d, err := strconv.ParseFloat(den, 64)
+	if err != nil || d == 0 {
+		return 0
+	}
+	return n / d
+}
@@ -0,0 +1,99 @@
+package mediaResize
+
+import (
+	"context"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+// 音频流在前、带封面图片、字幕和旋转信息的ffprobe输出
+const testProbeJSON = `{
+	"streams": [
+		{"index": 0, "codec_name": "aac", "codec_type": "audio", "profile": "LC", "sample_rate": "48000", "channels": 2,
+			"channel_layout": "stereo", "bit_rate": "128000", "duration": "10.010000",
+			"disposition": {"default": 1}, "tags": {"language": "eng"}},
+		{"index": 1, "codec_name": "h264", "codec_type": "video", "profile": "High", "level": 40, "pix_fmt": "yuv420p",
+			"width": 1920, "height": 1080, "avg_frame_rate": "30000/1001", "r_frame_rate": "30/1", "bit_rate": "4000000",
+			"duration": "10.000000", "nb_frames": "300", "disposition": {"default": 1}, "tags": {"language": "und"},
+			"side_data_list": [{"side_data_type": "Display Matrix", "rotation": -90}]},
+		{"index": 2, "codec_name": "mjpeg", "codec_type": "video", "width": 600, "height": 600,
+			"disposition": {"default": 0, "attached_pic": 1}},
+		{"index": 3, "codec_name": "mov_text", "codec_type": "subtitle",
+			"disposition": {"default": 0}, "tags": {"language": "chi", "title": "中文"}}
+	],
+	"format": {"format_name": "mov,mp4,m4a,3gp,3g2,mj2", "format_long_name": "QuickTime / MOV", "nb_streams": 4,
+		"duration": "10.010000", "size": "5242880", "bit_rate": "4190000", "tags": {"title": "test"}}
+}`
+
+func TestParseProbe(t *testing.T) {
+	info, err := parseProbe([]byte(testProbeJSON))
+	if err != nil {
+		t.Fatal(err)
+	}
+	f := info.Format
+	if f.Name != "mov,mp4,m4a,3gp,3g2,mj2" || f.Duration != 10010*time.Millisecond || f.Size != 5242880 || f.Bitrate != 4190000 || f.Streams != 4 || f.Tags["title"] != "test" {
+		t.Errorf("format: %+v", f)
+	}
+	// 封面图片不作为视频流
+	if len(info.Video) != 1 || len(info.Audio) != 1 || len(info.Subtitles) != 1 {
+		t.Fatalf("streams: %+v", info)
+	}
+	v := info.VideoStream()
+	if v.Index != 1 || v.Codec != "h264" || v.Profile != "High" || v.Level != 40 || v.PixFmt != "yuv420p" ||
+		v.FPS < 29.97 || v.FPS > 29.98 || v.Bitrate != 4000000 || v.Frames != 300 || v.Rotation != 90 || !v.Default {
+		t.Errorf("video: %+v", v)
+	}
+	a := info.Audio[0]
+	if a.Codec != "aac" || a.SampleRate != 48000 || a.Channels != 2 || a.ChannelLayout != "stereo" || a.Language != "eng" || !a.Default {
+		t.Errorf("audio: %+v", a)
+	}
+	if s := info.Subtitles[0]; s.Codec != "mov_text" || s.Language != "chi" || s.Title != "中文" {
+		t.Errorf("subtitle: %+v", s)
+	}
+	// 旋转90度后宽高互换
+	wh, err := info.DisplaySize()
+	if err != nil || wh.Width != 1080 || wh.Height != 1920 {
+		t.Errorf("display size: %+v %v", wh, err)
+	}
+	if info.Duration() != 10010*time.Millisecond {
+		t.Errorf("duration: %v", info.Duration())
+	}
+}
+
+func TestParseProbeAudioOnly(t *testing.T) {
+	info, err := parseProbe([]byte(`{"streams": [{"index": 0, "codec_type": "audio", "codec_name": "mp3", "duration": "3.5"}], "format": {"duration": "N/A"}}`))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err = info.DisplaySize(); err != ErrNoVideoStream {
+		t.Error("DisplaySize:", err)
+	}
+	if info.Duration() != 3500*time.Millisecond {
+		t.Error("duration:", info.Duration())
+	}
+}
+
+func TestProbeRate(t *testing.T) {
+	for s, want := range map[string]float64{"25/1": 25, "24": 24, "0/0": 0, "": 0, "N/A": 0} {
+		if got := probeRate(s); got != want {
+			t.Errorf("probeRate(%q) = %v", s, got)
+		}
+	}
+}
+
+func TestProbeMedia(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "a.mp4")
+	newTestVideo(t, path, 320, 240, 1)
+	info, err := ProbeMedia(context.Background(), path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(info.Video) != 1 || len(info.Audio) != 1 || info.Duration() <= 0 || info.VideoStream().FPS != 25 {
+		t.Errorf("info: %+v", info)
+	}
+	wh, err := DecodeFileWidthHeight(path, "video/mp4")
+	if err != nil || wh.Width != 320 || wh.Height != 240 {
+		t.Errorf("DecodeFileWidthHeight: %+v %v", wh, err)
+	}
+}
@@ -0,0 +1,461 @@
+package mediaResize
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"image"
+	"image/color"
+	"math"
+	"sort"
+	"strings"
+	"sync"
+
+	"github.com/disintegration/imaging"
+	"golang.org/x/image/font/opentype"
+)
+
+// Processor 图片处理器
+type Processor interface {
+	Apply(img image.Image) (image.Image, error)
+}
+
+// ProcessorFunc 将函数作为图片处理器使用
+type ProcessorFunc func(img image.Image) (image.Image, error)
+
+func (f ProcessorFunc) Apply(img image.Image) (image.Image, error) {
+	return f(img)
+}
+
+// VariantProcessor 只在部分尺寸执行的处理器
+type VariantProcessor struct {
+	Processor
+	VariantFilter
+}
+
+// ProcessorFactory 根据JSON参数创建处理器
+type ProcessorFactory func(params json.RawMessage) (Processor, error)
+
+var (
+	processorsMu sync.RWMutex
+	processors   = map[string]ProcessorFactory{}
+)
+
+func init() {
+	RegisterProcessor("rotate", newJSONFactory(func() Processor { return &RotateProcessor{} }))
+	RegisterProcessor("flip", newJSONFactory(func() Processor { return &FlipProcessor{} }))
+	RegisterProcessor("crop", newJSONFactory(func() Processor { return &CropProcessor{} }))
+	RegisterProcessor("resize", newJSONFactory(func() Processor { return &ResizeProcessor{} }))
+	RegisterProcessor("adjust", newJSONFactory(func() Processor { return &AdjustProcessor{} }))
+	RegisterProcessor("overlay", newJSONFactory(func() Processor { return &WatermarkOptions{} }))
+	RegisterProcessor("text", newJSONFactory(func() Processor { return &TextOptions{} }))
+	RegisterProcessor("sharpen", newJSONFactory(func() Processor { return &SharpenProcessor{} }))
+	RegisterProcessor("blur", newJSONFactory(func() Processor { return &BlurProcessor{} }))
+	RegisterProcessor("trim", newJSONFactory(func() Processor { return &TrimOptions{} }))
+}
+
+// ========================
+//
+//	注册处理器,同名处理器会被覆盖
+//	name		string			处理器名称
+//	factory		ProcessorFactory	创建处理器的函数
+func RegisterProcessor(name string, factory ProcessorFactory) {
+	processorsMu.Lock()
+	processors[strings.ToLower(name)] = factory
+	processorsMu.Unlock()
+}
+
+// ========================
+//
+//	根据名称和JSON参数创建已注册的处理器
+//	name		string		处理器名称
+//	params		json.RawMessage	处理器参数,可为空
+//	返回值		Processor	处理器
+//	返回值		error		错误信息
+func NewProcessor(name string, params json.RawMessage) (Processor, error) {
+	processorsMu.RLock()
+	factory, ok := processors[strings.ToLower(name)]
+	processorsMu.RUnlock()
+	if !ok {
+		return nil, fmt.Errorf("unknown processor: %s", name)
+	}
+	return factory(params)
+}
+
+// ========================
+//
+//	已注册的处理器名称
+//	返回值		[]string	处理器名称
+func ProcessorNames() []string {
+	processorsMu.RLock()
+	names := make([]string, 0, len(processors))
+	for name := range processors {
+		names = append(names, name)
+	}
+	processorsMu.RUnlock()
+	sort.Strings(names)
+	return names
+}
+
+func newJSONFactory(newProcessor func() Processor) ProcessorFactory {
+	return func(params json.RawMessage) (Processor, error) {
+		p := newProcessor()
+		if len(params) > 0 {
+			if err := json.Unmarshal(params, p); err != nil {
+				return nil, err
+			}
+		}
+		return p, nil
+	}
+}
+
+// ========================
+//
+//	按顺序执行处理器
+//	img			image.Image	图片
+//	ps			[]Processor	处理器
+//	imgSize		string		尺寸名称,为空时不按尺寸筛选
+//	返回值		image.Image	新图片
+//	返回值		error		错误信息
+func applyProcessors(img image.Image, ps []Processor, imgSize string) (image.Image, error) {
+	var err error
+	for _, p := range ps {
+		if f, ok := p.(interface{ appliesTo(string, int) bool }); ok && imgSize != "" && !f.appliesTo(imgSize, img.Bounds().Dx()) {
+			continue
+		}
+		img, err = p.Apply(img)
+		if err != nil {
+			return img, err
+		}
+	}
+	return img, nil
+}
+
+// RotateProcessor 逆时针旋转
+type RotateProcessor struct {
+	Angle      float64      `json:"angle"`                //旋转角度
+	Background *color.NRGBA `json:"background,omitempty"` //非90度倍数旋转时的背景色,默认为透明
+	VariantFilter
+}
+
+func (p *RotateProcessor) Apply(img image.Image) (image.Image, error) {
+	angle := math.Mod(p.Angle, 360)
+	if angle < 0 {
+		angle += 360
+	}
+	switch angle {
+	case 0:
+		return img, nil
+	case 90:
+		return imaging.Rotate90(img), nil
+	case 180:
+		return imaging.Rotate180(img), nil
+	case 270:
+		return imaging.Rotate270(img), nil
+	}
+	bg := color.NRGBA{}
+	if p.Background != nil {
+		bg = *p.Background
+	}
+	return imaging.Rotate(img, angle, bg), nil
+}
+
+// FlipProcessor 翻转
+type FlipProcessor struct {
+	Horizontal bool `json:"horizontal,omitempty"` //水平翻转
+	Vertical   bool `json:"vertical,omitempty"`   //垂直翻转
+	VariantFilter
+}
+
+func (p *FlipProcessor) Apply(img image.Image) (image.Image, error) {
+	if p.Horizontal {
+		img = imaging.FlipH(img)
+	}
+	if p.Vertical {
+		img = imaging.FlipV(img)
+	}
+	return img, nil
+}
+
+// CropProcessor 按位置裁剪为指定宽高
+type CropProcessor struct {
+	Width   int    `json:"width"`             //裁剪宽度
+	Height  int    `json:"height"`            //裁剪高度
+	Gravity string `json:"gravity,omitempty"` //裁剪位置,默认为GravityCenter
+	VariantFilter
+}
+
+func (p *CropProcessor) Apply(img image.Image) (image.Image, error) {
+	if p.Width <= 0 || p.Height <= 0 {
+		return img, fmt.Errorf("invalid crop size: %dx%d", p.Width, p.Height)
+	}
+	gravity := p.Gravity
+	if gravity == "" {
+		gravity = GravityCenter
+	}
+	return imaging.CropAnchor(img, p.Width, p.Height, gravityAnchor(gravity)), nil
+}
+
+// ResizeProcessor 缩放
+type ResizeProcessor struct {
+	Width  int    `json:"width,omitempty"`  //宽度,0为按高度等比缩放
+	Height int    `json:"height,omitempty"` //高度,0为按宽度等比缩放
+	Fit    string `json:"fit,omitempty"`    //宽高均不为0时的缩放方式: 为空时拉伸, FitCover 裁剪填充, "inside" 等比缩放到宽高以内
+	Filter string `json:"filter,omitempty"` //重采样算法,默认为lanczos
+	VariantFilter
+}
+
+func (p *ResizeProcessor) Apply(img image.Image) (image.Image, error) {
+	if p.Width < 0 || p.Height < 0 || (p.Width == 0 && p.Height == 0) {
+		return img, fmt.Errorf("invalid resize size: %dx%d", p.Width, p.Height)
+	}
+	filter, err := resampleFilter(p.Filter)
+	if err != nil {
+		return img, err
+	}
+	if p.Width > 0 && p.Height > 0 {
+		switch p.Fit {
+		case FitCover:
+			return imaging.Fill(img, p.Width, p.Height, imaging.Center, filter), nil
+		case "inside":
+			return imaging.Fit(img, p.Width, p.Height, filter), nil
+		}
+	}
+	return imaging.Resize(img, p.Width, p.Height, filter), nil
+}
+
+// AdjustProcessor 调整亮度、对比度等
+type AdjustProcessor struct {
+	Brightness float64 `json:"brightness,omitempty"` //亮度(-100至100)
+	Contrast   float64 `json:"contrast,omitempty"`   //对比度(-100至100)
+	Saturation float64 `json:"saturation,omitempty"` //饱和度(-100至100)
+	Gamma      float64 `json:"gamma,omitempty"`      //伽马值,0和1为不调整
+	Grayscale  bool    `json:"grayscale,omitempty"`  //转为灰度图
+	Invert     bool    `json:"invert,omitempty"`     //反色
+	VariantFilter
+}
+
+func (p *AdjustProcessor) Apply(img image.Image) (image.Image, error) {
+	if p.Brightness != 0 {
+		img = imaging.AdjustBrightness(img, p.Brightness)
+	}
+	if p.Contrast != 0 {
+		img = imaging.AdjustContrast(img, p.Contrast)
+	}
+	if p.Saturation != 0 {
+		img = imaging.AdjustSaturation(img, p.Saturation)
+	}
+	if p.Gamma > 0 && p.Gamma != 1 {
+		img = imaging.AdjustGamma(img, p.Gamma)
+	}
+	if p.Grayscale {
+		img = imaging.Grayscale(img)
+	}
+	if p.Invert {
+		img = imaging.Invert(img)
+	}
+	return img, nil
+}
+
+// SharpenProcessor 锐化
+type SharpenProcessor struct {
+	Sigma float64 `json:"sigma"` //锐化程度
+	VariantFilter
+}
+
+func (p *SharpenProcessor) Apply(img image.Image) (image.Image, error) {
+	if p.Sigma <= 0 {
+		return img, nil
+	}
+	return imaging.Sharpen(img, p.Sigma), nil
+}
+
+// BlurProcessor 高斯模糊
+type BlurProcessor struct {
+	Sigma float64 `json:"sigma"` //模糊程度
+	VariantFilter
+}
+
+func (p *BlurProcessor) Apply(img image.Image) (image.Image, error) {
+	if p.Sigma <= 0 {
+		return img, nil
+	}
+	return imaging.Blur(img, p.Sigma), nil
+}
+
+// 水印作为处理器使用
+func (wm *WatermarkOptions) Apply(img image.Image) (image.Image, error) {
+	return ApplyWatermark(img, wm)
+}
+
+// 去除纯色边框作为处理器使用
+func (opts *TrimOptions) Apply(img image.Image) (image.Image, error) {
+	newImage, _ := TrimImage(img, opts)
+	return newImage, nil
+}
+
+// 文字叠加作为处理器使用
+func (opts *TextOptions) Apply(img image.Image) (image.Image, error) {
+	return DrawText(img, opts)
+}
+
+// errNotSmaller 目标宽高不小于原图,不生成该尺寸
+var errNotSmaller = errors.New("variant is not smaller than the source")
+
+// regionStep 按CropRegion裁剪,执行后focus为焦点在裁剪后图片中的坐标
+type regionStep struct {
+	region *CropRegion
+	focus  *image.Point
+}
+
+func (s *regionStep) Apply(img image.Image) (image.Image, error) {
+	newImage, focus, err := applyCropRegion(img, s.region)
+	s.focus = focus
+	return newImage, err
+}
+
+// trimStep 去除纯色边框,执行后rect为保留的区域
+type trimStep struct {
+	opts *TrimOptions
+	rect image.Rectangle
+}
+
+func (s *trimStep) Apply(img image.Image) (image.Image, error) {
+	newImage, rect := TrimImage(img, s.opts)
+	s.rect = rect
+	return newImage, nil
+}
+
+// fitStep 按长边等比缩放,原图不大于目标宽高时返回errNotSmaller
+type fitStep struct {
+	width  int
+	height int
+	filter imaging.ResampleFilter
+}
+
+func (s *fitStep) Apply(img image.Image) (image.Image, error) {
+	b := img.Bounds()
+	if b.Dx() >= b.Dy() {
+		if b.Dx() > s.width {
+			return imaging.Resize(img, s.width, 0, s.filter), nil
+		}
+	} else if b.Dy() > s.height {
+		return imaging.Resize(img, 0, s.height, s.filter), nil
+	}
+	return img, errNotSmaller
+}
+
+// coverStep 以focus为中心裁剪填充,裁剪区域不大于目标宽高时返回errNotSmaller
+type coverStep struct {
+	width  int
+	height int
+	focus  image.Point
+	filter imaging.ResampleFilter
+}
+
+func (s *coverStep) Apply(img image.Image) (image.Image, error) {
+	newImage, resized := coverImage(img, s.width, s.height, s.focus, s.filter)
+	if !resized {
+		return img, errNotSmaller
+	}
+	return newImage, nil
+}
+
+// aspectStep 按目标宽高比例及裁剪策略裁剪,不缩放,用于原图小于目标宽高时的裁剪填充
+type aspectStep struct {
+	width  int
+	height int
+	opts   *ImgOptions
+}
+
+func (s *aspectStep) Apply(img image.Image) (image.Image, error) {
+	focus, err := cropFocus(img, s.opts, &ImgResult{})
+	if err != nil {
+		return img, err
+	}
+	newImage, _ := coverImage(img, s.width, s.height, focus, imaging.Lanczos)
+	return newImage, nil
+}
+
+func (s *aspectStep) CacheKey() string {
+	return fmt.Sprintf("%dx%d", s.width, s.height)
+}
+
+// watermarkStep 叠加已加载的水印,按水印的VariantFilter筛选尺寸
+type watermarkStep struct {
+	mark image.Image
+	opts *WatermarkOptions
+}
+
+func (s *watermarkStep) Apply(img image.Image) (image.Image, error) {
+	return drawWatermark(img, s.mark, s.opts), nil
+}
+
+func (s *watermarkStep) appliesTo(imgSize string, width int) bool {
+	return s.opts.appliesTo(imgSize, width)
+}
+
+// textStep 使用已加载的字体叠加文字,按文字的VariantFilter筛选尺寸
+type textStep struct {
+	font *opentype.Font
+	opts *TextOptions
+}
+
+func (s *textStep) Apply(img image.Image) (image.Image, error) {
+	return drawText(img, s.font, s.opts)
+}
+
+func (s *textStep) appliesTo(imgSize string, width int) bool {
+	return s.opts.appliesTo(imgSize, width)
+}
+
+// ========================
+//
+//	根据名称获取重采样算法
+//	name		string			算法名称
+//	返回值		imaging.ResampleFilter	重采样算法
+//	返回值		error			错误信息
+func resampleFilter(name string) (imaging.ResampleFilter, error) {
+	switch strings.ToLower(name) {
+	case "", "lanczos":
+		return imaging.Lanczos, nil
+	case "catmullrom":
+		return imaging.CatmullRom, nil
+	case "mitchell":
+		return imaging.MitchellNetravali, nil
+	case "linear":
+		return imaging.Linear, nil
+	case "box":
+		return imaging.Box, nil
+	case "nearest":
+		return imaging.NearestNeighbor, nil
+	}
+	return imaging.Lanczos, fmt.Errorf("unknown resample filter: %s", name)
+}
+
+// ========================
+//
+//	将位置转换为imaging的锚点
+//	gravity		string		位置
+//	返回值		imaging.Anchor	锚点
+func gravityAnchor(gravity string) imaging.Anchor {
+	switch gravity {
+	case GravityNorth:
+		return imaging.Top
+	case GravitySouth:
+		return imaging.Bottom
+	case GravityEast:
+		return imaging.Right
+	case GravityWest:
+		return imaging.Left
+	case GravityNorthEast:
+		return imaging.TopRight
+	case GravityNorthWest:
+		return imaging.TopLeft
+	case GravitySouthEast:
+		return imaging.BottomRight
+	case GravitySouthWest:
+		return imaging.BottomLeft
+	}
+	return imaging.Center
+}
@@ -0,0 +1,119 @@
+package mediaResize
+
+import (
+	"encoding/json"
+	"image"
+	"image/color"
+	"path/filepath"
+	"testing"
+
+	"github.com/disintegration/imaging"
+)
+
+// 注册测试用的处理器,测试结束时恢复原来的注册
+func registerTestProcessor(t *testing.T, name string, factory ProcessorFactory) {
+	processorsMu.RLock()
+	prev, ok := processors[name]
+	processorsMu.RUnlock()
+	RegisterProcessor(name, factory)
+	t.Cleanup(func() {
+		processorsMu.Lock()
+		defer processorsMu.Unlock()
+		if ok {
+			processors[name] = prev
+		} else {
+			delete(processors, name)
+		}
+	})
+}
+
+func TestProcessors(t *testing.T) {
+	t.Run("pipeline", testProcessorPipeline)
+	t.Run("order", testProcessorOrder)
+	for _, name := range ProcessorNames() {
+		if name == "record" {
+			t.Error("test processor is still registered")
+		}
+	}
+}
+
+func testProcessorPipeline(t *testing.T) {
+	dir := t.TempDir()
+	src := filepath.Join(dir, "proc.png")
+	newTestImage(t, src, 400, 200)
+
+	rotate, err := NewProcessor("rotate", []byte(`{"angle":90}`))
+	if err != nil {
+		t.Fatal("NewProcessor failed:", err)
+	}
+	calls := []int{}
+	registerTestProcessor(t, "record", func(params json.RawMessage) (Processor, error) {
+		return ProcessorFunc(func(img image.Image) (image.Image, error) {
+			calls = append(calls, img.Bounds().Dy())
+			return img, nil
+		}), nil
+	})
+	record, err := NewProcessor("record", nil)
+	if err != nil {
+		t.Fatal("NewProcessor failed:", err)
+	}
+	if _, err = NewProcessor("missing", nil); err == nil {
+		t.Error("expected error for unknown processor")
+	}
+
+	res, err := ImgResizeWithOptions(src, filepath.Join(dir, "out.png"), []string{"png"}, []MediaWH{{Width: -1, Height: -1}, {Width: 100, Height: 100}}, -1, false, &ImgOptions{
+		SourceProcessors: []Processor{rotate},
+		Processors: []Processor{
+			&VariantProcessor{Processor: record, VariantFilter: VariantFilter{Sizes: []string{"S"}}},
+			&AdjustProcessor{Grayscale: true},
+		},
+	})
+	if err != nil {
+		t.Fatal("ImgResizeWithOptions failed:", err)
+	}
+	if len(calls) != 1 || calls[0] != 100 {
+		t.Error("unexpected processor calls:", calls)
+	}
+	img, err := imaging.Open(res.Paths[0])
+	if err != nil {
+		t.Fatal("imaging.Open failed:", err)
+	}
+	if img.Bounds().Dx() != 200 || img.Bounds().Dy() != 400 {
+		t.Error("unexpected size:", img.Bounds())
+	}
+}
+
+// 每个尺寸依次执行缩放、Processors、水印
+func testProcessorOrder(t *testing.T) {
+	dir := t.TempDir()
+	src := filepath.Join(dir, "order.png")
+	if err := imaging.Save(imaging.New(400, 400, color.White), src); err != nil {
+		t.Fatal("imaging.Save:", err)
+	}
+	var seen image.Rectangle
+	var corner color.Color
+	res, err := ImgResizeWithOptions(src, filepath.Join(dir, "out.png"), []string{"png"}, []MediaWH{{Width: 100, Height: 100}}, -1, false, &ImgOptions{
+		Processors: []Processor{ProcessorFunc(func(img image.Image) (image.Image, error) {
+			seen = img.Bounds()
+			corner = img.At(seen.Max.X-1, seen.Max.Y-1)
+			return img, nil
+		})},
+		Watermark: &WatermarkOptions{Image: imaging.New(20, 20, color.NRGBA{R: 255, A: 255})},
+	})
+	if err != nil {
+		t.Fatal("ImgResizeWithOptions failed:", err)
+	}
+	if seen.Dx() != 100 || seen.Dy() != 100 {
+		t.Error("processor ran before resize:", seen)
+	}
+	if c := color.NRGBAModel.Convert(corner); c != (color.NRGBA{R: 255, G: 255, B: 255, A: 255}) {
+		t.Error("processor ran after watermark:", c)
+	}
+	out, err := imaging.Open(res.Paths[0])
+	if err != nil {
+		t.Fatal("imaging.Open failed:", err)
+	}
+	if c := color.NRGBAModel.Convert(out.At(99, 99)); c != (color.NRGBA{R: 255, A: 255}) {
+		t.Error("watermark missing:", c)
+	}
+}
@@ -0,0 +1,233 @@
+package mediaResize
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"fmt"
+	"io"
+	"os"
+	"os/exec"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// VideoProgress ffmpeg编码进度
+type VideoProgress struct {
+	Path     string        `json:"path"`     //正在生成的文件路径
+	Index    int           `json:"index"`    //正在生成第几个文件,从0开始
+	Count    int           `json:"count"`    //需要生成的文件数量
+	Percent  float64       `json:"percent"`  //当前文件的进度(0-100),无法获取时长时为0
+	OutTime  time.Duration `json:"outTime"`  //已编码的时长
+	Duration time.Duration `json:"duration"` //原视频时长,无法获取时为0
+	Frame    int64         `json:"frame"`    //已编码的帧数
+	FPS      float64       `json:"fps"`      //每秒编码的帧数
+	Speed    float64       `json:"speed"`    //编码速度,1为实时
+	Bitrate  float64       `json:"bitrate"`  //当前码率(kbit/s)
+	Size     int64         `json:"size"`     //已写入的字节数
+	ETA      time.Duration `json:"eta"`      //预计剩余时间,无法计算时为0
+	Done     bool          `json:"done"`     //当前文件是否编码完成
+}
+
+// ========================
+//
+//	所有文件的总进度
+//	返回值		float64		总进度(0-1)
+func (p *VideoProgress) Overall() float64 {
+	if p.Count <= 0 {
+		return 0
+	}
+	percent := p.Percent
+	if p.Done {
+		percent = 100
+	}
+	return (float64(p.Index) + percent/100) / float64(p.Count)
+}
+
+// ========================
+//
+//	将进度回调转换为channel,处理结束后调用返回的close函数关闭channel;回调不会阻塞,停止读取channel不影响编码
+//	buffer		int		channel缓冲大小,最小为1,缓冲已满时丢弃进度,完成事件丢弃最早的进度后放入
+//	返回值		func(*VideoProgress)	用于VideoOptions.OnProgress的回调
+//	返回值		<-chan VideoProgress	进度channel
+//	返回值		func()		关闭channel
+func ProgressChan(buffer int) (func(*VideoProgress), <-chan VideoProgress, func()) {
+	if buffer < 1 {
+		buffer = 1
+	}
+	ch := make(chan VideoProgress, buffer)
+	send := func(p *VideoProgress) {
+		for {
+			select {
+			case ch <- *p:
+				return
+			default:
+			}
+			if !p.Done {
+				return
+			}
+			// 完成事件不丢弃,缓冲已满时丢弃最早的进度
+			select {
+			case <-ch:
+			default:
+			}
+		}
+	}
+	return send, ch, func() { close(ch) }
+}
+
+// ========================
+//
+//	运行ffmpeg,onProgress不为nil时使用 -progress pipe:1 读取进度
+//	ctx			context.Context	上下文,取消时结束ffmpeg
+//	args		[]string	ffmpeg参数,最后一个为输出路径
+//	progress	*VideoProgress	进度模板,包含Path、Index、Count和Duration
+//	onProgress	func(*VideoProgress)	进度回调,为nil时不读取进度
+//	返回值		error		错误信息,包含ffmpeg的输出,ctx取消时包含ctx.Err();失败时删除不完整的输出文件
+func runFFmpeg(ctx context.Context, args []string, progress *VideoProgress, onProgress func(*VideoProgress)) error {
+	err := execFFmpeg(ctx, args, progress, onProgress)
+	if err != nil && len(args) > 0 {
+		os.Remove(args[len(args)-1])
+	}
+	return err
+}
+
+// execFFmpeg 运行ffmpeg并读取进度,不处理输出文件
+func execFFmpeg(ctx context.Context, args []string, progress *VideoProgress, onProgress func(*VideoProgress)) error {
+	if onProgress == nil {
+		output, err := exec.CommandContext(ctx, "ffmpeg", args...).CombinedOutput()
+		if ctx.Err() != nil {
+			return fmt.Errorf("ffmpeg: %w", ctx.Err())
+		}
+		if err != nil {
+			return fmt.Errorf("ffmpeg: %w: %s", err, lastLines(output, 10))
+		}
+		return nil
+	}
+	args = append([]string{"-progress", "pipe:1", "-nostats"}, args...)
+	cmd := exec.CommandContext(ctx, "ffmpeg", args...)
+	stderr := &bytes.Buffer{}
+	cmd.Stderr = stderr
+	stdout, err := cmd.StdoutPipe()
+	if err != nil {
+		return err
+	}
+	if err = cmd.Start(); err != nil {
+		return err
+	}
+	start := time.Now()
+	parseProgress(stdout, progress, func(p *VideoProgress) {
+		// 已完成的事件在ffmpeg成功退出后再发送
+		if !p.Done {
+			p.ETA = progressETA(p, time.Since(start))
+			onProgress(p)
+		}
+	})
+	if err = cmd.Wait(); ctx.Err() != nil {
+		return fmt.Errorf("ffmpeg: %w", ctx.Err())
+	} else if err != nil {
+		return fmt.Errorf("ffmpeg: %w: %s", err, lastLines(stderr.Bytes(), 10))
+	}
+	done := *progress
+	done.Percent, done.ETA, done.Done = 100, 0, true
+	onProgress(&done)
+	return nil
+}
+
+// ========================
+//
+//	解析ffmpeg -progress输出的 key=value 行,每遇到 progress= 行回调一次
+//	r			io.Reader	ffmpeg -progress输出
+//	progress	*VideoProgress	进度模板,解析结果写入其中
+//	fn			func(*VideoProgress)	回调
+func parseProgress(r io.Reader, progress *VideoProgress, fn func(*VideoProgress)) {
+	scanner := bufio.NewScanner(r)
+	for scanner.Scan() {
+		key, value, ok := strings.Cut(strings.TrimSpace(scanner.Text()), "=")
+		if !ok {
+			continue
+		}
+		value = strings.TrimSpace(value)
+		switch key {
+		case "frame":
+			progress.Frame, _ = strconv.ParseInt(value, 10, 64)
+		case "fps":
+			progress.FPS, _ = strconv.ParseFloat(value, 64)
+		case "bitrate":
+			// 如 1523.4kbits/s, 未知时为 N/A
+			if v, err := strconv.ParseFloat(strings.TrimSuffix(value, "kbits/s"), 64); err == nil {
+				progress.Bitrate = v
+			}
+		case "total_size":
+			if v, err := strconv.ParseInt(value, 10, 64); err == nil {
+				progress.Size = v
+			}
+		case "out_time_us", "out_time_ms":
+			// 旧版本ffmpeg的out_time_ms实际单位也是微秒
+			if v, err := strconv.ParseInt(value, 10, 64); err == nil && v >= 0 {
+				progress.OutTime = time.Duration(v) * time.Microsecond
+			}
+		case "speed":
+			if v, err := strconv.ParseFloat(strings.TrimSuffix(value, "x"), 64); err == nil {
+				progress.Speed = v
+			}
+		case "progress":
+			progress.Done = value == "end"
+			progress.Percent = 0
+			if progress.Duration > 0 {
+				progress.Percent = float64(progress.OutTime) / float64(progress.Duration) * 100
+				if progress.Percent > 100 {
+					progress.Percent = 100
+				}
+			}
+			if progress.Done {
+				progress.Percent = 100
+			}
+			p := *progress
+			fn(&p)
+		}
+	}
+	// 读取到文件末尾,避免ffmpeg因管道写满而阻塞
+	io.Copy(io.Discard, r)
+}
+
+// ========================
+//
+//	计算预计剩余时间,优先使用ffmpeg报告的速度
+//	p			*VideoProgress	进度
+//	elapsed		time.Duration	已用时间
+//	返回值		time.Duration	预计剩余时间
+func progressETA(p *VideoProgress, elapsed time.Duration) time.Duration {
+	if p.Duration <= 0 || p.OutTime <= 0 || p.OutTime >= p.Duration {
+		return 0
+	}
+	remaining := p.Duration - p.OutTime
+	if p.Speed > 0 {
+		return time.Duration(float64(remaining) / p.Speed)
+	}
+	return time.Duration(float64(elapsed) * float64(remaining) / float64(p.OutTime))
+}
+
+// ========================
+//
+//	将ffprobe输出的秒数转换为时长
+//	s			string		秒数,如 12.345000
+//	返回值		time.Duration	时长
+//	返回值		error		错误信息
+func parseSeconds(s string) (time.Duration, error) {
+	v, err := strconv.ParseFloat(s, 64)
+	if err != nil {
+		return 0, fmt.Errorf("invalid duration %q", s)
+	}
+	return time.Duration(v * float64(time.Second)), nil
+}
+
+// lastLines 返回输出的最后n行,用于错误信息
+func lastLines(output []byte, n int) string {
+	lines := strings.Split(strings.TrimSpace(string(output)), "\n")
+	if len(lines) > n {
+		lines = lines[len(lines)-n:]
+	}
+	return strings.Join(lines, "\n")
+}
@@ -0,0 +1,224 @@
+package mediaResize
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"runtime"
+	"strings"
+	"testing"
+	"time"
+)
+
+// newTestVideo 使用ffmpeg生成带音轨的测试视频,没有ffmpeg时跳过测试,设置MEDIARESIZE_REQUIRE_FFMPEG时失败
+func newTestVideo(t *testing.T, path string, width int, height int, seconds int) {
+	t.Helper()
+	requireFFmpeg(t)
+	cmd := exec.Command("ffmpeg", "-y", "-v", "error",
+		"-f", "lavfi", "-i", fmt.Sprintf("testsrc2=size=%dx%d:rate=25", width, height),
+		"-f", "lavfi", "-i", "sine=frequency=440:sample_rate=44100",
+		"-t", fmt.Sprint(seconds), "-pix_fmt", "yuv420p", "-shortest", path)
+	if output, err := cmd.CombinedOutput(); err != nil {
+		t.Fatal(err, string(output))
+	}
+}
+
+// requireFFmpeg 没有ffmpeg时跳过测试,设置了MEDIARESIZE_REQUIRE_FFMPEG时测试失败
+func requireFFmpeg(t *testing.T) {
+	t.Helper()
+	for _, bin := range []string{"ffmpeg", "ffprobe"} {
+		if _, err := exec.LookPath(bin); err != nil {
+			if os.Getenv("MEDIARESIZE_REQUIRE_FFMPEG") != "" {
+				t.Fatal(bin + " not found")
+			}
+			t.Skip(bin + " not found")
+		}
+	}
+}
+
+func TestParseProgress(t *testing.T) {
+	output := `frame=50
+fps=25.00
+stream_0_0_q=28.0
+bitrate=1523.4kbits/s
+total_size=381000
+out_time_us=2000000
+out_time_ms=2000000
+out_time=00:00:02.000000
+dup_frames=0
+drop_frames=0
+speed=2.00x
+progress=continue
+frame=100
+fps=25.00
+bitrate=N/A
+total_size=762000
+out_time_us=4000000
+speed=2x
+progress=end
+`
+	got := []VideoProgress{}
+	parseProgress(strings.NewReader(output), &VideoProgress{Path: "a.mp4", Index: 1, Count: 2, Duration: 4 * time.Second}, func(p *VideoProgress) {
+		got = append(got, *p)
+	})
+	if len(got) != 2 {
+		t.Fatalf("got %d events", len(got))
+	}
+	p := got[0]
+	if p.Path != "a.mp4" || p.Frame != 50 || p.FPS != 25 || p.Bitrate != 1523.4 || p.Size != 381000 ||
+		p.OutTime != 2*time.Second || p.Speed != 2 || p.Percent != 50 || p.Done {
+		t.Errorf("first: %+v", p)
+	}
+	if p.Overall() != 0.75 {
+		t.Errorf("overall: %v", p.Overall())
+	}
+	if eta := progressETA(&p, 10*time.Second); eta != time.Second {
+		t.Errorf("eta: %v", eta)
+	}
+	p = got[1]
+	// bitrate=N/A时保留上次的值
+	if p.Frame != 100 || p.Bitrate != 1523.4 || p.Percent != 100 || !p.Done || p.Overall() != 1 {
+		t.Errorf("last: %+v", p)
+	}
+}
+
+func TestProgressETA(t *testing.T) {
+	p := &VideoProgress{Duration: 10 * time.Second, OutTime: 2 * time.Second}
+	// 没有速度时按已用时间估算
+	if eta := progressETA(p, 4*time.Second); eta != 16*time.Second {
+		t.Errorf("eta: %v", eta)
+	}
+	p.Duration = 0
+	if eta := progressETA(p, 4*time.Second); eta != 0 {
+		t.Errorf("unknown duration eta: %v", eta)
+	}
+}
+
+func TestProgressChan(t *testing.T) {
+	send, ch, closeCh := ProgressChan(1)
+	send(&VideoProgress{Percent: 10})
+	// 缓冲已满时丢弃
+	send(&VideoProgress{Percent: 20})
+	if p := <-ch; p.Percent != 10 {
+		t.Errorf("got %+v", p)
+	}
+	// 停止读取时完成事件不阻塞,丢弃最早的进度
+	send(&VideoProgress{Percent: 30})
+	send(&VideoProgress{Percent: 100, Done: true})
+	closeCh()
+	n := 0
+	for p := range ch {
+		if !p.Done {
+			t.Errorf("got %+v", p)
+		}
+		n++
+	}
+	if n != 1 {
+		t.Errorf("done events: %d", n)
+	}
+}
+
+func TestVideoResizeProgress(t *testing.T) {
+	dir := t.TempDir()
+	src := filepath.Join(dir, "a.mp4")
+	newTestVideo(t, src, 320, 240, 2)
+
+	events := []VideoProgress{}
+	opts := &VideoOptions{OnProgress: func(p *VideoProgress) { events = append(events, *p) }}
+	res, err := VideoResizeWithOptions(src, filepath.Join(dir, "b.mp4"), []string{"mp4"}, []MediaWH{{Width: 160, Height: 160}, {Width: 240, Height: 240}}, -1, false, opts)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(res.Paths) != 2 || len(events) == 0 {
+		t.Fatalf("paths %v, events %d", res.Paths, len(events))
+	}
+	last := events[len(events)-1]
+	if !last.Done || last.Index != 1 || last.Count != 2 || last.Duration <= 0 || last.Overall() != 1 {
+		t.Errorf("last event: %+v", last)
+	}
+}
+
+func TestVideoResizeCancel(t *testing.T) {
+	dir := t.TempDir()
+	src := filepath.Join(dir, "a.mp4")
+	newTestVideo(t, src, 320, 240, 10)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	indexes := map[int]bool{}
+	opts := &VideoOptions{Context: ctx, OnProgress: func(p *VideoProgress) {
+		indexes[p.Index] = true
+		cancel()
+	}}
+	start := time.Now()
+	_, err := VideoResizeWithOptions(src, filepath.Join(dir, "b.mp4"), []string{"mp4"}, []MediaWH{{Width: 160, Height: 160}, {Width: 240, Height: 240}}, -1, false, opts)
+	if !errors.Is(err, context.Canceled) {
+		t.Fatal("expected context.Canceled, got:", err)
+	}
+	if indexes[1] {
+		t.Error("second size should not be encoded after cancel")
+	}
+	if time.Since(start) > 5*time.Second {
+		t.Error("cancel did not stop ffmpeg")
+	}
+	// 取消时不留下不完整的文件
+	if entries, _ := os.ReadDir(dir); len(entries) != 1 {
+		t.Errorf("files left behind: %v", entries)
+	}
+
+	// 已取消的ctx不运行ffprobe
+	if _, err = VideoResizeWithOptions(src, filepath.Join(dir, "c.mp4"), []string{"mp4"}, []MediaWH{{Width: 160, Height: 160}}, -1, false, opts); !errors.Is(err, context.Canceled) {
+		t.Error("expected context.Canceled, got:", err)
+	}
+}
+
+// fakeFFmpeg 在PATH中放入写入部分输出后失败的ffmpeg
+func fakeFFmpeg(t *testing.T) {
+	t.Helper()
+	if runtime.GOOS == "windows" {
+		t.Skip("shell script ffmpeg")
+	}
+	bin := t.TempDir()
+	script := "#!/bin/sh\nfor last; do :; done\necho partial > \"$last\"\necho progress=continue\nexit 1\n"
+	if err := os.WriteFile(filepath.Join(bin, "ffmpeg"), []byte(script), 0o755); err != nil {
+		t.Fatal(err)
+	}
+	t.Setenv("PATH", bin+string(os.PathListSeparator)+os.Getenv("PATH"))
+}
+
+func TestRunFFmpegRemovesPartialOutput(t *testing.T) {
+	fakeFFmpeg(t)
+	dir := t.TempDir()
+	for _, withProgress := range []bool{false, true} {
+		out := filepath.Join(dir, "out.mp4")
+		var onProgress func(*VideoProgress)
+		if withProgress {
+			onProgress = func(*VideoProgress) {}
+		}
+		if err := runFFmpeg(context.Background(), []string{"-i", "in.mp4", out}, &VideoProgress{}, onProgress); err == nil {
+			t.Fatal("expected error")
+		}
+		if _, err := os.Stat(out); !os.IsNotExist(err) {
+			t.Errorf("progress %v: partial output left behind: %v", withProgress, err)
+		}
+	}
+
+	// 失败的编码不留下文件,也不发送完成事件,再次处理时重新编码
+	for i := 0; i < 2; i++ {
+		done := 0
+		err := resizeVideo(context.Background(), "in.mp4", filepath.Join(dir, "b.mp4"), "video/mp4", nil, 160, 120, "", &VideoProgress{}, func(p *VideoProgress) {
+			if p.Done {
+				done++
+			}
+		})
+		if err == nil || done != 0 {
+			t.Errorf("run %d: err %v, done events %d", i, err, done)
+		}
+		if entries, _ := os.ReadDir(dir); len(entries) != 0 {
+			t.Errorf("run %d: files left behind: %v", i, entries)
+		}
+	}
+}
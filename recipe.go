@@ -0,0 +1,547 @@
+package mediaResize
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"regexp"
+	"strconv"
+	"strings"
+
+	"gopkg.in/yaml.v3"
+)
+
+const (
+	MetadataStrip      = "strip"  // 去除所有元数据(默认)
+	MetadataAutoOrient = "orient" // 按EXIF方向信息旋转后去除元数据
+)
+
+// Recipe 声明式的处理配置,可使用JSON或YAML编写
+type Recipe struct {
+	Inputs           []string       `json:"inputs,omitempty" yaml:"inputs,omitempty"`                     //输入文件、目录或通配符
+	Sizes            []SizePreset   `json:"sizes" yaml:"sizes"`                                           //尺寸预设
+	Formats          []RecipeFormat `json:"formats" yaml:"formats"`                                       //图片格式及编码参数
+	Quality          int            `json:"quality,omitempty" yaml:"quality,omitempty"`                   //默认图片质量(1-100), 0为编码器默认值
+	Naming           string         `json:"naming,omitempty" yaml:"naming,omitempty"`                     //命名模板, 见ImgOptions.Naming, 同时用于视频
+	Metadata         string         `json:"metadata,omitempty" yaml:"metadata,omitempty"`                 //元数据策略: MetadataStrip, MetadataAutoOrient
+	Fit              string         `json:"fit,omitempty" yaml:"fit,omitempty"`                           //默认缩放方式
+	Crop             string         `json:"crop,omitempty" yaml:"crop,omitempty"`                         //裁剪策略
+	SourceProcessors []RecipeStep   `json:"sourceProcessors,omitempty" yaml:"sourceProcessors,omitempty"` //对原图执行一次的处理器
+	Processors       []RecipeStep   `json:"processors,omitempty" yaml:"processors,omitempty"`             //每个尺寸执行的处理器
+	Video            *RecipeVideo   `json:"video,omitempty" yaml:"video,omitempty"`                       //视频参数
+}
+
+// RecipeFormat 图片格式及编码参数
+type RecipeFormat struct {
+	Format   string `json:"format" yaml:"format"`                         //图片格式
+	Quality  int    `json:"quality,omitempty" yaml:"quality,omitempty"`   //图片质量(1-100), 0为使用Recipe.Quality
+	Lossless *bool  `json:"lossless,omitempty" yaml:"lossless,omitempty"` //webp是否使用无损压缩, 默认为true
+}
+
+// RecipeStep 处理器名称及参数,参数与已注册处理器的JSON字段相同
+type RecipeStep struct {
+	Name   string                 `json:"name" yaml:"name"`                         //处理器名称
+	Params map[string]interface{} `json:"params,omitempty" yaml:"params,omitempty"` //处理器参数
+}
+
+// RecipeVideo 视频参数
+type RecipeVideo struct {
+	Formats  []string       `json:"formats" yaml:"formats"`                       //视频格式
+	CodeRate int            `json:"codeRate,omitempty" yaml:"codeRate,omitempty"` //视频码率(k), 0为默认值
+	Encoding *VideoEncoding `json:"encoding,omitempty" yaml:"encoding,omitempty"` //编码参数
+}
+
+// ResizeSpec 由Recipe生成的ImgResize/VideoResize参数
+type ResizeSpec struct {
+	Formats      []string      //图片格式
+	MaxWHs       []MediaWH     //图片宽高
+	Quality      int           //图片质量
+	Image        *ImgOptions   //图片可选参数
+	VideoFormats []string      //视频格式
+	CodeRate     int           //视频码率
+	Video        *VideoOptions //视频可选参数
+}
+
+// RecipeError 配置错误及其位置
+type RecipeError struct {
+	File   string //文件路径
+	Line   int    //行号,从1开始,0为未知
+	Column int    //列号,从1开始,0为未知
+	Path   string //字段路径,如 sizes[1].width
+	Msg    string //错误信息
+}
+
+func (e *RecipeError) Error() string {
+	pos := e.File
+	if pos == "" {
+		pos = "recipe"
+	}
+	if e.Line > 0 {
+		pos += fmt.Sprintf(":%d:%d", e.Line, e.Column)
+	}
+	if e.Path != "" {
+		return fmt.Sprintf("%s: %s: %s", pos, e.Path, e.Msg)
+	}
+	return fmt.Sprintf("%s: %s", pos, e.Msg)
+}
+
+// RecipeErrors 多个配置错误
+type RecipeErrors []*RecipeError
+
+func (errs RecipeErrors) Error() string {
+	msgs := make([]string, len(errs))
+	for i, e := range errs {
+		msgs[i] = e.Error()
+	}
+	return strings.Join(msgs, "\n")
+}
+
+var yamlLineRe = regexp.MustCompile(`line (\d+)`)
+
+// ========================
+//
+//	读取配置文件
+//	path		string		配置文件路径,.json或.yaml/.yml
+//	返回值		*Recipe		配置
+//	返回值		error		错误信息,配置有误时为RecipeErrors
+func LoadRecipeFile(path string) (*Recipe, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	r, err := LoadRecipe(data)
+	var errs RecipeErrors
+	if errors.As(err, &errs) {
+		for _, e := range errs {
+			e.File = path
+		}
+	}
+	return r, err
+}
+
+// ========================
+//
+//	解析并校验配置,以"{"开头时按JSON解析,否则按YAML解析
+//	data		[]byte		配置内容
+//	返回值		*Recipe		配置
+//	返回值		error		错误信息,配置有误时为RecipeErrors
+func LoadRecipe(data []byte) (*Recipe, error) {
+	r := &Recipe{}
+	isJSON := bytes.HasPrefix(bytes.TrimSpace(data), []byte("{"))
+	var locate func(path []interface{}) (int, int)
+
+	if isJSON {
+		dec := json.NewDecoder(bytes.NewReader(data))
+		dec.DisallowUnknownFields()
+		if err := dec.Decode(r); err != nil {
+			offset := dec.InputOffset()
+			var syntaxErr *json.SyntaxError
+			var typeErr *json.UnmarshalTypeError
+			if errors.As(err, &syntaxErr) {
+				offset = syntaxErr.Offset
+			} else if errors.As(err, &typeErr) {
+				offset = typeErr.Offset
+			}
+			line, col := offsetLineColumn(data, offset)
+			return nil, RecipeErrors{{Line: line, Column: col, Msg: err.Error()}}
+		}
+		locate = func(path []interface{}) (int, int) {
+			return offsetLineColumn(data, jsonPathOffset(data, path))
+		}
+	} else {
+		var root yaml.Node
+		if err := yaml.Unmarshal(data, &root); err != nil {
+			return nil, yamlErrors(err)
+		}
+		dec := yaml.NewDecoder(bytes.NewReader(data))
+		dec.KnownFields(true)
+		if err := dec.Decode(r); err != nil {
+			return nil, yamlErrors(err)
+		}
+		locate = func(path []interface{}) (int, int) {
+			n := yamlPathNode(&root, path)
+			if n == nil {
+				return 0, 0
+			}
+			return n.Line, n.Column
+		}
+	}
+
+	if errs := r.validate(); len(errs) > 0 {
+		for _, e := range errs {
+			e.Line, e.Column = locate(e.path)
+			e.Path = formatRecipePath(e.path)
+		}
+		result := make(RecipeErrors, len(errs))
+		for i, e := range errs {
+			result[i] = &e.RecipeError
+		}
+		return r, result
+	}
+	return r, nil
+}
+
+// ========================
+//
+//	将配置转换为JSON
+//	返回值		[]byte		JSON内容
+//	返回值		error		错误信息
+func (r *Recipe) JSON() ([]byte, error) {
+	return json.MarshalIndent(r, "", "  ")
+}
+
+type recipeIssue struct {
+	RecipeError
+	path []interface{}
+}
+
+// ========================
+//
+//	校验配置
+//	返回值		[]*recipeIssue	错误及其字段路径
+func (r *Recipe) validate() []*recipeIssue {
+	issues := []*recipeIssue{}
+	add := func(msg string, path ...interface{}) {
+		issues = append(issues, &recipeIssue{RecipeError: RecipeError{Msg: msg}, path: path})
+	}
+
+	if len(r.Sizes) == 0 {
+		add("at least one size is required", "sizes")
+	}
+	names := map[string]bool{}
+	for i, size := range r.Sizes {
+		original := size.Width < 0 || size.Height < 0
+		if original && (size.Width != -1 || size.Height != -1) {
+			add("original size must set both width and height to -1", "sizes", i)
+		} else if !original && (size.Width == 0 || size.Height == 0) {
+			add("width and height must be positive or -1", "sizes", i)
+		}
+		if size.Name != "" {
+			if names[size.Name] {
+				add(fmt.Sprintf("duplicate size name %q", size.Name), "sizes", i, "name")
+			}
+			names[size.Name] = true
+		}
+		if size.Fit != FitInside && size.Fit != FitCover && size.Fit != "inside" {
+			add(fmt.Sprintf("unknown fit %q", size.Fit), "sizes", i, "fit")
+		}
+		if _, err := resampleFilter(size.Filter); err != nil {
+			add(err.Error(), "sizes", i, "filter")
+		}
+	}
+
+	if len(r.Formats) == 0 {
+		add("at least one format is required", "formats")
+	}
+	for i, f := range r.Formats {
+		switch strings.ToLower(f.Format) {
+		case "jpg", "jpeg", "png", "webp", "gif", "bmp", "tif", "tiff":
+		default:
+			add(fmt.Sprintf("unsupported format %q", f.Format), "formats", i, "format")
+		}
+		if f.Quality < 0 || f.Quality > 100 {
+			add("quality must be between 1 and 100", "formats", i, "quality")
+		}
+	}
+	if r.Quality < 0 || r.Quality > 100 {
+		add("quality must be between 1 and 100", "quality")
+	}
+
+	if r.Naming != "" {
+		rest := strings.NewReplacer("{name}", "", "{ext}", "", "{size}", "", "{format}", "").Replace(r.Naming)
+		if strings.ContainsAny(rest, "{}") {
+			add("unknown placeholder, use {name} {ext} {size} {format}", "naming")
+		}
+		if !strings.Contains(r.Naming, "{size}") || !strings.Contains(r.Naming, "{format}") {
+			add("naming must contain {size} and {format}", "naming")
+		}
+	}
+	switch r.Metadata {
+	case "", MetadataStrip, MetadataAutoOrient:
+	default:
+		add(fmt.Sprintf("unsupported metadata policy %q", r.Metadata), "metadata")
+	}
+	if r.Fit != FitInside && r.Fit != FitCover && r.Fit != "inside" {
+		add(fmt.Sprintf("unknown fit %q", r.Fit), "fit")
+	}
+	switch r.Crop {
+	case CropCenter, CropSmart, CropFace:
+	default:
+		add(fmt.Sprintf("unknown crop %q", r.Crop), "crop")
+	}
+
+	for i, step := range r.SourceProcessors {
+		if _, err := step.processor(); err != nil {
+			add(err.Error(), "sourceProcessors", i)
+		}
+	}
+	for i, step := range r.Processors {
+		if _, err := step.processor(); err != nil {
+			add(err.Error(), "processors", i)
+		}
+	}
+
+	if r.Video != nil {
+		if len(r.Video.Formats) == 0 {
+			add("at least one video format is required", "video", "formats")
+		}
+		if r.Video.CodeRate < 0 {
+			add("codeRate must be positive", "video", "codeRate")
+		}
+		if r.Video.Encoding != nil {
+			for i, format := range r.Video.Formats {
+				if err := r.Video.Encoding.Validate(format); err != nil {
+					add(err.Error(), "video", "formats", i)
+				}
+			}
+		}
+	}
+	return issues
+}
+
+// ========================
+//
+//	创建处理器
+//	返回值		Processor	处理器
+//	返回值		error		错误信息
+func (step RecipeStep) processor() (Processor, error) {
+	var params json.RawMessage
+	if len(step.Params) > 0 {
+		var err error
+		params, err = json.Marshal(step.Params)
+		if err != nil {
+			return nil, err
+		}
+	}
+	return NewProcessor(step.Name, params)
+}
+
+// ========================
+//
+//	将配置转换为ImgResize/VideoResize使用的参数
+//	返回值		*ResizeSpec	处理参数
+//	返回值		error		错误信息
+func (r *Recipe) Spec() (*ResizeSpec, error) {
+	spec := &ResizeSpec{
+		Quality:  -1,
+		CodeRate: -1,
+		Image: &ImgOptions{
+			Fit:        r.Fit,
+			Crop:       r.Crop,
+			AutoOrient: r.Metadata == MetadataAutoOrient,
+			Presets:    r.Sizes,
+			Encoders:   map[string]EncoderOptions{},
+			Naming:     r.Naming,
+		},
+		Video: &VideoOptions{Naming: r.Naming},
+	}
+	if r.Quality > 0 {
+		spec.Quality = r.Quality
+	}
+	for _, size := range r.Sizes {
+		spec.MaxWHs = append(spec.MaxWHs, MediaWH{Width: size.Width, Height: size.Height})
+	}
+	for _, f := range r.Formats {
+		format := strings.ToLower(f.Format)
+		spec.Formats = append(spec.Formats, format)
+		spec.Image.Encoders[format] = EncoderOptions{Quality: f.Quality, Lossless: f.Lossless}
+	}
+	for _, step := range r.SourceProcessors {
+		p, err := step.processor()
+		if err != nil {
+			return nil, err
+		}
+		spec.Image.SourceProcessors = append(spec.Image.SourceProcessors, p)
+	}
+	for _, step := range r.Processors {
+		p, err := step.processor()
+		if err != nil {
+			return nil, err
+		}
+		spec.Image.Processors = append(spec.Image.Processors, p)
+	}
+	if r.Video != nil {
+		spec.VideoFormats = r.Video.Formats
+		spec.Video.Encoding = r.Video.Encoding
+		if r.Video.CodeRate > 0 {
+			spec.CodeRate = r.Video.CodeRate
+		}
+	}
+	return spec, nil
+}
+
+// ========================
+//
+//	将yaml错误转换为带行号的RecipeErrors
+//	err			error		yaml错误
+//	返回值		RecipeErrors	配置错误
+func yamlErrors(err error) RecipeErrors {
+	msgs := []string{err.Error()}
+	var typeErr *yaml.TypeError
+	if errors.As(err, &typeErr) {
+		msgs = typeErr.Errors
+	}
+	errs := RecipeErrors{}
+	for _, msg := range msgs {
+		e := &RecipeError{Msg: msg}
+		if m := yamlLineRe.FindStringSubmatch(msg); m != nil {
+			e.Line, _ = strconv.Atoi(m[1])
+		}
+		errs = append(errs, e)
+	}
+	return errs
+}
+
+// ========================
+//
+//	查找字段路径对应的yaml节点,找不到时返回最近的上级节点
+//	root		*yaml.Node	根节点
+//	path		[]interface{}	字段路径,元素为string或int
+//	返回值		*yaml.Node	节点
+func yamlPathNode(root *yaml.Node, path []interface{}) *yaml.Node {
+	n := root
+	if n.Kind == yaml.DocumentNode && len(n.Content) > 0 {
+		n = n.Content[0]
+	}
+	for _, p := range path {
+		var next *yaml.Node
+		switch key := p.(type) {
+		case string:
+			if n.Kind == yaml.MappingNode {
+				for i := 0; i+1 < len(n.Content); i += 2 {
+					if n.Content[i].Value == key {
+						next = n.Content[i+1]
+						break
+					}
+				}
+			}
+		case int:
+			if n.Kind == yaml.SequenceNode && key < len(n.Content) {
+				next = n.Content[key]
+			}
+		}
+		if next == nil {
+			return n
+		}
+		n = next
+	}
+	return n
+}
+
+// ========================
+//
+//	查找字段路径对应的JSON值的偏移,找不到时返回最近的上级值的偏移
+//	data		[]byte		JSON内容
+//	path		[]interface{}	字段路径,元素为string或int
+//	返回值		int64		偏移
+func jsonPathOffset(data []byte, path []interface{}) int64 {
+	type frame struct {
+		obj     bool
+		wantKey bool
+		key     string
+		index   int
+	}
+	var (
+		dec   = json.NewDecoder(bytes.NewReader(data))
+		stack = []*frame{}
+		best  = int64(0)
+	)
+	afterValue := func() {
+		if n := len(stack); n > 0 {
+			if stack[n-1].obj {
+				stack[n-1].wantKey = true
+			} else {
+				stack[n-1].index++
+			}
+		}
+	}
+	for {
+		offset := dec.InputOffset()
+		tok, err := dec.Token()
+		if err != nil {
+			return best
+		}
+		d, isDelim := tok.(json.Delim)
+		if n := len(stack); n > 0 && stack[n-1].obj && stack[n-1].wantKey {
+			if isDelim && d == '}' {
+				stack = stack[:n-1]
+				afterValue()
+				continue
+			}
+			stack[n-1].key, _ = tok.(string)
+			stack[n-1].wantKey = false
+			continue
+		}
+		if isDelim && d == ']' {
+			stack = stack[:len(stack)-1]
+			afterValue()
+			continue
+		}
+
+		// 值的开始位置,判断路径是否匹配
+		matched := len(stack) <= len(path)
+		for i := 0; matched && i < len(stack); i++ {
+			if stack[i].obj {
+				matched = path[i] == interface{}(stack[i].key)
+			} else {
+				matched = path[i] == interface{}(stack[i].index)
+			}
+		}
+		if matched {
+			for int(offset) < len(data) && strings.ContainsRune(" \t\r\n,:", rune(data[offset])) {
+				offset++
+			}
+			best = offset
+			if len(stack) == len(path) {
+				return best
+			}
+		}
+		if isDelim && (d == '{' || d == '[') {
+			stack = append(stack, &frame{obj: d == '{', wantKey: d == '{'})
+			continue
+		}
+		afterValue()
+	}
+}
+
+// ========================
+//
+//	将偏移转换为行号和列号
+//	data		[]byte		内容
+//	offset		int64		偏移
+//	返回值		int		行号
+//	返回值		int		列号
+func offsetLineColumn(data []byte, offset int64) (int, int) {
+	if offset > int64(len(data)) {
+		offset = int64(len(data))
+	}
+	if offset < 0 {
+		return 0, 0
+	}
+	line := 1 + bytes.Count(data[:offset], []byte("\n"))
+	col := int(offset) - bytes.LastIndexByte(data[:offset], '\n')
+	return line, col
+}
+
+// ========================
+//
+//	将字段路径格式化为字符串
+//	path		[]interface{}	字段路径
+//	返回值		string		如 sizes[1].width
+func formatRecipePath(path []interface{}) string {
+	var sb strings.Builder
+	for _, p := range path {
+		switch v := p.(type) {
+		case int:
+			fmt.Fprintf(&sb, "[%d]", v)
+		default:
+			if sb.Len() > 0 {
+				sb.WriteString(".")
+			}
+			fmt.Fprint(&sb, v)
+		}
+	}
+	return sb.String()
+}
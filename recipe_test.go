@@ -0,0 +1,129 @@
+package mediaResize
+
+import (
+	"errors"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+const testRecipeYAML = `sizes:
+  - name: thumb
+    width: 100
+    height: 100
+    fit: cover
+  - width: 300
+    height: 300
+    filter: linear
+formats:
+  - format: webp
+    quality: 80
+    lossless: false
+  - format: png
+naming: "{name}-{size}.{format}"
+metadata: orient
+processors:
+  - name: adjust
+    params:
+      contrast: 10
+`
+
+func TestLoadRecipe(t *testing.T) {
+	r, err := LoadRecipe([]byte(testRecipeYAML))
+	if err != nil {
+		t.Fatal("LoadRecipe failed:", err)
+	}
+	data, err := r.JSON()
+	if err != nil {
+		t.Fatal("Recipe.JSON failed:", err)
+	}
+	r2, err := LoadRecipe(data)
+	if err != nil {
+		t.Fatal("LoadRecipe json failed:", err)
+	}
+	data2, _ := r2.JSON()
+	if string(data) != string(data2) {
+		t.Errorf("round trip mismatch:\n%s\n%s", data, data2)
+	}
+
+	spec, err := r.Spec()
+	if err != nil {
+		t.Fatal("Recipe.Spec failed:", err)
+	}
+	if spec.Video.Naming != r.Naming {
+		t.Errorf("video naming %q, want %q", spec.Video.Naming, r.Naming)
+	}
+	dir := t.TempDir()
+	src := filepath.Join(dir, "recipe.png")
+	newTestImage(t, src, 400, 200)
+	res, err := ImgResizeWithOptions(src, filepath.Join(dir, "out.png"), spec.Formats, spec.MaxWHs, spec.Quality, false, spec.Image)
+	if err != nil {
+		t.Fatal("ImgResizeWithOptions failed:", err)
+	}
+	want := []string{"out-thumb.webp", "out-thumb.png", "out-M.webp", "out-M.png"}
+	if len(res.Paths) != len(want) {
+		t.Fatal("unexpected paths:", res.Paths)
+	}
+	for i, p := range res.Paths {
+		if filepath.Base(p) != want[i] {
+			t.Error("unexpected path:", p, "want:", want[i])
+		}
+	}
+}
+
+func TestLoadRecipeErrors(t *testing.T) {
+	cases := []struct {
+		data string
+		line int
+		path string
+	}{
+		{"sizes:\n  - width: 100\n    height: 100\nformats:\n  - format: jpg\n  - format: heic\n", 6, "formats[1].format"},
+		{"sizes:\n  - width: 100\n    height: 0\nformats:\n  - format: jpg\n", 2, "sizes[0]"},
+		{"sizes:\n  - width: abc\n", 2, ""},
+		{"{\n\t\"sizes\": [{\"width\": 100, \"height\": 100}],\n\t\"formats\": [\n\t\t{\"format\": \"jpg\", \"quality\": 101}\n\t]\n}", 4, "formats[0].quality"},
+		{"{\n\t\"sizes\": [],\n\t\"formats\": [{\"format\": \"jpg\"}],\n\t\"processors\": [{\"name\": \"nope\"}]\n}", 4, "processors[0]"},
+		{"{\n\t\"sizes\": [\n\t\t{\"width\": \"x\"}\n\t]\n}", 3, ""},
+		{"sizes:\n  - width: 100\n    height: 100\nformats:\n  - format: jpg\nvideo:\n  formats:\n    - mp4\n    - webm\n  encoding:\n    codec: h264\n", 9, "video.formats[1]"},
+	}
+	for i, c := range cases {
+		_, err := LoadRecipe([]byte(c.data))
+		var errs RecipeErrors
+		if !errors.As(err, &errs) {
+			t.Errorf("case %d: expected RecipeErrors, got %v", i, err)
+			continue
+		}
+		found := false
+		for _, e := range errs {
+			if e.Line == c.line && e.Path == c.path {
+				found = true
+			}
+		}
+		if !found {
+			t.Errorf("case %d: expected error at line %d %q, got:\n%v", i, c.line, c.path, err)
+		}
+	}
+}
+
+func TestRecipeVideoNaming(t *testing.T) {
+	dir := t.TempDir()
+	src := filepath.Join(dir, "a.mp4")
+	newTestVideo(t, src, 320, 240, 1)
+	r, err := LoadRecipe([]byte("sizes:\n  - width: 160\n    height: 160\nformats:\n  - format: jpg\nnaming: \"{name}-{size}.{format}\"\nvideo:\n  formats: [mp4]\n"))
+	if err != nil {
+		t.Fatal("LoadRecipe failed:", err)
+	}
+	spec, err := r.Spec()
+	if err != nil {
+		t.Fatal("Recipe.Spec failed:", err)
+	}
+	// 输出目录中的"."不影响视频文件名
+	out := filepath.Join(dir, "v1.2", "out.mp4")
+	os.MkdirAll(filepath.Dir(out), os.ModePerm)
+	res, err := VideoResizeWithOptions(src, out, spec.VideoFormats, spec.MaxWHs, spec.CodeRate, false, spec.Video)
+	if err != nil {
+		t.Fatal("VideoResizeWithOptions failed:", err)
+	}
+	if want := filepath.Join(dir, "v1.2", "out-S.mp4"); len(res.Paths) != 1 || res.Paths[0] != want {
+		t.Errorf("paths %v, want %s", res.Paths, want)
+	}
+}
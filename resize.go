@@ -1,11 +1,13 @@
 package mediaResize
 
 import (
+	"errors"
 	"fmt"
 	"image"
 	"os"
 
 	"github.com/disintegration/imaging"
+	"golang.org/x/image/font/opentype"
 )
 
 // ========================
@@ -37,6 +39,36 @@ func ImgResizes(paths []string, newPaths []string, formats []string, maxWHs []Me
 	return newImagePath, newsizes, newformats, nil
 }
 
+// ========================
+//
+//	使用可选参数批量处理图片
+//	paths		[]string	原图片路径
+//	newPaths	[]string	新图片路径
+//	formats		[]string	图片格式
+//	maxWHs		[]MediaWH	图片宽高
+//	quality		int		图片质量
+//	isPrint		bool		是否打印错误及提示信息
+//	opts		[]*ImgOptions	每张图片的可选参数,长度为1时所有图片共用
+//	返回值		[]*ImgResult	处理结果
+//	返回值		error		错误信息
+func ImgResizesWithOptions(paths []string, newPaths []string, formats []string, maxWHs []MediaWH, quality int, isPrint bool, opts []*ImgOptions) ([]*ImgResult, error) {
+	results := []*ImgResult{}
+	for i := 0; i < len(paths); i++ {
+		var opt *ImgOptions
+		if len(opts) == 1 {
+			opt = opts[0]
+		} else if i < len(opts) {
+			opt = opts[i]
+		}
+		res, err := ImgResizeWithOptions(paths[i], newPaths[i], formats, maxWHs, quality, isPrint, opt)
+		if err != nil {
+			return results, err
+		}
+		results = append(results, res)
+	}
+	return results, nil
+}
+
 // ========================
 //
 //	处理图片
@@ -49,50 +81,188 @@ func ImgResizes(paths []string, newPaths []string, formats []string, maxWHs []Me
 //	返回值		[]string	新图片路径
 //	返回值		error		错误信息
 func ImgResize(path string, newPath string, formats []string, maxWHs []MediaWH, quality int, isPrint bool) ([]string, []string, []string, error) {
-	newImagePath := []string{}
-	sizes := []string{}
-	newformats := []string{}
+	res, err := ImgResizeWithOptions(path, newPath, formats, maxWHs, quality, isPrint, nil)
+	return res.Paths, res.Sizes, res.Formats, err
+}
+
+// ========================
+//
+//	使用可选参数处理图片
+//	paths		string		原图片路径
+//	newPaths	string		新图片路径
+//	formats		[]string	图片格式
+//	maxWHs		[]MediaWH	图片宽高
+//	quality		int		图片质量
+//	isPrint		bool		是否打印错误及提示信息
+//	opts		*ImgOptions	可选参数,为nil时与ImgResize相同
+//	返回值		*ImgResult	处理结果
+//	返回值		error		错误信息
+func ImgResizeWithOptions(path string, newPath string, formats []string, maxWHs []MediaWH, quality int, isPrint bool, opts *ImgOptions) (*ImgResult, error) {
+	res := &ImgResult{Paths: []string{}, Sizes: []string{}, Formats: []string{}, Files: []ResizeResult{}}
+	if opts == nil {
+		opts = &ImgOptions{}
+	}
+	needFocus := opts.Fit == FitCover
+	if len(opts.Presets) > 0 {
+		maxWHs = make([]MediaWH, len(opts.Presets))
+		for i, preset := range opts.Presets {
+			maxWHs[i] = MediaWH{Width: preset.Width, Height: preset.Height}
+			if preset.Fit == FitCover {
+				needFocus = true
+			}
+		}
+	}
+
+	// 命中缓存时直接写入缓存的文件
+	cacheKey := ""
+	if opts.Cache != nil {
+		key, err := imgCacheKey(path, formats, maxWHs, quality, opts)
+		if err != nil {
+			if isPrint {
+				fmt.Println("imgCacheKey failed:", err)
+			}
+		} else {
+			cacheKey = key
+			entry, paths, err := loadCacheEntry(opts.Cache, key, func(size string, format string) string {
+				return variantPath(newPath, size, format, opts.Naming)
+			})
+			if err == nil {
+				if isPrint {
+					fmt.Println("cache hit:", path)
+				}
+				res.Paths, res.Sizes, res.Formats, res.Files, res.Faces, res.Trim = paths, entry.Sizes, entry.Formats, entry.Files, entry.Faces, entry.Trim
+				return res, nil
+			}
+			if isPrint && !errors.Is(err, ErrCacheMiss) {
+				fmt.Println("loadCacheEntry failed:", err)
+			}
+		}
+	}
 
 	file, err := os.Open(path)
 	if err != nil {
 		// fmt.Println("os.Open failed:", err)
 		file.Close()
-		return newImagePath, sizes, newformats, err
+		return res, err
 	}
 	// 读取图像文件的配置信息
 	_, rformat, err := image.DecodeConfig(file)
 	if err != nil {
 		// fmt.Println("image.DecodeConfig failed:", err)
 		file.Close()
-		return newImagePath, sizes, newformats, err
+		return res, err
 	}
 	file.Close()
 	if rformat == "jpeg" {
 		rformat = "jpg"
 	}
 
-	tempImage, err := imaging.Open(path)
+	tempImage, err := imaging.Open(path, imaging.AutoOrientation(opts.AutoOrient || opts.Region != nil))
 	if err != nil {
 		if isPrint {
 			fmt.Println("imaging.Open failed:", err)
 		}
-		return newImagePath, sizes, newformats, err
+		return res, err
+	}
+
+	// 原图依次执行SourceProcessors、按指定区域裁剪、去除纯色边框
+	region := &regionStep{region: opts.Region}
+	trim := &trimStep{opts: opts.Trim}
+	source := append([]Processor{}, opts.SourceProcessors...)
+	if opts.Region != nil {
+		source = append(source, region)
+	}
+	if opts.Trim != nil {
+		source = append(source, trim)
+	}
+	tempImage, err = applyProcessors(tempImage, source, "")
+	if err != nil {
+		if isPrint {
+			fmt.Println("SourceProcessors failed:", err)
+		}
+		return res, err
 	}
 
 	if isPrint {
 		fmt.Println("open image: ", path, " format is:", rformat)
 	}
 
+	// 焦点换算为裁剪及去除边框后的坐标
+	regionFocus := region.focus
+	if opts.Trim != nil {
+		res.Trim = &trim.rect
+		if regionFocus != nil {
+			p := regionFocus.Sub(trim.rect.Min)
+			regionFocus = &p
+		}
+		if isPrint {
+			fmt.Println("trim:", trim.rect)
+		}
+	}
+
+	// 裁剪填充时先确定裁剪中心
+	var focus image.Point
+	if regionFocus != nil {
+		focus = *regionFocus
+	} else if needFocus {
+		focus, err = cropFocus(tempImage, opts, res)
+		if err != nil {
+			if isPrint {
+				fmt.Println("cropFocus failed:", err)
+			}
+			return res, err
+		}
+		if isPrint && len(res.Faces) > 0 {
+			fmt.Println("faces:", res.Faces)
+		}
+	}
+
+	var mark image.Image
+	if opts.Watermark != nil {
+		mark, err = loadWatermark(opts.Watermark)
+		if err != nil {
+			if isPrint {
+				fmt.Println("loadWatermark failed:", err)
+			}
+			return res, err
+		}
+	}
+
+	fonts := make([]*opentype.Font, len(opts.Texts))
+	for i, text := range opts.Texts {
+		fonts[i], err = loadFont(text)
+		if err != nil {
+			if isPrint {
+				fmt.Println("loadFont failed:", err)
+			}
+			return res, err
+		}
+	}
+
 	exists := map[string]bool{}
 	sizeNamei := 0
 	for i := 0; i < len(maxWHs); i++ {
 		var (
 			newImage image.Image = tempImage
-			isResize bool        = false
 			format   string      = "jpg"
 			imgSize  string      = ""
 			imagewh  *MediaWH    = nil
+			preset   SizePreset
 		)
+		if i < len(opts.Presets) {
+			preset = opts.Presets[i]
+		}
+		fit := opts.Fit
+		if preset.Fit != "" {
+			fit = preset.Fit
+		}
+		filter, err := resampleFilter(preset.Filter)
+		if err != nil {
+			if isPrint {
+				fmt.Println("resampleFilter failed:", err)
+			}
+			return res, err
+		}
 
 		switch sizeNamei {
 		case 0:
@@ -107,6 +277,9 @@ func ImgResize(path string, newPath string, formats []string, maxWHs []MediaWH,
 			}
 			imgSize += "L"
 		}
+		if preset.Name != "" {
+			imgSize = preset.Name
+		}
 		if isPrint && i == 0 {
 			// 解析图片宽高后，进行图片缩放
 			imagewh, err = DecodeImageWidthHeight(newImage, format)
@@ -114,54 +287,51 @@ func ImgResize(path string, newPath string, formats []string, maxWHs []MediaWH,
 				if isPrint {
 					fmt.Println("DecodeImageWidthHeight failed:", err)
 				}
-				return newImagePath, sizes, newformats, err
+				return res, err
 			}
 			fmt.Println("Image size is:", imagewh.Width, "x", imagewh.Height)
 		}
+		// 每个尺寸按顺序执行: 缩放或裁剪填充、Processors、水印、文字
+		var size Processor
 		if maxWHs[i].Width < 0 || maxWHs[i].Height < 0 {
 			// 不进行图片缩放
-			imgSize = "R"
-			isResize = true
+			if preset.Name == "" {
+				imgSize = "R"
+			}
 			sizeNamei--
-			sizes = append(sizes, imgSize)
+		} else if fit == FitCover && maxWHs[i].Width > 0 && maxWHs[i].Height > 0 {
+			// 按预设宽高裁剪填充
+			size = &coverStep{width: maxWHs[i].Width, height: maxWHs[i].Height, focus: focus, filter: filter}
 		} else {
-			// 解析图片宽高后，进行图片缩放
-			imagewh, err = DecodeImageWidthHeight(newImage, format)
-			if err != nil {
-				if isPrint {
-					fmt.Println("DecodeImageWidthHeight failed:", err)
-				}
-				return newImagePath, sizes, newformats, err
-			}
-
-			if imagewh.Width >= imagewh.Height {
-				if imagewh.Width > maxWHs[i].Width {
-					isResize = true
-					newImage = imaging.Resize(newImage, maxWHs[i].Width, 0, imaging.Lanczos)
-				}
-			} else {
-				if imagewh.Height > maxWHs[i].Height {
-					isResize = true
-					newImage = imaging.Resize(newImage, 0, maxWHs[i].Height, imaging.Lanczos)
-				}
-			}
-
-			if isPrint && isResize {
-				sizes = append(sizes, imgSize)
-				imagewh, err = DecodeImageWidthHeight(newImage, format)
-				if err != nil {
-					if isPrint {
-						fmt.Println("DecodeImageWidthHeight failed:", err)
-					}
-					return newImagePath, sizes, newformats, err
-				}
-				fmt.Println("Image resize is:", imagewh.Width, "x", imagewh.Height)
-			}
+			size = &fitStep{width: maxWHs[i].Width, height: maxWHs[i].Height, filter: filter}
 		}
 		sizeNamei++
-		if !isResize {
+		pipeline := []Processor{}
+		if size != nil {
+			pipeline = append(pipeline, size)
+		}
+		pipeline = append(pipeline, opts.Processors...)
+		if opts.Watermark != nil {
+			pipeline = append(pipeline, &watermarkStep{mark: mark, opts: opts.Watermark})
+		}
+		for ti, text := range opts.Texts {
+			pipeline = append(pipeline, &textStep{font: fonts[ti], opts: text})
+		}
+		newImage, err = applyProcessors(newImage, pipeline, imgSize)
+		if errors.Is(err, errNotSmaller) {
+			// 原图小于该尺寸时不再生成更大的尺寸
 			break
 		}
+		if err != nil {
+			if isPrint {
+				fmt.Println("Processors failed:", err)
+			}
+			return res, err
+		}
+		res.Sizes = append(res.Sizes, imgSize)
+		if isPrint && size != nil {
+			fmt.Println("Image resize is:", newImage.Bounds().Dx(), "x", newImage.Bounds().Dy())
+		}
 		isRformat := false
 
 		// 保存图片
@@ -169,40 +339,52 @@ func ImgResize(path string, newPath string, formats []string, maxWHs []MediaWH,
 			if rformat == v || ((rformat == "tiff" || rformat == "tif") && (v == "tiff" || v == "tif")) {
 				isRformat = true
 			}
-			path, err = saveImage(newImage, newPath, imgSize, v, quality)
+			path = variantPath(newPath, imgSize, v, opts.Naming)
+			q, lossless := opts.encoder(v, quality)
+			err = saveImage(newImage, path, v, q, lossless)
 			if err != nil {
 				if isPrint {
 					fmt.Println("saveImage failed:", err)
 				}
-				return newImagePath, sizes, newformats, err
+				return res, err
 			}
 			if isPrint {
 				fmt.Println("saveImage:", path)
 			}
-			newImagePath = append(newImagePath, path)
+			res.Paths = append(res.Paths, path)
+			res.Files = append(res.Files, ResizeResult{Path: path, Size: imgSize, Format: v})
 
 			if _, ok := exists[v]; !ok {
-				newformats = append(newformats, v)
+				res.Formats = append(res.Formats, v)
 				exists[v] = true
 			}
-			if i+1 == len(formats) && !isRformat {
-				path, err = saveImage(newImage, newPath, imgSize, rformat, quality)
+			if i+1 == len(formats) && !isRformat && !opts.SkipSourceFormat {
+				path = variantPath(newPath, imgSize, rformat, opts.Naming)
+				q, lossless := opts.encoder(rformat, quality)
+				err = saveImage(newImage, path, rformat, q, lossless)
 				if err != nil {
 					if isPrint {
 						fmt.Println("saveImage failed:", err)
 					}
-					return newImagePath, sizes, newformats, err
+					return res, err
 				}
 				if isPrint {
 					fmt.Println("saveImage 2:", path)
 				}
-				newImagePath = append(newImagePath, path)
+				res.Paths = append(res.Paths, path)
+				res.Files = append(res.Files, ResizeResult{Path: path, Size: imgSize, Format: rformat})
 				if _, ok := exists[rformat]; !ok {
-					newformats = append(newformats, rformat)
+					res.Formats = append(res.Formats, rformat)
 					exists[rformat] = true
 				}
 			}
 		}
 	}
-	return newImagePath, sizes, newformats, nil
+	if cacheKey != "" {
+		entry := &cacheEntry{Sizes: res.Sizes, Formats: res.Formats, Files: res.Files, Faces: res.Faces, Trim: res.Trim}
+		if err = storeCacheEntry(opts.Cache, cacheKey, entry); err != nil && isPrint {
+			fmt.Println("storeCacheEntry failed:", err)
+		}
+	}
+	return res, nil
 }
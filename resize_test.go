@@ -2,8 +2,13 @@ package mediaResize
 
 import (
 	"fmt"
+	"image"
+	"image/color"
 	"os"
+	"path/filepath"
 	"testing"
+
+	"github.com/disintegration/imaging"
 )
 
 func TestImgResize(t *testing.T) {
@@ -72,3 +77,215 @@ func TestVideoResize(t *testing.T) {
 	fmt.Println(newsizes)
 	fmt.Println(newformats)
 }
+
+// 生成带渐变的测试图片
+func newTestImage(t *testing.T, path string, w int, h int) {
+	img := image.NewNRGBA(image.Rect(0, 0, w, h))
+	for y := 0; y < h; y++ {
+		for x := 0; x < w; x++ {
+			img.Set(x, y, color.NRGBA{R: uint8(x * 255 / w), G: uint8(y * 255 / h), B: 128, A: 255})
+		}
+	}
+	if err := imaging.Save(img, path); err != nil {
+		t.Fatal("imaging.Save:", err)
+	}
+}
+
+func TestImgResizeCover(t *testing.T) {
+	dir := t.TempDir()
+	src := filepath.Join(dir, "cover.png")
+	newTestImage(t, src, 400, 200)
+
+	res, err := ImgResizeWithOptions(src, filepath.Join(dir, "out.png"), []string{"png"}, []MediaWH{{Width: 100, Height: 100}}, -1, false, &ImgOptions{
+		Fit:  FitCover,
+		Crop: CropFace,
+		Face: &FaceOptions{Fallback: CropSmart},
+	})
+	if err != nil {
+		t.Fatal("ImgResizeWithOptions failed:", err)
+	}
+	if len(res.Faces) != 0 {
+		t.Error("unexpected faces:", res.Faces)
+	}
+	if len(res.Paths) != 1 {
+		t.Fatal("unexpected paths:", res.Paths)
+	}
+	img, err := imaging.Open(res.Paths[0])
+	if err != nil {
+		t.Fatal("imaging.Open failed:", err)
+	}
+	if img.Bounds().Dx() != 100 || img.Bounds().Dy() != 100 {
+		t.Error("unexpected size:", img.Bounds())
+	}
+}
+
+// testdata/face.jpg 来自 https://github.com/esimov/pigo 的testdata (MIT)
+func TestImgResizeCoverFace(t *testing.T) {
+	face, err := imaging.Open(filepath.Join("testdata", "face.jpg"))
+	if err != nil {
+		t.Fatal("imaging.Open failed:", err)
+	}
+	// 人脸放在宽图的最右侧,居中裁剪时不包含人脸
+	canvas := imaging.New(1200, 400, color.NRGBA{R: 240, G: 240, B: 240, A: 255})
+	canvas = imaging.Paste(canvas, face, image.Pt(1200-face.Bounds().Dx(), 0))
+	dir := t.TempDir()
+	src := filepath.Join(dir, "face.png")
+	if err = imaging.Save(canvas, src); err != nil {
+		t.Fatal("imaging.Save failed:", err)
+	}
+
+	res, err := ImgResizeWithOptions(src, filepath.Join(dir, "out.png"), []string{"png"}, []MediaWH{{Width: 100, Height: 100}}, -1, false, &ImgOptions{
+		Fit:  FitCover,
+		Crop: CropFace,
+	})
+	if err != nil {
+		t.Fatal("ImgResizeWithOptions failed:", err)
+	}
+	if len(res.Faces) == 0 {
+		t.Fatal("no face detected")
+	}
+	// 400x400的裁剪区域贴着右边缘,必须包含检测到的人脸
+	crop := image.Rect(800, 0, 1200, 400)
+	for _, f := range res.Faces {
+		if !f.In(crop) {
+			t.Errorf("face %v is outside crop %v", f, crop)
+		}
+	}
+	out, err := imaging.Open(res.Paths[0])
+	if err != nil {
+		t.Fatal("imaging.Open failed:", err)
+	}
+	want := imaging.Resize(imaging.Crop(canvas, crop), 100, 100, imaging.Lanczos)
+	if d := meanDiff(out, want); d > 2 {
+		t.Errorf("output differs from face crop by %.1f per channel", d)
+	}
+}
+
+// 两张相同尺寸图片每个通道的平均差值
+func meanDiff(a image.Image, b image.Image) float64 {
+	na, nb := imaging.Clone(a), imaging.Clone(b)
+	var sum float64
+	for i := range na.Pix {
+		d := float64(na.Pix[i]) - float64(nb.Pix[i])
+		if d < 0 {
+			d = -d
+		}
+		sum += d
+	}
+	return sum / float64(len(na.Pix))
+}
+
+func TestImgResizeCoverStopsAtSource(t *testing.T) {
+	dir := t.TempDir()
+	src := filepath.Join(dir, "cover.png")
+	newTestImage(t, src, 400, 200)
+
+	maxWHs := []MediaWH{{Width: 100, Height: 100}, {Width: 150, Height: 150}, {Width: 300, Height: 300}, {Width: 500, Height: 500}}
+	res, err := ImgResizeWithOptions(src, filepath.Join(dir, "out.png"), []string{"png"}, maxWHs, -1, false, &ImgOptions{Fit: FitCover})
+	if err != nil {
+		t.Fatal("ImgResizeWithOptions failed:", err)
+	}
+	// 300x300只能裁剪出200x200,不再缩小,与按比例缩放相同不生成该尺寸及更大的尺寸
+	if len(res.Paths) != 2 || len(res.Sizes) != 2 || res.Sizes[0] != "S" || res.Sizes[1] != "M" {
+		t.Fatalf("paths %v, sizes %v", res.Paths, res.Sizes)
+	}
+	for i, want := range []int{100, 150} {
+		img, err := imaging.Open(res.Paths[i])
+		if err != nil {
+			t.Fatal("imaging.Open failed:", err)
+		}
+		if img.Bounds().Dx() != want || img.Bounds().Dy() != want {
+			t.Errorf("%s: size %v, want %dx%d", res.Sizes[i], img.Bounds(), want, want)
+		}
+	}
+}
+
+func TestImgResizeRegion(t *testing.T) {
+	dir := t.TempDir()
+	src := filepath.Join(dir, "region.png")
+	newTestImage(t, src, 400, 300)
+
+	results, err := ImgResizesWithOptions([]string{src}, []string{filepath.Join(dir, "out.png")}, []string{"png"}, []MediaWH{{Width: -1, Height: -1}}, -1, false, []*ImgOptions{{
+		Region: &CropRegion{
+			Relative: &CropRect{X: 0.25, Y: 0, Width: 0.5, Height: 0.5},
+			Focus:    &FocalPoint{X: 0.5, Y: 0.25},
+		},
+	}})
+	if err != nil {
+		t.Fatal("ImgResizesWithOptions failed:", err)
+	}
+	img, err := imaging.Open(results[0].Paths[0])
+	if err != nil {
+		t.Fatal("imaging.Open failed:", err)
+	}
+	if img.Bounds().Dx() != 200 || img.Bounds().Dy() != 150 {
+		t.Error("unexpected size:", img.Bounds())
+	}
+
+	// 焦点(360,75)在裁剪出的400x150中靠右,裁剪填充为正方形时取最右侧的150x150
+	res, err := ImgResizeWithOptions(src, filepath.Join(dir, "focus.png"), []string{"png"}, []MediaWH{{Width: 50, Height: 50}}, -1, false, &ImgOptions{
+		Fit: FitCover,
+		Region: &CropRegion{
+			Relative: &CropRect{X: 0, Y: 0, Width: 1, Height: 0.5},
+			Focus:    &FocalPoint{X: 0.9, Y: 0.25},
+		},
+	})
+	if err != nil {
+		t.Fatal("ImgResizeWithOptions failed:", err)
+	}
+	out, err := imaging.Open(res.Paths[0])
+	if err != nil {
+		t.Fatal("imaging.Open failed:", err)
+	}
+	source, err := imaging.Open(src)
+	if err != nil {
+		t.Fatal("imaging.Open failed:", err)
+	}
+	want := imaging.Resize(imaging.Crop(source, image.Rect(250, 0, 400, 150)), 50, 50, imaging.Lanczos)
+	if d := meanDiff(out, want); d > 2 {
+		t.Errorf("focus crop differs by %.1f per channel", d)
+	}
+	center := imaging.Resize(imaging.Crop(source, image.Rect(125, 0, 275, 150)), 50, 50, imaging.Lanczos)
+	if d := meanDiff(out, center); d < 10 {
+		t.Errorf("focus had no effect, output matches centre crop (%.1f)", d)
+	}
+
+	_, err = ImgResizeWithOptions(src, filepath.Join(dir, "bad.png"), []string{"png"}, []MediaWH{{Width: -1, Height: -1}}, -1, false, &ImgOptions{
+		Region: &CropRegion{Rect: &CropRect{X: 500, Y: 500, Width: 10, Height: 10}},
+	})
+	if err == nil {
+		t.Error("expected error for empty crop region")
+	}
+
+	for _, r := range []CropRect{
+		{X: -0.1, Y: 0, Width: 0.5, Height: 0.5},
+		{X: 0.6, Y: 0, Width: 0.5, Height: 0.5},
+		{X: 0, Y: 0, Width: 0, Height: 0.5},
+		{X: 0, Y: 0.2, Width: 0.5, Height: 1},
+	} {
+		r := r
+		_, err = ImgResizeWithOptions(src, filepath.Join(dir, "bad.png"), []string{"png"}, []MediaWH{{Width: -1, Height: -1}}, -1, false, &ImgOptions{
+			Region: &CropRegion{Relative: &r},
+		})
+		if err == nil {
+			t.Errorf("expected error for relative region %+v", r)
+		}
+	}
+}
+
+func TestVariantPath(t *testing.T) {
+	dir := filepath.Join("srv", "example.com", "v1.2")
+	for _, c := range []struct {
+		path, naming, want string
+	}{
+		// 只修改文件名,目录中的"."不变
+		{filepath.Join(dir, "a.png"), "", filepath.Join(dir, "a.S.webp")},
+		{filepath.Join(dir, "a.b.png"), "", filepath.Join(dir, "a.S.b.S.webp")},
+		{filepath.Join(dir, "a.b.png"), "{name}.{size}.{format}", filepath.Join(dir, "a.b.S.webp")},
+		{filepath.Join(dir, "a.png"), "{size}/{name}.{format}", filepath.Join(dir, "S", "a.webp")},
+	} {
+		if got := variantPath(c.path, "S", "webp", c.naming); got != c.want {
+			t.Errorf("variantPath(%q, %q) = %q, want %q", c.path, c.naming, got, c.want)
+		}
+	}
+}
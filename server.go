@@ -0,0 +1,434 @@
+package mediaResize
+
+import (
+	"bytes"
+	"context"
+	"crypto/sha1"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"image"
+	"io"
+	"io/fs"
+	"math"
+	"net/http"
+	"os"
+	"path"
+	"path/filepath"
+	"strconv"
+	"strings"
+)
+
+// Transform URL中的处理参数,如 w_400,h_400,fit_cover,f_webp
+type Transform struct {
+	Width   int    //w_ 最大宽度,0为不限制
+	Height  int    //h_ 最大高度,0为不限制
+	Fit     string //fit_ 缩放方式: inside(默认), cover
+	Crop    string //c_ 裁剪策略: smart, face
+	Quality int    //q_ 图片质量(1-100)
+	Format  string //f_ 输出格式,为空时使用原图格式,FormatAuto 根据Accept请求头选择
+}
+
+// ========================
+//
+//	解析URL中的处理参数
+//	s			string		处理参数,如 w_400,h_400,fit_cover,f_webp
+//	返回值		*Transform	处理参数
+//	返回值		error		错误信息
+func ParseTransform(s string) (*Transform, error) {
+	t := &Transform{}
+	for _, item := range strings.Split(s, ",") {
+		key, value, ok := strings.Cut(item, "_")
+		if !ok || value == "" {
+			return nil, fmt.Errorf("invalid transform: %q", item)
+		}
+		var err error
+		switch key {
+		case "w":
+			t.Width, err = parsePositive(value)
+		case "h":
+			t.Height, err = parsePositive(value)
+		case "q":
+			t.Quality, err = parsePositive(value)
+			if err == nil && t.Quality > 100 {
+				err = errors.New("quality must be between 1 and 100")
+			}
+		case "fit":
+			if value != "inside" && value != FitCover {
+				err = fmt.Errorf("unknown fit: %s", value)
+			}
+			t.Fit = value
+		case "c":
+			if value != CropSmart && value != CropFace {
+				err = fmt.Errorf("unknown crop: %s", value)
+			}
+			t.Crop = value
+		case "f":
+			t.Format = normalizeFormat(value)
+			if t.Format != FormatAuto && !encodableFormat(t.Format) {
+				err = fmt.Errorf("unsupported format: %s", value)
+			}
+		default:
+			err = fmt.Errorf("unknown transform: %s", key)
+		}
+		if err != nil {
+			return nil, err
+		}
+	}
+	if t.Fit == "inside" {
+		t.Fit = ""
+	}
+	return t, nil
+}
+
+func parsePositive(s string) (int, error) {
+	v, err := strconv.Atoi(s)
+	if err != nil {
+		return 0, err
+	}
+	if v <= 0 {
+		return 0, fmt.Errorf("value must be positive: %d", v)
+	}
+	return v, nil
+}
+
+// ========================
+//
+//	按固定顺序输出处理参数,相同参数得到相同的字符串
+//	返回值		string		处理参数
+func (t *Transform) String() string {
+	items := []string{}
+	if t.Width > 0 {
+		items = append(items, "w_"+strconv.Itoa(t.Width))
+	}
+	if t.Height > 0 {
+		items = append(items, "h_"+strconv.Itoa(t.Height))
+	}
+	if t.Fit != "" {
+		items = append(items, "fit_"+t.Fit)
+	}
+	if t.Crop != "" {
+		items = append(items, "c_"+t.Crop)
+	}
+	if t.Quality > 0 {
+		items = append(items, "q_"+strconv.Itoa(t.Quality))
+	}
+	if t.Format != "" {
+		items = append(items, "f_"+t.Format)
+	}
+	return strings.Join(items, ",")
+}
+
+// Handler 按URL参数实时缩放图片的http.Handler,URL格式为 /<处理参数>/<存储key>
+type Handler struct {
+	Storage      Storage     //原图存储
+	Options      *ImgOptions //基础图片参数,如水印、文字等
+	CacheControl string      //Cache-Control响应头,为空时使用 public, max-age=86400
+	MaxWidth     int         //允许的最大宽度,0为不限制
+	MaxHeight    int         //允许的最大高度,0为不限制
+	TempDir      string      //临时目录,为空时使用系统临时目录
+
+	Presets     map[string]*Transform //命名预设,URL中可使用预设名称代替处理参数,如 /thumb/photos/a.jpg
+	PresetsOnly bool                  //只允许使用命名预设
+
+	FormatPreference []string //f_auto时的格式优先级,为空时使用DefaultFormatPreference
+
+	Cache Cache //处理结果缓存,为空时使用Options.Cache
+}
+
+// ========================
+//
+//	创建实时缩放图片的http.Handler
+//	storage		Storage		原图存储
+//	返回值		*Handler	http.Handler
+func NewHandler(storage Storage) *Handler {
+	return &Handler{Storage: storage}
+}
+
+func (h *Handler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet && r.Method != http.MethodHead {
+		w.Header().Set("Allow", "GET, HEAD")
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	seg, key, _ := strings.Cut(strings.TrimPrefix(r.URL.Path, "/"), "/")
+	if seg == "" || key == "" {
+		http.NotFound(w, r)
+		return
+	}
+	t, ok := h.Presets[seg]
+	if !ok {
+		if h.PresetsOnly {
+			http.Error(w, "unknown preset", http.StatusForbidden)
+			return
+		}
+		var err error
+		if t, err = ParseTransform(seg); err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+	}
+	h.serve(w, r, key, t)
+}
+
+// ========================
+//
+//	按处理参数输出图片
+//	w			http.ResponseWriter	响应
+//	r			*http.Request		请求
+//	key			string			存储key
+//	t			*Transform		处理参数
+func (h *Handler) serve(w http.ResponseWriter, r *http.Request, key string, t *Transform) {
+	if (h.MaxWidth > 0 && t.Width > h.MaxWidth) || (h.MaxHeight > 0 && t.Height > h.MaxHeight) {
+		http.Error(w, "requested size is too large", http.StatusBadRequest)
+		return
+	}
+	if t.Format == FormatAuto {
+		auto := *t
+		auto.Format = NegotiateFormat(r.Header.Get("Accept"), encodableFormats, h.FormatPreference)
+		t = &auto
+		SetVary(w.Header())
+	}
+	key = strings.TrimPrefix(path.Clean("/"+key), "/")
+	info, err := h.Storage.Stat(r.Context(), key)
+	if err != nil {
+		httpStorageError(w, r, err)
+		return
+	}
+
+	etag := h.transformETag(key, info, t)
+	header := w.Header()
+	cacheControl := h.CacheControl
+	if cacheControl == "" {
+		cacheControl = "public, max-age=86400"
+	}
+	if etag != "" && etagMatch(r.Header.Get("If-None-Match"), etag) {
+		header.Set("ETag", etag)
+		header.Set("Cache-Control", cacheControl)
+		w.WriteHeader(http.StatusNotModified)
+		return
+	}
+
+	data, format, err := h.render(r.Context(), key, t)
+	if err != nil {
+		// 错误响应不能被缓存
+		header.Set("Cache-Control", "no-store")
+		httpStorageError(w, r, err)
+		return
+	}
+	if etag != "" {
+		header.Set("ETag", etag)
+	}
+	header.Set("Cache-Control", cacheControl)
+	header.Set("Content-Type", formatContentType(format))
+	http.ServeContent(w, r, "", info.ModTime, bytes.NewReader(data))
+}
+
+// ========================
+//
+//	从存储读取原图并使用ImgResizeWithOptions处理
+//	ctx			context.Context	上下文
+//	key			string		存储key
+//	t			*Transform	处理参数
+//	返回值		[]byte		处理后的图片
+//	返回值		string		图片格式
+//	返回值		error		错误信息
+func (h *Handler) render(ctx context.Context, key string, t *Transform) ([]byte, string, error) {
+	dir, err := os.MkdirTemp(h.TempDir, "mediaResize")
+	if err != nil {
+		return nil, "", err
+	}
+	defer os.RemoveAll(dir)
+
+	src := filepath.Join(dir, "src"+path.Ext(key))
+	if err = copyFromStorage(ctx, h.Storage, key, src); err != nil {
+		return nil, "", err
+	}
+	file, err := os.Open(src)
+	if err != nil {
+		return nil, "", err
+	}
+	conf, rformat, err := image.DecodeConfig(file)
+	file.Close()
+	if err != nil {
+		return nil, "", err
+	}
+	format := t.Format
+	if format == "" {
+		format = normalizeFormat(rformat)
+	}
+
+	opts := ImgOptions{}
+	if h.Options != nil {
+		opts = *h.Options
+	}
+	if h.Cache != nil {
+		opts.Cache = h.Cache
+	}
+	opts.Fit, opts.Crop = t.Fit, t.Crop
+	opts.Presets = nil
+	opts.Naming = "out.{size}.{format}"
+	opts.SkipSourceFormat = true
+	quality := -1
+	if t.Quality > 0 {
+		quality = t.Quality
+	}
+
+	wh := transformSize(conf.Width, conf.Height, t)
+	res, err := ImgResizeWithOptions(src, filepath.Join(dir, "out"), []string{format}, []MediaWH{wh}, quality, false, &opts)
+	if err == nil && len(res.Paths) == 0 {
+		// 原图已符合要求时只转换格式,裁剪填充时按目标比例裁剪但不放大
+		if t.Fit == FitCover && t.Width > 0 && t.Height > 0 {
+			opts.Processors = append([]Processor{&aspectStep{width: t.Width, height: t.Height, opts: &opts}}, opts.Processors...)
+		}
+		res, err = ImgResizeWithOptions(src, filepath.Join(dir, "out"), []string{format}, []MediaWH{{Width: -1, Height: -1}}, quality, false, &opts)
+	}
+	if err != nil {
+		return nil, "", err
+	}
+	if len(res.Paths) == 0 {
+		return nil, "", errors.New("no image generated")
+	}
+	data, err := os.ReadFile(res.Paths[0])
+	return data, format, err
+}
+
+// ========================
+//
+//	根据原图宽高和处理参数计算ImgResize使用的宽高
+//	width		int		原图宽度
+//	height		int		原图高度
+//	t			*Transform	处理参数
+//	返回值		MediaWH		宽高,不需要缩放时为-1
+func transformSize(width int, height int, t *Transform) MediaWH {
+	if t.Fit == FitCover && t.Width > 0 && t.Height > 0 {
+		return MediaWH{Width: t.Width, Height: t.Height}
+	}
+	scale := 1.0
+	if t.Width > 0 {
+		scale = math.Min(scale, float64(t.Width)/float64(width))
+	}
+	if t.Height > 0 {
+		scale = math.Min(scale, float64(t.Height)/float64(height))
+	}
+	if scale >= 1 {
+		return MediaWH{Width: -1, Height: -1}
+	}
+	w := int(math.Max(1, math.Round(float64(width)*scale)))
+	h := int(math.Max(1, math.Round(float64(height)*scale)))
+	return MediaWH{Width: w, Height: h}
+}
+
+// ========================
+//
+//	将存储中的文件复制到本地
+//	ctx			context.Context	上下文
+//	storage		Storage		存储
+//	key			string		存储key
+//	dst			string		本地路径
+//	返回值		error		错误信息
+func copyFromStorage(ctx context.Context, storage Storage, key string, dst string) error {
+	rc, err := storage.Get(ctx, key)
+	if err != nil {
+		return err
+	}
+	defer rc.Close()
+	file, err := os.Create(dst)
+	if err != nil {
+		return err
+	}
+	_, err = io.Copy(file, rc)
+	if cerr := file.Close(); err == nil {
+		err = cerr
+	}
+	return err
+}
+
+// ========================
+//
+//	根据原图、处理参数和Handler的图片参数计算ETag,图片参数与缓存key使用相同的处理
+//	key			string		存储key
+//	info		*StorageInfo	原图信息
+//	t			*Transform	处理参数
+//	返回值		string		ETag,图片参数无法计算缓存key时为空
+func (h *Handler) transformETag(key string, info *StorageInfo, t *Transform) string {
+	opts := ImgOptions{}
+	if h.Options != nil {
+		opts = *h.Options
+	}
+	params, err := imgParams(nil, nil, 0, &opts)
+	if err != nil {
+		return ""
+	}
+	data, err := json.Marshal(params)
+	if err != nil {
+		return ""
+	}
+	sum := sha1.Sum([]byte(fmt.Sprintf("%s\x00%d\x00%d\x00%s\x00%s", key, info.Size, info.ModTime.UnixNano(), t.String(), data)))
+	return `"` + hex.EncodeToString(sum[:10]) + `"`
+}
+
+// ========================
+//
+//	判断If-None-Match是否与ETag匹配
+//	header		string		If-None-Match请求头
+//	etag		string		ETag
+//	返回值		bool		是否匹配
+func etagMatch(header string, etag string) bool {
+	for _, v := range strings.Split(header, ",") {
+		v = strings.TrimPrefix(strings.TrimSpace(v), "W/")
+		if v == "*" || v == etag {
+			return true
+		}
+	}
+	return false
+}
+
+func httpStorageError(w http.ResponseWriter, r *http.Request, err error) {
+	switch {
+	case errors.Is(err, fs.ErrNotExist), errors.Is(err, fs.ErrInvalid):
+		http.NotFound(w, r)
+	case errors.Is(err, image.ErrFormat):
+		http.Error(w, "unsupported image", http.StatusUnsupportedMediaType)
+	default:
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+	}
+}
+
+// ========================
+//
+//	图片格式对应的Content-Type
+//	format		string		图片格式
+//	返回值		string		Content-Type,不支持的格式为空
+func formatContentType(format string) string {
+	switch strings.ToLower(format) {
+	case "jpg", "jpeg":
+		return "image/jpeg"
+	case "png":
+		return "image/png"
+	case "webp":
+		return "image/webp"
+	case "gif":
+		return "image/gif"
+	case "bmp":
+		return "image/bmp"
+	case "tif", "tiff":
+		return "image/tiff"
+	case "avif":
+		return "image/avif"
+	}
+	return ""
+}
+
+// 可以使用saveImage保存的格式
+var encodableFormats = []string{"webp", "jpg", "png", "gif", "bmp", "tif"}
+
+func encodableFormat(format string) bool {
+	for _, f := range encodableFormats {
+		if f == format {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,173 @@
+package mediaResize
+
+import (
+	"bytes"
+	"image"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func TestParseTransform(t *testing.T) {
+	tr, err := ParseTransform("f_jpeg,h_300,w_400,fit_cover,q_80")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got := tr.String(); got != "w_400,h_300,fit_cover,q_80,f_jpg" {
+		t.Error("canonical transform:", got)
+	}
+	for _, s := range []string{"w_0", "w_abc", "fit_stretch", "q_101", "f_exe", "x_1", "w"} {
+		if _, err := ParseTransform(s); err == nil {
+			t.Errorf("ParseTransform(%q) should fail", s)
+		}
+	}
+}
+
+func TestHandler(t *testing.T) {
+	root := t.TempDir()
+	os.MkdirAll(filepath.Join(root, "photos"), os.ModePerm)
+	newTestImage(t, filepath.Join(root, "photos", "a.png"), 400, 200)
+	srv := httptest.NewServer(NewHandler(NewDirStorage(root)))
+	defer srv.Close()
+
+	resp, err := http.Get(srv.URL + "/w_100,h_100,fit_cover,f_jpg/photos/a.png")
+	if err != nil {
+		t.Fatal(err)
+	}
+	var buf bytes.Buffer
+	buf.ReadFrom(resp.Body)
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		t.Fatal("status:", resp.StatusCode, buf.String())
+	}
+	if ct := resp.Header.Get("Content-Type"); ct != "image/jpeg" {
+		t.Error("content type:", ct)
+	}
+	conf, _, err := image.DecodeConfig(&buf)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if conf.Width != 100 || conf.Height != 100 {
+		t.Errorf("size: %dx%d", conf.Width, conf.Height)
+	}
+	etag := resp.Header.Get("ETag")
+	if etag == "" || resp.Header.Get("Cache-Control") == "" {
+		t.Fatal("missing cache headers")
+	}
+
+	req, _ := http.NewRequest(http.MethodGet, srv.URL+"/f_jpg,fit_cover,h_100,w_100/photos/a.png", nil)
+	req.Header.Set("If-None-Match", etag)
+	resp, err = http.DefaultClient.Do(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusNotModified {
+		t.Error("If-None-Match status:", resp.StatusCode)
+	}
+
+	// 原图小于请求尺寸时不放大
+	resp, err = http.Get(srv.URL + "/w_1000/photos/a.png")
+	if err != nil {
+		t.Fatal(err)
+	}
+	buf.Reset()
+	buf.ReadFrom(resp.Body)
+	resp.Body.Close()
+	conf, format, err := image.DecodeConfig(&buf)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if format != "png" || conf.Width != 400 || conf.Height != 200 {
+		t.Errorf("inside: %s %dx%d", format, conf.Width, conf.Height)
+	}
+
+	// 裁剪填充时原图小于请求尺寸,按比例裁剪但不放大
+	for path, want := range map[string][2]int{
+		"/w_800,h_800,fit_cover/photos/a.png":  {200, 200},
+		"/w_600,h_400,fit_cover/photos/a.png":  {300, 200},
+		"/w_1000,h_250,fit_cover/photos/a.png": {400, 100},
+	} {
+		resp, err = http.Get(srv.URL + path)
+		if err != nil {
+			t.Fatal(err)
+		}
+		buf.Reset()
+		buf.ReadFrom(resp.Body)
+		resp.Body.Close()
+		conf, _, err = image.DecodeConfig(&buf)
+		if err != nil {
+			t.Fatal(path, err)
+		}
+		if conf.Width != want[0] || conf.Height != want[1] {
+			t.Errorf("%s: %dx%d, want %dx%d", path, conf.Width, conf.Height, want[0], want[1])
+		}
+	}
+
+	// 处理失败的响应不能被缓存
+	os.WriteFile(filepath.Join(root, "photos", "b.png"), []byte("not an image"), os.ModePerm)
+	resp, err = http.Get(srv.URL + "/w_100/photos/b.png")
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusUnsupportedMediaType {
+		t.Error("broken image status:", resp.StatusCode)
+	}
+	if resp.Header.Get("ETag") != "" || resp.Header.Get("Cache-Control") != "no-store" {
+		t.Errorf("broken image cache headers: %q %q", resp.Header.Get("ETag"), resp.Header.Get("Cache-Control"))
+	}
+
+	for path, status := range map[string]int{
+		"/w_100/photos/missing.png": http.StatusNotFound,
+		"/w_100/../../etc/passwd":   http.StatusNotFound,
+		"/w_abc/photos/a.png":       http.StatusBadRequest,
+		"/fit_stretch/photos/a.png": http.StatusBadRequest,
+		"/photos/a.png":             http.StatusBadRequest,
+		"/w_100,h_100/photos":       http.StatusNotFound,
+	} {
+		resp, err := http.Get(srv.URL + path)
+		if err != nil {
+			t.Fatal(err)
+		}
+		resp.Body.Close()
+		if resp.StatusCode != status {
+			t.Errorf("%s: status %d, want %d", path, resp.StatusCode, status)
+		}
+	}
+}
+
+func TestTransformETagOptions(t *testing.T) {
+	dir := t.TempDir()
+	mark := filepath.Join(dir, "mark.png")
+	newTestImage(t, mark, 20, 20)
+	h := NewHandler(NewDirStorage(dir))
+	info := &StorageInfo{Size: 100, ModTime: time.Unix(1700000000, 0)}
+	tr := &Transform{Width: 100}
+
+	plain := h.transformETag("a.png", info, tr)
+	h.Options = &ImgOptions{Watermark: &WatermarkOptions{Path: mark}}
+	first := h.transformETag("a.png", info, tr)
+	if first == "" || first == plain {
+		t.Fatal("watermark should change the ETag:", plain, first)
+	}
+	// 水印文件内容改变时ETag改变
+	newTestImage(t, mark, 30, 30)
+	if second := h.transformETag("a.png", info, tr); second == "" || second == first {
+		t.Error("watermark content should change the ETag:", first, second)
+	}
+	h.Options = &ImgOptions{Texts: []*TextOptions{{Text: "a"}}}
+	text := h.transformETag("a.png", info, tr)
+	h.Options = &ImgOptions{Texts: []*TextOptions{{Text: "b"}}}
+	if other := h.transformETag("a.png", info, tr); text == "" || other == text {
+		t.Error("text should change the ETag:", text, other)
+	}
+	// 无法计算缓存key时不使用ETag
+	h.Options = &ImgOptions{Processors: []Processor{levelProcessor{1}}}
+	if etag := h.transformETag("a.png", info, tr); etag != "" {
+		t.Error("uncacheable options should not have an ETag:", etag)
+	}
+}
@@ -0,0 +1,89 @@
+package mediaResize
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/base64"
+	"errors"
+	"net/http"
+	"net/url"
+	"strconv"
+	"time"
+)
+
+var (
+	ErrSignatureMissing = errors.New("mediaResize: missing signature")
+	ErrSignatureInvalid = errors.New("mediaResize: invalid signature")
+	ErrSignatureExpired = errors.New("mediaResize: signature expired")
+)
+
+// ========================
+//
+//	为URL路径签名,签名和过期时间以查询参数 sig 和 exp 附加到路径后
+//	key			[]byte		签名密钥
+//	urlPath		string		URL路径,如 /w_400,f_webp/photos/a.jpg
+//	expires		time.Time	过期时间,零值为不过期
+//	返回值		string		带签名的URL
+func SignURL(key []byte, urlPath string, expires time.Time) string {
+	q := url.Values{}
+	exp := ""
+	if !expires.IsZero() {
+		exp = strconv.FormatInt(expires.Unix(), 10)
+		q.Set("exp", exp)
+	}
+	q.Set("sig", urlSignature(key, urlPath, exp))
+	return urlPath + "?" + q.Encode()
+}
+
+// ========================
+//
+//	校验URL的签名和过期时间
+//	key			[]byte		签名密钥
+//	u			*url.URL	URL
+//	now			time.Time	当前时间
+//	返回值		error		ErrSignatureMissing, ErrSignatureInvalid 或 ErrSignatureExpired
+func VerifyURL(key []byte, u *url.URL, now time.Time) error {
+	q := u.Query()
+	sig := q.Get("sig")
+	if sig == "" {
+		return ErrSignatureMissing
+	}
+	exp := q.Get("exp")
+	if !hmac.Equal([]byte(sig), []byte(urlSignature(key, u.Path, exp))) {
+		return ErrSignatureInvalid
+	}
+	if exp != "" {
+		unix, err := strconv.ParseInt(exp, 10, 64)
+		if err != nil {
+			return ErrSignatureInvalid
+		}
+		if now.Unix() > unix {
+			return ErrSignatureExpired
+		}
+	}
+	return nil
+}
+
+func urlSignature(key []byte, urlPath string, exp string) string {
+	mac := hmac.New(sha256.New, key)
+	mac.Write([]byte(urlPath))
+	mac.Write([]byte{0})
+	mac.Write([]byte(exp))
+	return base64.RawURLEncoding.EncodeToString(mac.Sum(nil))
+}
+
+// ========================
+//
+//	只允许带有效签名的请求访问的中间件,签名校验使用中间件收到的URL路径
+//	key			[]byte		签名密钥
+//	next		http.Handler	下一个处理器
+//	返回值		http.Handler	中间件
+func RequireSignature(key []byte, next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if err := VerifyURL(key, r.URL, time.Now()); err != nil {
+			http.Error(w, err.Error(), http.StatusForbidden)
+			return
+		}
+		next.ServeHTTP(w, r)
+	})
+}
@@ -0,0 +1,71 @@
+package mediaResize
+
+import (
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestSignURL(t *testing.T) {
+	key := []byte("secret")
+	now := time.Unix(1700000000, 0)
+	signed := SignURL(key, "/w_100/a.png", now.Add(time.Minute))
+	u, _ := url.Parse(signed)
+	if err := VerifyURL(key, u, now); err != nil {
+		t.Fatal(err)
+	}
+	if err := VerifyURL(key, u, now.Add(2*time.Minute)); !errors.Is(err, ErrSignatureExpired) {
+		t.Error("expired:", err)
+	}
+	if err := VerifyURL([]byte("other"), u, now); !errors.Is(err, ErrSignatureInvalid) {
+		t.Error("wrong key:", err)
+	}
+	tampered, _ := url.Parse(strings.Replace(signed, "w_100", "w_5000", 1))
+	if err := VerifyURL(key, tampered, now); !errors.Is(err, ErrSignatureInvalid) {
+		t.Error("tampered path:", err)
+	}
+	u.RawQuery = strings.Replace(u.RawQuery, "exp=", "exp=9", 1)
+	if err := VerifyURL(key, u, now); !errors.Is(err, ErrSignatureInvalid) {
+		t.Error("tampered expiry:", err)
+	}
+	unsigned, _ := url.Parse("/w_100/a.png")
+	if err := VerifyURL(key, unsigned, now); !errors.Is(err, ErrSignatureMissing) {
+		t.Error("unsigned:", err)
+	}
+	forever, _ := url.Parse(SignURL(key, "/w_100/a.png", time.Time{}))
+	if err := VerifyURL(key, forever, now.AddDate(100, 0, 0)); err != nil {
+		t.Error("no expiry:", err)
+	}
+}
+
+func TestHandlerSignatureAndPresets(t *testing.T) {
+	root := t.TempDir()
+	newTestImage(t, filepath.Join(root, "a.png"), 200, 100)
+	key := []byte("secret")
+	h := NewHandler(NewDirStorage(root))
+	h.Presets = map[string]*Transform{"thumb": {Width: 50, Height: 50, Fit: FitCover}}
+	h.PresetsOnly = true
+	srv := httptest.NewServer(RequireSignature(key, h))
+	defer srv.Close()
+
+	for path, status := range map[string]int{
+		SignURL(key, "/thumb/a.png", time.Now().Add(time.Hour)): http.StatusOK,
+		"/thumb/a.png":                                           http.StatusForbidden,
+		SignURL(key, "/w_50/a.png", time.Time{}):                 http.StatusForbidden,
+		SignURL(key, "/thumb/a.png", time.Now().Add(-time.Hour)): http.StatusForbidden,
+	} {
+		resp, err := http.Get(srv.URL + path)
+		if err != nil {
+			t.Fatal(err)
+		}
+		resp.Body.Close()
+		if resp.StatusCode != status {
+			t.Errorf("%s: status %d, want %d", path, resp.StatusCode, status)
+		}
+	}
+}
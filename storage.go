@@ -0,0 +1,113 @@
+package mediaResize
+
+import (
+	"context"
+	"errors"
+	"io"
+	"io/fs"
+	"os"
+	"path"
+	"path/filepath"
+	"time"
+)
+
+// Storage 媒体文件存储,key使用"/"分隔,不存在时返回的错误满足errors.Is(err, fs.ErrNotExist)
+type Storage interface {
+	Get(ctx context.Context, key string) (io.ReadCloser, error)
+	Put(ctx context.Context, key string, r io.Reader) error
+	Stat(ctx context.Context, key string) (*StorageInfo, error)
+	Delete(ctx context.Context, key string) error
+}
+
+// StorageInfo 存储对象信息
+type StorageInfo struct {
+	Size    int64     `json:"size"`    //大小(字节)
+	ModTime time.Time `json:"modTime"` //修改时间
+}
+
+// DirStorage 使用本地目录的存储
+type DirStorage struct {
+	Root string //根目录
+}
+
+// ========================
+//
+//	创建使用本地目录的存储
+//	root		string		根目录
+//	返回值		*DirStorage	存储
+func NewDirStorage(root string) *DirStorage {
+	return &DirStorage{Root: root}
+}
+
+// ========================
+//
+//	将key转换为根目录下的路径,不允许访问根目录以外的文件
+//	key			string		存储key
+//	返回值		string		文件路径
+//	返回值		error		错误信息
+func (s *DirStorage) path(key string) (string, error) {
+	clean := path.Clean("/" + key)
+	if clean == "/" {
+		return "", &fs.PathError{Op: "open", Path: key, Err: fs.ErrInvalid}
+	}
+	return filepath.Join(s.Root, filepath.FromSlash(clean)), nil
+}
+
+func (s *DirStorage) Get(ctx context.Context, key string) (io.ReadCloser, error) {
+	p, err := s.path(key)
+	if err != nil {
+		return nil, err
+	}
+	return os.Open(p)
+}
+
+func (s *DirStorage) Put(ctx context.Context, key string, r io.Reader) error {
+	p, err := s.path(key)
+	if err != nil {
+		return err
+	}
+	if err = os.MkdirAll(filepath.Dir(p), os.ModePerm); err != nil {
+		return err
+	}
+	// 先写入临时文件再重命名,避免读取到未写完的文件
+	tmp, err := os.CreateTemp(filepath.Dir(p), ".put-*")
+	if err != nil {
+		return err
+	}
+	_, err = io.Copy(tmp, r)
+	if cerr := tmp.Close(); err == nil {
+		err = cerr
+	}
+	if err != nil {
+		os.Remove(tmp.Name())
+		return err
+	}
+	return os.Rename(tmp.Name(), p)
+}
+
+func (s *DirStorage) Stat(ctx context.Context, key string) (*StorageInfo, error) {
+	p, err := s.path(key)
+	if err != nil {
+		return nil, err
+	}
+	info, err := os.Stat(p)
+	if err != nil {
+		return nil, err
+	}
+	if info.IsDir() {
+		return nil, &fs.PathError{Op: "stat", Path: key, Err: fs.ErrNotExist}
+	}
+	return &StorageInfo{Size: info.Size(), ModTime: info.ModTime()}, nil
+}
+
+func (s *DirStorage) Delete(ctx context.Context, key string) error {
+	p, err := s.path(key)
+	if err != nil {
+		return err
+	}
+	err = os.Remove(p)
+	if errors.Is(err, fs.ErrNotExist) {
+		return nil
+	}
+	return err
+}
@@ -0,0 +1,214 @@
+package mediaResize
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"image"
+	"image/draw"
+	"math"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/disintegration/imaging"
+)
+
+// StoryboardOptions 进度条预览图参数
+type StoryboardOptions struct {
+	Interval   time.Duration `json:"interval,omitempty"`   //截图间隔,默认为5秒
+	Columns    int           `json:"columns,omitempty"`    //每张拼图的列数,默认为5
+	Rows       int           `json:"rows,omitempty"`       //每张拼图的行数,默认为5
+	TileWidth  int           `json:"tileWidth,omitempty"`  //每帧的宽度,默认为160
+	TileHeight int           `json:"tileHeight,omitempty"` //每帧的高度,为0时按视频宽高比计算
+	Quality    int           `json:"quality,omitempty"`    //图片质量,0为编码器默认值
+	BaseURL    string        `json:"baseURL,omitempty"`    //WebVTT中拼图的URL前缀,为空时使用文件名
+}
+
+// StoryboardResult 进度条预览图
+type StoryboardResult struct {
+	Sprites    []string `json:"sprites"`    //拼图路径
+	VTT        string   `json:"vtt"`        //WebVTT文件路径
+	Frames     int      `json:"frames"`     //截图数量
+	TileWidth  int      `json:"tileWidth"`  //每帧的宽度
+	TileHeight int      `json:"tileHeight"` //每帧的高度
+}
+
+// ========================
+//
+//	每隔Interval截取一帧,拼成Columns×Rows的拼图,并生成时间对应拼图坐标的WebVTT文件
+//	ctx			context.Context	上下文
+//	path		string		视频路径
+//	newPath		string		拼图路径,如 storyboard.jpg,生成 storyboard-1.jpg, storyboard-2.jpg... 和 storyboard.vtt
+//	opts		*StoryboardOptions	参数,可以为nil
+//	返回值		*StoryboardResult	生成结果
+//	返回值		error		错误信息
+func VideoStoryboard(ctx context.Context, path string, newPath string, opts *StoryboardOptions) (*StoryboardResult, error) {
+	o := StoryboardOptions{}
+	if opts != nil {
+		o = *opts
+	}
+	if o.Interval <= 0 {
+		o.Interval = 5 * time.Second
+	}
+	if o.Columns <= 0 {
+		o.Columns = 5
+	}
+	if o.Rows <= 0 {
+		o.Rows = 5
+	}
+	if o.TileWidth <= 0 {
+		o.TileWidth = 160
+	}
+	ext := filepath.Ext(newPath)
+	format := normalizeFormat(strings.TrimPrefix(ext, "."))
+	if !encodableFormat(format) {
+		return nil, fmt.Errorf("unsupported storyboard format %q", format)
+	}
+
+	media, err := ProbeMedia(ctx, path)
+	if err != nil {
+		return nil, err
+	}
+	wh, err := media.DisplaySize()
+	if err != nil {
+		return nil, err
+	}
+	duration := media.Duration()
+	if duration <= 0 {
+		return nil, errors.New("unknown video duration")
+	}
+	if o.TileHeight <= 0 {
+		o.TileHeight = int(math.Round(float64(o.TileWidth) * float64(wh.Height) / float64(wh.Width)))
+	}
+
+	tmpDir, err := os.MkdirTemp("", "mediaResize-storyboard-")
+	if err != nil {
+		return nil, err
+	}
+	defer os.RemoveAll(tmpDir)
+	frames, err := extractStoryboardFrames(ctx, path, tmpDir, o.Interval, o.TileWidth, o.TileHeight)
+	if err != nil {
+		return nil, err
+	}
+	// fps滤镜可能在结尾多输出一帧
+	if max := int(math.Ceil(float64(duration) / float64(o.Interval))); len(frames) > max {
+		frames = frames[:max]
+	}
+
+	res := &StoryboardResult{Sprites: []string{}, Frames: len(frames), TileWidth: o.TileWidth, TileHeight: o.TileHeight}
+	perSprite := o.Columns * o.Rows
+	base := strings.TrimSuffix(newPath, ext)
+	for start := 0; start < len(frames); start += perSprite {
+		end := start + perSprite
+		if end > len(frames) {
+			end = len(frames)
+		}
+		sprite := fmt.Sprintf("%s-%d%s", base, len(res.Sprites)+1, ext)
+		if err = writeSprite(sprite, frames[start:end], &o, format); err != nil {
+			return nil, err
+		}
+		res.Sprites = append(res.Sprites, sprite)
+	}
+
+	res.VTT = base + ".vtt"
+	vtt := storyboardVTT(res, &o, duration)
+	if err = os.WriteFile(res.VTT, []byte(vtt), 0o644); err != nil {
+		return nil, err
+	}
+	return res, nil
+}
+
+// ========================
+//
+//	使用fps滤镜按间隔截取并缩放帧
+//	ctx			context.Context	上下文
+//	path		string		视频路径
+//	dir			string		保存截图的目录
+//	interval	time.Duration	截图间隔
+//	width		int		宽
+//	height		int		高
+//	返回值		[]string	按时间排序的截图路径
+//	返回值		error		错误信息
+func extractStoryboardFrames(ctx context.Context, path string, dir string, interval time.Duration, width int, height int) ([]string, error) {
+	filter := fmt.Sprintf("fps=1/%g,scale=%d:%d", interval.Seconds(), width, height)
+	args := []string{"-y", "-i", path, "-vf", filter, "-an", filepath.Join(dir, "%06d.png")}
+	if err := runFFmpeg(ctx, args, nil, nil); err != nil {
+		return nil, err
+	}
+	frames, err := filepath.Glob(filepath.Join(dir, "*.png"))
+	if err != nil {
+		return nil, err
+	}
+	if len(frames) == 0 {
+		return nil, errors.New("no frames extracted")
+	}
+	sort.Strings(frames)
+	return frames, nil
+}
+
+// ========================
+//
+//	将截图按行拼成一张图片,最后一张拼图只保留有截图的行
+//	path		string		拼图路径
+//	frames		[]string	截图路径
+//	o			*StoryboardOptions	参数
+//	format		string		图片格式
+//	返回值		error		错误信息
+func writeSprite(path string, frames []string, o *StoryboardOptions, format string) error {
+	rows := (len(frames) + o.Columns - 1) / o.Columns
+	cols := o.Columns
+	if rows == 1 {
+		cols = len(frames)
+	}
+	sprite := image.NewNRGBA(image.Rect(0, 0, cols*o.TileWidth, rows*o.TileHeight))
+	for i, frame := range frames {
+		img, err := imaging.Open(frame)
+		if err != nil {
+			return err
+		}
+		x, y := (i%o.Columns)*o.TileWidth, (i/o.Columns)*o.TileHeight
+		draw.Draw(sprite, image.Rect(x, y, x+o.TileWidth, y+o.TileHeight), img, img.Bounds().Min, draw.Src)
+	}
+	quality := o.Quality
+	if quality <= 0 {
+		quality = -1
+	}
+	return saveImage(sprite, path, format, quality, false)
+}
+
+// ========================
+//
+//	生成WebVTT,每个截图间隔对应拼图中的一个区域
+//	res			*StoryboardResult	拼图
+//	o			*StoryboardOptions	参数
+//	duration	time.Duration	视频时长
+//	返回值		string		WebVTT内容
+func storyboardVTT(res *StoryboardResult, o *StoryboardOptions, duration time.Duration) string {
+	var b strings.Builder
+	b.WriteString("WEBVTT\n")
+	perSprite := o.Columns * o.Rows
+	for i := 0; i < res.Frames; i++ {
+		start := time.Duration(i) * o.Interval
+		end := start + o.Interval
+		if end > duration {
+			end = duration
+		}
+		url := filepath.Base(res.Sprites[i/perSprite])
+		if o.BaseURL != "" {
+			url = strings.TrimSuffix(o.BaseURL, "/") + "/" + url
+		}
+		n := i % perSprite
+		fmt.Fprintf(&b, "\n%s --> %s\n%s#xywh=%d,%d,%d,%d\n", vttTime(start), vttTime(end), url,
+			(n%o.Columns)*o.TileWidth, (n/o.Columns)*o.TileHeight, o.TileWidth, o.TileHeight)
+	}
+	return b.String()
+}
+
+// vttTime WebVTT时间格式 HH:MM:SS.mmm
+func vttTime(d time.Duration) string {
+	ms := d.Milliseconds()
+	return fmt.Sprintf("%02d:%02d:%02d.%03d", ms/3600000, ms/60000%60, ms/1000%60, ms%1000)
+}
@@ -0,0 +1,97 @@
+package mediaResize
+
+import (
+	"context"
+	"fmt"
+	"image"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/disintegration/imaging"
+)
+
+func TestStoryboardVTT(t *testing.T) {
+	res := &StoryboardResult{Sprites: []string{"out/sb-1.jpg", "out/sb-2.jpg"}, Frames: 5, TileWidth: 160, TileHeight: 90}
+	o := &StoryboardOptions{Interval: 10 * time.Second, Columns: 2, Rows: 2, TileWidth: 160, TileHeight: 90, BaseURL: "/media/"}
+	got := storyboardVTT(res, o, 45500*time.Millisecond)
+	want := `WEBVTT
+
+00:00:00.000 --> 00:00:10.000
+/media/sb-1.jpg#xywh=0,0,160,90
+
+00:00:10.000 --> 00:00:20.000
+/media/sb-1.jpg#xywh=160,0,160,90
+
+00:00:20.000 --> 00:00:30.000
+/media/sb-1.jpg#xywh=0,90,160,90
+
+00:00:30.000 --> 00:00:40.000
+/media/sb-1.jpg#xywh=160,90,160,90
+
+00:00:40.000 --> 00:00:45.500
+/media/sb-2.jpg#xywh=0,0,160,90
+`
+	if got != want {
+		t.Errorf("got:\n%s", got)
+	}
+	if s := vttTime(3723004 * time.Millisecond); s != "01:02:03.004" {
+		t.Error("vttTime:", s)
+	}
+}
+
+func TestWriteSprite(t *testing.T) {
+	dir := t.TempDir()
+	frames := []string{}
+	for i := 0; i < 3; i++ {
+		frame := filepath.Join(dir, fmt.Sprintf("%d.png", i))
+		newTestImage(t, frame, 40, 30)
+		frames = append(frames, frame)
+	}
+	o := &StoryboardOptions{Columns: 2, Rows: 2, TileWidth: 40, TileHeight: 30}
+	path := filepath.Join(dir, "sprite.jpg")
+	if err := writeSprite(path, frames, o, "jpg"); err != nil {
+		t.Fatal(err)
+	}
+	img, err := imaging.Open(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	// 3帧按2列排为2行
+	if img.Bounds() != image.Rect(0, 0, 80, 60) {
+		t.Error("bounds:", img.Bounds())
+	}
+	if err = writeSprite(path, frames[:1], o, "jpg"); err != nil {
+		t.Fatal(err)
+	}
+	if img, _ = imaging.Open(path); img.Bounds() != image.Rect(0, 0, 40, 30) {
+		t.Error("single row bounds:", img.Bounds())
+	}
+}
+
+func TestVideoStoryboard(t *testing.T) {
+	dir := t.TempDir()
+	src := filepath.Join(dir, "a.mp4")
+	newTestVideo(t, src, 320, 180, 10)
+
+	res, err := VideoStoryboard(context.Background(), src, filepath.Join(dir, "sb.jpg"), &StoryboardOptions{Interval: time.Second, Columns: 3, Rows: 2, TileWidth: 80})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if res.Frames != 10 || len(res.Sprites) != 2 || res.TileHeight != 45 {
+		t.Fatalf("res: %+v", res)
+	}
+	img, err := imaging.Open(res.Sprites[0])
+	if err != nil || img.Bounds() != image.Rect(0, 0, 240, 90) {
+		t.Errorf("sprite: %v %v", img.Bounds(), err)
+	}
+	vtt, err := os.ReadFile(res.VTT)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !strings.HasPrefix(string(vtt), "WEBVTT") || !strings.Contains(string(vtt), "00:00:09.000 --> 00:00:10.000\nsb-2.jpg#xywh=") {
+		t.Errorf("vtt:\n%s", vtt)
+	}
+}
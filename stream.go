@@ -0,0 +1,477 @@
+package mediaResize
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"math"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+)
+
+// 自适应码率输出格式
+const (
+	StreamHLS  = "hls"  //HLS, master.m3u8
+	StreamDASH = "dash" //DASH, manifest.mpd
+)
+
+// StreamOptions 自适应码率输出参数
+type StreamOptions struct {
+	Format         string               `json:"format,omitempty"`         //输出格式: hls, dash, 默认为hls
+	SegmentSeconds float64              `json:"segmentSeconds,omitempty"` //分片时长(秒),默认为4
+	SegmentType    string               `json:"segmentType,omitempty"`    //HLS分片类型: ts, fmp4(CMAF), 默认为ts
+	Bitrates       []int                `json:"bitrates,omitempty"`       //各尺寸的视频码率(k),与maxWHs顺序相同,未设置的尺寸使用AutoBitrate、Encoding.Bitrate或1500
+	AutoBitrate    *BitrateModel        `json:"autoBitrate,omitempty"`    //按分辨率和帧率计算未指定尺寸的码率,不超过原视频码率
+	Encoding       *VideoEncoding       `json:"encoding,omitempty"`       //编码参数,默认为h264,码率控制使用crf时各尺寸码率作为最大码率
+	OnProgress     func(*VideoProgress) `json:"-"`                        //编码进度回调
+}
+
+// StreamRendition 码率阶梯中的一档
+type StreamRendition struct {
+	Name     string `json:"name"`             //尺寸名称,也是分片所在的子目录
+	Width    int    `json:"width"`            //宽
+	Height   int    `json:"height"`           //高
+	Bitrate  int    `json:"bitrate"`          //视频码率(k)
+	Playlist string `json:"playlist"`         //HLS媒体播放列表路径,DASH为空
+	Codecs   string `json:"codecs,omitempty"` //HLS的CODECS属性,如 avc1.64001F,mp4a.40.2,DASH为空
+}
+
+// StreamResult 自适应码率输出结果
+type StreamResult struct {
+	Manifest   string            `json:"manifest"`   //master.m3u8 或 manifest.mpd 路径
+	Renditions []StreamRendition `json:"renditions"` //码率阶梯
+}
+
+// ========================
+//
+//	按maxWHs生成码率阶梯,使用ffmpeg一次编码所有尺寸并对齐关键帧,输出HLS或DASH分片及播放列表
+//	ctx			context.Context	上下文,取消时结束ffmpeg
+//	path		string		原视频路径
+//	outDir		string		输出目录
+//	maxWHs		[]MediaWH	各档的最大宽高,不放大原视频
+//	opts		*StreamOptions	输出参数,可以为nil
+//	返回值		*StreamResult	输出结果
+//	返回值		error		错误信息
+func VideoStreaming(ctx context.Context, path string, outDir string, maxWHs []MediaWH, opts *StreamOptions) (*StreamResult, error) {
+	if opts == nil {
+		opts = &StreamOptions{}
+	}
+	format := strings.ToLower(opts.Format)
+	if format == "" {
+		format = StreamHLS
+	}
+	if format != StreamHLS && format != StreamDASH {
+		return nil, fmt.Errorf("unknown stream format %q", opts.Format)
+	}
+	segType := strings.ToLower(opts.SegmentType)
+	if segType == "" {
+		segType = "ts"
+	}
+	if segType != "ts" && segType != "fmp4" {
+		return nil, fmt.Errorf("unknown segment type %q", opts.SegmentType)
+	}
+	enc := VideoEncoding{Codec: "h264"}
+	if opts.Encoding != nil {
+		enc = *opts.Encoding
+		if enc.Codec == "" && enc.Encoder == "" {
+			enc.Codec = "h264"
+		}
+	}
+	// ts只支持h264/hevc,fmp4和DASH按mp4校验
+	container := "mp4"
+	if format == StreamHLS && segType == "ts" {
+		codec := strings.ToLower(enc.Codec)
+		if codec != "h264" && codec != "hevc" {
+			return nil, fmt.Errorf("video codec %s is not supported in ts segments", enc.Codec)
+		}
+	}
+	if err := enc.Validate(container); err != nil {
+		return nil, err
+	}
+	segment := opts.SegmentSeconds
+	if segment <= 0 {
+		segment = 4
+	}
+
+	media, err := ProbeMedia(ctx, path)
+	if err != nil {
+		return nil, err
+	}
+	srcWH, err := media.DisplaySize()
+	if err != nil {
+		return nil, err
+	}
+	renditions := streamLadder(srcWH, maxWHs, newBitrateSelector(opts.Bitrates, opts.AutoBitrate, &enc, -1, media).bitrate)
+	if len(renditions) == 0 {
+		return nil, errors.New("no renditions")
+	}
+	if err = os.MkdirAll(outDir, os.ModePerm); err != nil {
+		return nil, err
+	}
+
+	fps := 25.0
+	if v := media.VideoStream(); v != nil && v.FPS > 0 {
+		fps = v.FPS
+	}
+	hasAudio := len(media.Audio) > 0 && strings.ToLower(enc.AudioCodec) != "none"
+	// HLS每档需要单独的音频流,DASH的音频在同一adaptation set中
+	audioStreams := 0
+	if hasAudio {
+		audioStreams = 1
+		if format == StreamHLS {
+			audioStreams = len(renditions)
+		}
+	}
+	args := streamArgs(path, renditions, &enc, fps, segment, audioStreams)
+
+	res := &StreamResult{Renditions: renditions}
+	if format == StreamHLS {
+		var streamMap []string
+		for i, r := range renditions {
+			m := fmt.Sprintf("v:%d", i)
+			if hasAudio {
+				m += fmt.Sprintf(",a:%d", i)
+			}
+			streamMap = append(streamMap, m+",name:"+r.Name)
+			res.Renditions[i].Playlist = filepath.Join(outDir, r.Name, "index.m3u8")
+			if err = os.MkdirAll(filepath.Join(outDir, r.Name), os.ModePerm); err != nil {
+				return nil, err
+			}
+		}
+		segName := "seg_%05d.ts"
+		if segType == "fmp4" {
+			segName = "seg_%05d.m4s"
+			args = append(args, "-hls_segment_type", "fmp4", "-hls_fmp4_init_filename", "init.mp4")
+		}
+		args = append(args,
+			"-f", "hls",
+			"-hls_time", strconv.FormatFloat(segment, 'f', -1, 64),
+			"-hls_playlist_type", "vod",
+			"-hls_flags", "independent_segments",
+			"-hls_segment_filename", filepath.Join(outDir, "%v", segName),
+			"-var_stream_map", strings.Join(streamMap, " "),
+			filepath.Join(outDir, "%v", "index.m3u8"),
+		)
+		res.Manifest = filepath.Join(outDir, "master.m3u8")
+	} else {
+		sets := "id=0,streams=v"
+		if hasAudio {
+			sets += " id=1,streams=a"
+		}
+		args = append(args,
+			"-f", "dash",
+			"-seg_duration", strconv.FormatFloat(segment, 'f', -1, 64),
+			"-use_template", "1",
+			"-use_timeline", "1",
+			"-init_seg_name", "init-$RepresentationID$.m4s",
+			"-media_seg_name", "chunk-$RepresentationID$-$Number%05d$.m4s",
+			"-adaptation_sets", sets,
+			filepath.Join(outDir, "manifest.mpd"),
+		)
+		res.Manifest = filepath.Join(outDir, "manifest.mpd")
+	}
+
+	progress := &VideoProgress{Path: res.Manifest, Count: 1, Duration: media.Duration()}
+	if err = runFFmpeg(ctx, append([]string{"-y"}, args...), progress, opts.OnProgress); err != nil {
+		return nil, err
+	}
+	if format == StreamHLS {
+		audioBitrate := 0
+		if hasAudio {
+			audioBitrate = 128
+			if enc.AudioBitrate > 0 {
+				audioBitrate = enc.AudioBitrate
+			}
+		}
+		// CODECS按编码结果的profile和level生成
+		for i := range res.Renditions {
+			r := &res.Renditions[i]
+			probePath := filepath.Join(filepath.Dir(r.Playlist), "seg_00000.ts")
+			if segType == "fmp4" {
+				probePath = filepath.Join(filepath.Dir(r.Playlist), "init.mp4")
+			}
+			out, err := ProbeMedia(ctx, probePath)
+			if err != nil {
+				return nil, err
+			}
+			r.Codecs = hlsCodecs(out)
+		}
+		if err = writeMasterPlaylist(res.Manifest, res.Renditions, audioBitrate, fps); err != nil {
+			return nil, err
+		}
+	}
+	return res, nil
+}
+
+// ========================
+//
+//	生成码率阶梯,大于原视频的档位使用原尺寸,相同宽高只保留第一档
+//	src			*MediaWH	原视频显示宽高
+//	maxWHs		[]MediaWH	各档的最大宽高,-1为原尺寸
+//	bitrate		func(i, w, h int) int	第i档的视频码率(k)
+//	返回值		[]StreamRendition	码率阶梯
+func streamLadder(src *MediaWH, maxWHs []MediaWH, bitrate func(i int, width int, height int) int) []StreamRendition {
+	renditions := []StreamRendition{}
+	seen := map[[2]int]bool{}
+	sizeNamei := 0
+	for i, wh := range maxWHs {
+		name := ""
+		w, h := src.Width, src.Height
+		if wh.Width < 0 || wh.Height < 0 {
+			name = "R"
+		} else {
+			name = sizeName(sizeNamei)
+			sizeNamei++
+			w, h = calcResolutionRatio(src.Width, src.Height, wh.Width, wh.Height)
+			if w > src.Width || h > src.Height {
+				w, h = src.Width, src.Height
+			}
+		}
+		// 编码器要求宽高为偶数
+		w, h = w&^1, h&^1
+		if w <= 0 || h <= 0 || seen[[2]int{w, h}] {
+			continue
+		}
+		seen[[2]int{w, h}] = true
+		renditions = append(renditions, StreamRendition{Name: name, Width: w, Height: h, Bitrate: bitrate(i, w, h)})
+	}
+	return renditions
+}
+
+// ========================
+//
+//	尺寸名称: S, M, L, XL, XXL...
+//	i			int		非原尺寸的序号
+//	返回值		string		尺寸名称
+func sizeName(i int) string {
+	switch i {
+	case 0:
+		return "S"
+	case 1:
+		return "M"
+	case 2:
+		return "L"
+	}
+	return strings.Repeat("X", i-2) + "L"
+}
+
+// ========================
+//
+//	生成一次编码所有档位的ffmpeg参数,各档关键帧按分片时长对齐
+//	path		string		原视频路径
+//	renditions	[]StreamRendition	码率阶梯
+//	enc			*VideoEncoding	编码参数
+//	fps			float64		原视频帧率
+//	segment		float64		分片时长(秒)
+//	audioStreams	int		输出的音频流数量,0为不输出音频
+//	返回值		[]string	ffmpeg参数,不包含输出格式及路径
+func streamArgs(path string, renditions []StreamRendition, enc *VideoEncoding, fps float64, segment float64, audioStreams int) []string {
+	n := len(renditions)
+	filter := fmt.Sprintf("[0:v]split=%d", n)
+	for i := range renditions {
+		filter += fmt.Sprintf("[v%d]", i)
+	}
+	for i, r := range renditions {
+		filter += fmt.Sprintf(";[v%d]scale=%d:%d[v%do]", i, r.Width, r.Height, i)
+	}
+	args := []string{"-i", path, "-filter_complex", filter}
+	for i := range renditions {
+		args = append(args, "-map", fmt.Sprintf("[v%do]", i))
+	}
+	for i := 0; i < audioStreams; i++ {
+		args = append(args, "-map", "0:a:0")
+	}
+
+	codec := strings.ToLower(enc.Codec)
+	encoder := enc.Encoder
+	if encoder == "" {
+		encoder = videoEncoders[codec]
+	}
+	args = append(args, "-c:v", encoder)
+	crf := enc.rateControl() == RateCRF
+	if crf {
+		args = append(args, "-crf", strconv.Itoa(enc.CRF))
+	}
+	for i, r := range renditions {
+		maxrate := r.Bitrate * 107 / 100
+		if !crf {
+			args = append(args, fmt.Sprintf("-b:v:%d", i), fmt.Sprintf("%dk", r.Bitrate))
+		} else {
+			maxrate = r.Bitrate
+		}
+		args = append(args,
+			fmt.Sprintf("-maxrate:v:%d", i), fmt.Sprintf("%dk", maxrate),
+			fmt.Sprintf("-bufsize:v:%d", i), fmt.Sprintf("%dk", r.Bitrate*3/2),
+		)
+	}
+	if enc.Preset != "" {
+		if enc.Encoder == "" && (codec == "vp9" || codec == "av1") {
+			args = append(args, "-cpu-used", enc.Preset)
+		} else {
+			args = append(args, "-preset", enc.Preset)
+		}
+	}
+	if enc.Profile != "" {
+		args = append(args, "-profile:v", enc.Profile)
+	}
+	if enc.Level != "" {
+		args = append(args, "-level:v", enc.Level)
+	}
+	if codec == "hevc" {
+		// 与CODECS属性中的hvc1一致
+		args = append(args, "-tag:v", "hvc1")
+	}
+	pixFmt := enc.PixFmt
+	if pixFmt == "" {
+		pixFmt = "yuv420p"
+	}
+	gop := int(math.Round(fps * segment))
+	if enc.GOP > 0 {
+		gop = enc.GOP
+	}
+	args = append(args,
+		"-pix_fmt", pixFmt,
+		"-g", strconv.Itoa(gop),
+		"-keyint_min", strconv.Itoa(gop),
+		"-sc_threshold", "0",
+		"-force_key_frames", fmt.Sprintf("expr:gte(t,n_forced*%g)", segment),
+	)
+	if audioStreams > 0 {
+		audio := strings.ToLower(enc.AudioCodec)
+		if audio == "" || audio == "copy" {
+			audio = "aac"
+		}
+		audioBitrate := 128
+		if enc.AudioBitrate > 0 {
+			audioBitrate = enc.AudioBitrate
+		}
+		args = append(args, "-c:a", audioEncoders[audio], "-b:a", fmt.Sprintf("%dk", audioBitrate))
+	}
+	return args
+}
+
+// ========================
+//
+//	写入HLS主播放列表
+//	path		string		主播放列表路径
+//	renditions	[]StreamRendition	码率阶梯,Playlist为媒体播放列表路径
+//	audioBitrate	int		音频码率(k),没有音频时为0
+//	fps			float64		帧率
+//	返回值		error		错误信息
+func writeMasterPlaylist(path string, renditions []StreamRendition, audioBitrate int, fps float64) error {
+	var b strings.Builder
+	b.WriteString("#EXTM3U\n#EXT-X-VERSION:6\n#EXT-X-INDEPENDENT-SEGMENTS\n")
+	dir := filepath.Dir(path)
+	for _, r := range renditions {
+		rel, err := filepath.Rel(dir, r.Playlist)
+		if err != nil {
+			return err
+		}
+		// BANDWIDTH为峰值码率,按最大码率计算
+		average := (r.Bitrate + audioBitrate) * 1000
+		peak := (r.Bitrate*107/100 + audioBitrate) * 1000
+		codecs := ""
+		if r.Codecs != "" {
+			codecs = fmt.Sprintf(",CODECS=\"%s\"", r.Codecs)
+		}
+		fmt.Fprintf(&b, "#EXT-X-STREAM-INF:BANDWIDTH=%d,AVERAGE-BANDWIDTH=%d%s,RESOLUTION=%dx%d,FRAME-RATE=%.3f,NAME=\"%s\"\n%s\n",
+			peak, average, codecs, r.Width, r.Height, fps, r.Name, filepath.ToSlash(rel))
+	}
+	tmp := path + ".tmp"
+	if err := os.WriteFile(tmp, []byte(b.String()), 0o644); err != nil {
+		return err
+	}
+	return os.Rename(tmp, path)
+}
+
+// h264 profile名称对应的profile_idc和constraint flags
+var avcProfiles = map[string]string{
+	"baseline":              "4200",
+	"constrained baseline":  "42E0",
+	"main":                  "4D40",
+	"extended":              "5800",
+	"high":                  "6400",
+	"high 10":               "6E00",
+	"high 4:2:2":            "7A00",
+	"high 4:4:4 predictive": "F400",
+}
+
+// ========================
+//
+//	按ffprobe的编码、profile和level生成HLS的CODECS属性(RFC 6381)
+//	media		*MediaInfo	HLS分片的媒体信息
+//	返回值		string		如 avc1.64001F,mp4a.40.2,无法识别的编码时为空
+func hlsCodecs(media *MediaInfo) string {
+	codecs := []string{}
+	if v := media.VideoStream(); v != nil {
+		profile := strings.ToLower(v.Profile)
+		depth := 8
+		if strings.Contains(v.PixFmt, "10") {
+			depth = 10
+		}
+		switch v.Codec {
+		case "h264":
+			idc, ok := avcProfiles[profile]
+			if !ok || v.Level <= 0 {
+				return ""
+			}
+			codecs = append(codecs, fmt.Sprintf("avc1.%s%02X", idc, v.Level))
+		case "hevc":
+			// ffprobe的level为 level*30
+			if v.Level <= 0 {
+				return ""
+			}
+			switch profile {
+			case "main":
+				codecs = append(codecs, fmt.Sprintf("hvc1.1.6.L%d.B0", v.Level))
+			case "main 10":
+				codecs = append(codecs, fmt.Sprintf("hvc1.2.4.L%d.B0", v.Level))
+			default:
+				return ""
+			}
+		case "vp9":
+			var p int
+			if _, err := fmt.Sscanf(profile, "profile %d", &p); err != nil || v.Level <= 0 {
+				return ""
+			}
+			codecs = append(codecs, fmt.Sprintf("vp09.%02d.%02d.%02d", p, v.Level, depth))
+		case "av1":
+			p, ok := map[string]int{"main": 0, "high": 1, "professional": 2}[profile]
+			if !ok || v.Level < 0 {
+				return ""
+			}
+			codecs = append(codecs, fmt.Sprintf("av01.%d.%02dM.%02d", p, v.Level, depth))
+		default:
+			return ""
+		}
+	}
+	if len(media.Audio) > 0 {
+		a := media.Audio[0]
+		switch a.Codec {
+		case "aac":
+			switch strings.ToLower(a.Profile) {
+			case "he-aac":
+				codecs = append(codecs, "mp4a.40.5")
+			case "he-aacv2":
+				codecs = append(codecs, "mp4a.40.29")
+			default:
+				codecs = append(codecs, "mp4a.40.2")
+			}
+		case "mp3":
+			codecs = append(codecs, "mp4a.40.34")
+		case "ac3":
+			codecs = append(codecs, "ac-3")
+		case "eac3":
+			codecs = append(codecs, "ec-3")
+		case "opus":
+			codecs = append(codecs, "Opus")
+		case "flac":
+			codecs = append(codecs, "fLaC")
+		default:
+			return ""
+		}
+	}
+	return strings.Join(codecs, ",")
+}
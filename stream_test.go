@@ -0,0 +1,159 @@
+package mediaResize
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestStreamLadder(t *testing.T) {
+	src := &MediaWH{Width: 1280, Height: 720}
+	maxWHs := []MediaWH{{Width: 427, Height: 427}, {Width: 854, Height: 854}, {Width: 1920, Height: 1920}, {Width: 2560, Height: 2560}, {Width: -1, Height: -1}}
+	got := streamLadder(src, maxWHs, newBitrateSelector([]int{400, 1200}, nil, &VideoEncoding{Bitrate: 2500}, -1, nil).bitrate)
+	want := []StreamRendition{
+		{Name: "S", Width: 426, Height: 240, Bitrate: 400},
+		{Name: "M", Width: 854, Height: 480, Bitrate: 1200},
+		// 不放大原视频,相同尺寸只保留一档
+		{Name: "L", Width: 1280, Height: 720, Bitrate: 2500},
+	}
+	if len(got) != len(want) {
+		t.Fatalf("got %+v", got)
+	}
+	for i := range want {
+		if got[i] != want[i] {
+			t.Errorf("rendition %d: got %+v, want %+v", i, got[i], want[i])
+		}
+	}
+	if names := []string{sizeName(0), sizeName(3), sizeName(4)}; strings.Join(names, ",") != "S,XL,XXL" {
+		t.Error("sizeName:", names)
+	}
+}
+
+func TestStreamArgs(t *testing.T) {
+	renditions := []StreamRendition{{Name: "S", Width: 426, Height: 240, Bitrate: 400}, {Name: "M", Width: 854, Height: 480, Bitrate: 1200}}
+	args := strings.Join(streamArgs("in.mp4", renditions, &VideoEncoding{Codec: "h264", Preset: "fast"}, 30, 2, 2), " ")
+	for _, want := range []string{
+		"-filter_complex [0:v]split=2[v0][v1];[v0]scale=426:240[v0o];[v1]scale=854:480[v1o]",
+		"-map [v0o] -map [v1o] -map 0:a:0 -map 0:a:0",
+		"-c:v libx264",
+		"-b:v:0 400k -maxrate:v:0 428k -bufsize:v:0 600k",
+		"-b:v:1 1200k",
+		"-preset fast",
+		// 关键帧按分片对齐
+		"-g 60 -keyint_min 60 -sc_threshold 0 -force_key_frames expr:gte(t,n_forced*2)",
+		"-c:a aac -b:a 128k",
+	} {
+		if !strings.Contains(args, want) {
+			t.Errorf("missing %q in\n%s", want, args)
+		}
+	}
+	args = strings.Join(streamArgs("in.mp4", renditions, &VideoEncoding{Codec: "h264", CRF: 22}, 25, 4, 0), " ")
+	if !strings.Contains(args, "-crf 22 -maxrate:v:0 400k") || strings.Contains(args, "-b:v:0") || strings.Contains(args, "0:a") {
+		t.Error("crf args:", args)
+	}
+}
+
+func TestWriteMasterPlaylist(t *testing.T) {
+	dir := t.TempDir()
+	renditions := []StreamRendition{
+		{Name: "S", Width: 426, Height: 240, Bitrate: 400, Playlist: filepath.Join(dir, "S", "index.m3u8"), Codecs: "avc1.64001E,mp4a.40.2"},
+		{Name: "M", Width: 854, Height: 480, Bitrate: 1200, Playlist: filepath.Join(dir, "M", "index.m3u8")},
+	}
+	path := filepath.Join(dir, "master.m3u8")
+	if err := writeMasterPlaylist(path, renditions, 128, 25); err != nil {
+		t.Fatal(err)
+	}
+	data, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := `#EXTM3U
+#EXT-X-VERSION:6
+#EXT-X-INDEPENDENT-SEGMENTS
+#EXT-X-STREAM-INF:BANDWIDTH=556000,AVERAGE-BANDWIDTH=528000,CODECS="avc1.64001E,mp4a.40.2",RESOLUTION=426x240,FRAME-RATE=25.000,NAME="S"
+S/index.m3u8
+#EXT-X-STREAM-INF:BANDWIDTH=1412000,AVERAGE-BANDWIDTH=1328000,RESOLUTION=854x480,FRAME-RATE=25.000,NAME="M"
+M/index.m3u8
+`
+	if string(data) != want {
+		t.Errorf("got:\n%s", data)
+	}
+}
+
+func TestHLSCodecs(t *testing.T) {
+	aac := []AudioStream{{Codec: "aac", Profile: "LC"}}
+	for _, c := range []struct {
+		media MediaInfo
+		want  string
+	}{
+		{MediaInfo{Video: []VideoStream{{Codec: "h264", Profile: "High", Level: 31, PixFmt: "yuv420p"}}, Audio: aac}, "avc1.64001F,mp4a.40.2"},
+		{MediaInfo{Video: []VideoStream{{Codec: "h264", Profile: "Constrained Baseline", Level: 30}}}, "avc1.42E01E"},
+		{MediaInfo{Video: []VideoStream{{Codec: "h264", Profile: "Main", Level: 40}}, Audio: []AudioStream{{Codec: "aac", Profile: "HE-AAC"}}}, "avc1.4D4028,mp4a.40.5"},
+		{MediaInfo{Video: []VideoStream{{Codec: "hevc", Profile: "Main 10", Level: 120, PixFmt: "yuv420p10le"}}, Audio: aac}, "hvc1.2.4.L120.B0,mp4a.40.2"},
+		{MediaInfo{Video: []VideoStream{{Codec: "vp9", Profile: "Profile 0", Level: 31, PixFmt: "yuv420p"}}, Audio: []AudioStream{{Codec: "opus"}}}, "vp09.00.31.08,Opus"},
+		{MediaInfo{Video: []VideoStream{{Codec: "av1", Profile: "Main", Level: 8, PixFmt: "yuv420p10le"}}}, "av01.0.08M.10"},
+		// 无法识别时不输出CODECS
+		{MediaInfo{Video: []VideoStream{{Codec: "h264", Profile: "High", Level: -99}}}, ""},
+		{MediaInfo{Video: []VideoStream{{Codec: "mpeg4", Profile: "Simple Profile", Level: 1}}}, ""},
+		{MediaInfo{Video: []VideoStream{{Codec: "h264", Profile: "High", Level: 40}}, Audio: []AudioStream{{Codec: "pcm_s16le"}}}, ""},
+	} {
+		if got := hlsCodecs(&c.media); got != c.want {
+			t.Errorf("%+v: got %q, want %q", c.media.Video, got, c.want)
+		}
+	}
+}
+
+func TestVideoStreamingOptions(t *testing.T) {
+	ctx := context.Background()
+	if _, err := VideoStreaming(ctx, "a.mp4", t.TempDir(), nil, &StreamOptions{Format: "smooth"}); err == nil {
+		t.Error("expected format error")
+	}
+	if _, err := VideoStreaming(ctx, "a.mp4", t.TempDir(), nil, &StreamOptions{Encoding: &VideoEncoding{Codec: "vp9"}}); err == nil {
+		t.Error("expected ts codec error")
+	}
+}
+
+func TestVideoStreaming(t *testing.T) {
+	dir := t.TempDir()
+	src := filepath.Join(dir, "a.mp4")
+	newTestVideo(t, src, 640, 360, 5)
+	maxWHs := []MediaWH{{Width: 320, Height: 320}, {Width: 640, Height: 640}}
+
+	hls := filepath.Join(dir, "hls")
+	res, err := VideoStreaming(context.Background(), src, hls, maxWHs, &StreamOptions{SegmentSeconds: 2, Bitrates: []int{300, 800}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	master, err := os.ReadFile(res.Manifest)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !strings.Contains(string(master), "S/index.m3u8") || !strings.Contains(string(master), "RESOLUTION=640x360") ||
+		strings.Count(string(master), `CODECS="avc1.64`) != len(res.Renditions) || !strings.Contains(string(master), `,mp4a.40.2"`) {
+		t.Errorf("master:\n%s", master)
+	}
+	for _, r := range res.Renditions {
+		playlist, err := os.ReadFile(r.Playlist)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !strings.Contains(string(playlist), "#EXT-X-ENDLIST") || strings.Count(string(playlist), ".ts") < 2 {
+			t.Errorf("%s:\n%s", r.Playlist, playlist)
+		}
+	}
+
+	dash := filepath.Join(dir, "dash")
+	res, err = VideoStreaming(context.Background(), src, dash, maxWHs, &StreamOptions{Format: StreamDASH, SegmentSeconds: 2})
+	if err != nil {
+		t.Fatal(err)
+	}
+	mpd, err := os.ReadFile(res.Manifest)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if strings.Count(string(mpd), "<Representation ") != 3 {
+		t.Errorf("mpd:\n%s", mpd)
+	}
+}
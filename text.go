@@ -0,0 +1,234 @@
+package mediaResize
+
+import (
+	"errors"
+	"image"
+	"image/color"
+	"os"
+	"strings"
+	"sync"
+
+	"github.com/disintegration/imaging"
+	"golang.org/x/image/font"
+	"golang.org/x/image/font/gofont/goregular"
+	"golang.org/x/image/font/opentype"
+	"golang.org/x/image/math/fixed"
+)
+
+const (
+	AlignLeft   = "left" // 默认
+	AlignCenter = "center"
+	AlignRight  = "right"
+)
+
+var (
+	defaultFont     *opentype.Font
+	defaultFontErr  error
+	defaultFontOnce sync.Once
+)
+
+// TextOptions 文字叠加参数
+type TextOptions struct {
+	Text         string       `json:"text"`                   //文字内容,可使用\n换行
+	FontPath     string       `json:"fontPath,omitempty"`     //TTF/OTF字体路径,为空时使用内置的Go Regular(不含中文字形)
+	FontData     []byte       `json:"-"`                      //字体数据,不为nil时优先于FontPath
+	Size         float64      `json:"size,omitempty"`         //字号(像素),默认为24
+	RelativeSize float64      `json:"relativeSize,omitempty"` //字号相对输出宽度的比例,不为0时优先于Size
+	Color        *color.NRGBA `json:"color,omitempty"`        //文字颜色,默认为白色
+	StrokeColor  *color.NRGBA `json:"strokeColor,omitempty"`  //描边颜色,默认为黑色
+	StrokeWidth  int          `json:"strokeWidth,omitempty"`  //描边宽度(像素),0为不描边
+	Align        string       `json:"align,omitempty"`        //多行文字的对齐方式: AlignLeft, AlignCenter, AlignRight
+	Wrap         bool         `json:"wrap,omitempty"`         //超出MaxWidth时自动换行
+	MaxWidth     int          `json:"maxWidth,omitempty"`     //换行宽度(像素),0为输出宽度减去两侧偏移
+	LineSpacing  float64      `json:"lineSpacing,omitempty"`  //行高倍数,默认为1
+	Gravity      string       `json:"gravity,omitempty"`      //文字位置,默认为GravitySouthEast
+	OffsetX      int          `json:"offsetX,omitempty"`      //水平偏移(像素)
+	OffsetY      int          `json:"offsetY,omitempty"`      //垂直偏移(像素)
+	VariantFilter
+}
+
+// ========================
+//
+//	读取字体
+//	opts		*TextOptions	文字叠加参数
+//	返回值		*opentype.Font	字体
+//	返回值		error		错误信息
+func loadFont(opts *TextOptions) (*opentype.Font, error) {
+	data := opts.FontData
+	if data == nil && opts.FontPath != "" {
+		var err error
+		data, err = os.ReadFile(opts.FontPath)
+		if err != nil {
+			return nil, err
+		}
+	}
+	if data != nil {
+		return opentype.Parse(data)
+	}
+	defaultFontOnce.Do(func() {
+		defaultFont, defaultFontErr = opentype.Parse(goregular.TTF)
+	})
+	return defaultFont, defaultFontErr
+}
+
+// ========================
+//
+//	在图片上绘制文字
+//	img			image.Image	图片
+//	opts		*TextOptions	文字叠加参数
+//	返回值		image.Image	新图片
+//	返回值		error		错误信息
+func DrawText(img image.Image, opts *TextOptions) (image.Image, error) {
+	f, err := loadFont(opts)
+	if err != nil {
+		return img, err
+	}
+	return drawText(img, f, opts)
+}
+
+// ========================
+//
+//	使用已读取的字体在图片上绘制文字
+//	img			image.Image	图片
+//	f			*opentype.Font	字体
+//	opts		*TextOptions	文字叠加参数
+//	返回值		*image.NRGBA	新图片
+//	返回值		error		错误信息
+func drawText(img image.Image, f *opentype.Font, opts *TextOptions) (*image.NRGBA, error) {
+	dst := imaging.Clone(img)
+	width, height := dst.Bounds().Dx(), dst.Bounds().Dy()
+	if strings.TrimSpace(opts.Text) == "" {
+		return dst, nil
+	}
+
+	size := opts.Size
+	if opts.RelativeSize > 0 {
+		size = float64(width) * opts.RelativeSize
+	}
+	if size <= 0 {
+		size = 24
+	}
+	face, err := opentype.NewFace(f, &opentype.FaceOptions{Size: size, DPI: 72, Hinting: font.HintingFull})
+	if err != nil {
+		return dst, err
+	}
+	defer face.Close()
+
+	maxWidth := 0
+	if opts.Wrap {
+		maxWidth = opts.MaxWidth
+		if maxWidth <= 0 {
+			maxWidth = width - 2*absInt(opts.OffsetX)
+		}
+		if maxWidth <= 0 {
+			return dst, errors.New("text max width is too small")
+		}
+	}
+	lines := wrapText(face, opts.Text, maxWidth)
+
+	metrics := face.Metrics()
+	spacing := opts.LineSpacing
+	if spacing <= 0 {
+		spacing = 1
+	}
+	lineHeight := int(float64(metrics.Height.Ceil()) * spacing)
+	blockW := 0
+	lineWs := make([]int, len(lines))
+	for i, line := range lines {
+		lineWs[i] = font.MeasureString(face, line).Ceil()
+		if lineWs[i] > blockW {
+			blockW = lineWs[i]
+		}
+	}
+	blockH := lineHeight*(len(lines)-1) + metrics.Height.Ceil()
+	stroke := opts.StrokeWidth
+	if stroke < 0 {
+		stroke = 0
+	}
+	origin := gravityPoint(opts.Gravity, width, height, blockW+2*stroke, blockH+2*stroke, opts.OffsetX, opts.OffsetY)
+	origin = origin.Add(image.Pt(stroke, stroke))
+
+	fill := color.NRGBA{R: 255, G: 255, B: 255, A: 255}
+	if opts.Color != nil {
+		fill = *opts.Color
+	}
+	strokeColor := color.NRGBA{A: 255}
+	if opts.StrokeColor != nil {
+		strokeColor = *opts.StrokeColor
+	}
+
+	d := &font.Drawer{Dst: dst, Face: face}
+	for i, line := range lines {
+		x := origin.X
+		switch opts.Align {
+		case AlignCenter:
+			x += (blockW - lineWs[i]) / 2
+		case AlignRight:
+			x += blockW - lineWs[i]
+		}
+		y := origin.Y + i*lineHeight + metrics.Ascent.Ceil()
+
+		// 描边: 在四周偏移绘制后再绘制文字
+		if stroke > 0 {
+			d.Src = image.NewUniform(strokeColor)
+			for dy := -stroke; dy <= stroke; dy++ {
+				for dx := -stroke; dx <= stroke; dx++ {
+					if dx*dx+dy*dy > stroke*stroke || (dx == 0 && dy == 0) {
+						continue
+					}
+					d.Dot = fixed.P(x+dx, y+dy)
+					d.DrawString(line)
+				}
+			}
+		}
+		d.Src = image.NewUniform(fill)
+		d.Dot = fixed.P(x, y)
+		d.DrawString(line)
+	}
+	return dst, nil
+}
+
+// ========================
+//
+//	按宽度拆分文字,单词过长时按字符拆分
+//	face		font.Face	字体
+//	text		string		文字内容
+//	maxWidth	int		最大宽度,0为只按\n拆分
+//	返回值		[]string	拆分后的各行文字
+func wrapText(face font.Face, text string, maxWidth int) []string {
+	lines := []string{}
+	for _, paragraph := range strings.Split(text, "\n") {
+		if maxWidth <= 0 {
+			lines = append(lines, paragraph)
+			continue
+		}
+		fits := func(s string) bool {
+			return font.MeasureString(face, s).Ceil() <= maxWidth
+		}
+		line := ""
+		for _, word := range strings.Fields(paragraph) {
+			next := word
+			if line != "" {
+				next = line + " " + word
+			}
+			if fits(next) {
+				line = next
+				continue
+			}
+			if line != "" {
+				lines = append(lines, line)
+				line = ""
+			}
+			// 单词本身超出宽度时按字符拆分(适用于中文等无空格文字)
+			for _, r := range word {
+				if line != "" && !fits(line+string(r)) {
+					lines = append(lines, line)
+					line = ""
+				}
+				line += string(r)
+			}
+		}
+		lines = append(lines, line)
+	}
+	return lines
+}
@@ -0,0 +1,50 @@
+package mediaResize
+
+import (
+	"image/color"
+	"testing"
+
+	"github.com/disintegration/imaging"
+	"golang.org/x/image/font/opentype"
+)
+
+func TestDrawText(t *testing.T) {
+	img := imaging.New(200, 100, color.Black)
+	out, err := DrawText(img, &TextOptions{
+		Text:        "SAMPLE SAMPLE SAMPLE",
+		Size:        20,
+		Color:       &color.NRGBA{R: 255, A: 255},
+		StrokeWidth: 1,
+		Align:       AlignCenter,
+		Wrap:        true,
+		Gravity:     GravityCenter,
+	})
+	if err != nil {
+		t.Fatal("DrawText failed:", err)
+	}
+	red := 0
+	b := out.Bounds()
+	for y := b.Min.Y; y < b.Max.Y; y++ {
+		for x := b.Min.X; x < b.Max.X; x++ {
+			if c := color.NRGBAModel.Convert(out.At(x, y)).(color.NRGBA); c.R > 200 && c.G < 50 {
+				red++
+			}
+		}
+	}
+	if red == 0 {
+		t.Error("text was not drawn")
+	}
+
+	f, err := loadFont(&TextOptions{})
+	if err != nil {
+		t.Fatal("loadFont failed:", err)
+	}
+	face, err := opentype.NewFace(f, &opentype.FaceOptions{Size: 20, DPI: 72})
+	if err != nil {
+		t.Fatal("opentype.NewFace failed:", err)
+	}
+	lines := wrapText(face, "SAMPLE SAMPLE SAMPLE\n样张", 100)
+	if len(lines) != 4 {
+		t.Error("unexpected lines:", lines)
+	}
+}
@@ -0,0 +1,225 @@
+package mediaResize
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"math"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// ThumbnailOptions 视频截图参数,Times、Percents和Best都未设置时截取最佳帧
+type ThumbnailOptions struct {
+	Times          []time.Duration `json:"times,omitempty"`          //截图时间
+	Percents       []float64       `json:"percents,omitempty"`       //按时长百分比(0-100)截图
+	Best           bool            `json:"best,omitempty"`           //使用thumbnail滤镜选择最有代表性的一帧
+	BestFrames     int             `json:"bestFrames,omitempty"`     //在整个视频中均匀抽取后由thumbnail滤镜比较的帧数,默认为100
+	SceneThreshold float64         `json:"sceneThreshold,omitempty"` //大于0时只在场景变化(0-1)超过该值的帧中选择最佳帧
+	Image          *ImgOptions     `json:"image,omitempty"`          //截图的图片处理参数,与ImgResizeWithOptions相同
+	IsPrint        bool            `json:"-"`                        //是否打印错误及提示信息
+}
+
+// Thumbnail 视频截图
+type Thumbnail struct {
+	Time  time.Duration `json:"time"`  //截图时间
+	Image *ImgResult    `json:"image"` //生成的图片
+}
+
+// showinfoTime 解析showinfo滤镜输出的帧时间
+var showinfoTime = regexp.MustCompile(`pts_time:\s*([0-9.]+)`)
+
+// ========================
+//
+//	从视频中截图,截取的帧使用ImgResizeWithOptions生成各尺寸和格式的图片
+//	ctx			context.Context	上下文
+//	path		string		视频路径
+//	newPath		string		图片路径,如 poster.jpg,截取多帧时依次为 poster-1.jpg, poster-2.jpg...
+//	formats		[]string	图片格式
+//	maxWHs		[]MediaWH	图片宽高
+//	quality		int		图片质量
+//	opts		*ThumbnailOptions	截图参数,可以为nil
+//	返回值		[]*Thumbnail	截图结果
+//	返回值		error		错误信息
+func VideoThumbnails(ctx context.Context, path string, newPath string, formats []string, maxWHs []MediaWH, quality int, opts *ThumbnailOptions) ([]*Thumbnail, error) {
+	if opts == nil {
+		opts = &ThumbnailOptions{}
+	}
+	media, err := ProbeMedia(ctx, path)
+	if err != nil {
+		return nil, err
+	}
+	if media.VideoStream() == nil {
+		return nil, ErrNoVideoStream
+	}
+	times, err := thumbnailTimes(opts, media.Duration())
+	if err != nil {
+		return nil, err
+	}
+	best := opts.Best || (len(opts.Times) == 0 && len(opts.Percents) == 0)
+
+	tmpDir, err := os.MkdirTemp("", "mediaResize-thumb-")
+	if err != nil {
+		return nil, err
+	}
+	defer os.RemoveAll(tmpDir)
+
+	frames := []string{}
+	thumbs := []*Thumbnail{}
+	for i, t := range times {
+		frame := filepath.Join(tmpDir, fmt.Sprintf("frame-%d.png", i))
+		if err = extractFrame(ctx, path, frame, t); err != nil {
+			return nil, err
+		}
+		frames = append(frames, frame)
+		thumbs = append(thumbs, &Thumbnail{Time: t})
+	}
+	if best {
+		frame := filepath.Join(tmpDir, "best.png")
+		t, err := extractBestFrame(ctx, path, frame, media, opts.BestFrames, opts.SceneThreshold)
+		if err != nil {
+			return nil, err
+		}
+		frames = append(frames, frame)
+		thumbs = append(thumbs, &Thumbnail{Time: t})
+	}
+
+	for i, frame := range frames {
+		thumbPath := newPath
+		if len(frames) > 1 {
+			ext := filepath.Ext(newPath)
+			thumbPath = fmt.Sprintf("%s-%d%s", strings.TrimSuffix(newPath, ext), i+1, ext)
+		}
+		thumbs[i].Image, err = ImgResizeWithOptions(frame, thumbPath, formats, maxWHs, quality, opts.IsPrint, opts.Image)
+		if err != nil {
+			return thumbs[:i+1], err
+		}
+	}
+	return thumbs, nil
+}
+
+// ========================
+//
+//	计算截图时间,超出时长的时间改为最后一秒
+//	opts		*ThumbnailOptions	截图参数
+//	duration	time.Duration	视频时长,0为未知
+//	返回值		[]time.Duration	截图时间
+//	返回值		error		错误信息
+func thumbnailTimes(opts *ThumbnailOptions, duration time.Duration) ([]time.Duration, error) {
+	times := []time.Duration{}
+	last := duration - time.Second
+	if last < 0 {
+		last = 0
+	}
+	for _, t := range opts.Times {
+		if t < 0 {
+			return nil, fmt.Errorf("invalid thumbnail time %v", t)
+		}
+		if duration > 0 && t > last {
+			t = last
+		}
+		times = append(times, t)
+	}
+	for _, p := range opts.Percents {
+		if p < 0 || p > 100 {
+			return nil, fmt.Errorf("invalid thumbnail percent %v", p)
+		}
+		if duration <= 0 {
+			return nil, errors.New("unknown video duration")
+		}
+		t := time.Duration(float64(duration) * p / 100)
+		if t > last {
+			t = last
+		}
+		times = append(times, t)
+	}
+	return times, nil
+}
+
+// ========================
+//
+//	截取指定时间的一帧
+//	ctx			context.Context	上下文
+//	path		string		视频路径
+//	frame		string		图片路径
+//	t			time.Duration	截图时间
+//	返回值		error		错误信息
+func extractFrame(ctx context.Context, path string, frame string, t time.Duration) error {
+	// -ss放在-i前按关键帧快速定位后再精确解码到指定时间
+	args := []string{"-y", "-ss", strconv.FormatFloat(t.Seconds(), 'f', 3, 64), "-i", path, "-frames:v", "1", "-an", frame}
+	if err := runFFmpeg(ctx, args, nil, nil); err != nil {
+		return err
+	}
+	if _, err := os.Stat(frame); err != nil {
+		return fmt.Errorf("no frame at %v", t)
+	}
+	return nil
+}
+
+// ========================
+//
+//	使用thumbnail滤镜截取最有代表性的一帧,设置了场景变化阈值但没有符合的帧时不按场景筛选;
+//	不按场景筛选时先在整个视频中均匀抽取n帧,避免只比较开头的n帧
+//	ctx			context.Context	上下文
+//	path		string		视频路径
+//	frame		string		图片路径
+//	media		*MediaInfo	视频信息,用于计算抽帧间隔
+//	n			int		每次比较的帧数,默认为100
+//	scene		float64		场景变化阈值,0为不筛选
+//	返回值		time.Duration	截取的帧的时间
+//	返回值		error		错误信息
+func extractBestFrame(ctx context.Context, path string, frame string, media *MediaInfo, n int, scene float64) (time.Duration, error) {
+	if n <= 0 {
+		n = 100
+	}
+	filter := fmt.Sprintf("thumbnail=%d,showinfo", n)
+	if scene > 0 {
+		// 场景变化的帧较少,直接在其中选择
+		filter = fmt.Sprintf("select='gt(scene,%g)',", scene) + filter
+	} else if step := bestFrameStep(media, n); step > 1 {
+		filter = fmt.Sprintf("select='not(mod(n,%d))',", step) + filter
+	}
+	args := []string{"-y", "-i", path, "-vf", filter, "-frames:v", "1", "-an", frame}
+	output, err := exec.CommandContext(ctx, "ffmpeg", args...).CombinedOutput()
+	if err != nil {
+		return 0, fmt.Errorf("ffmpeg: %w: %s", err, lastLines(output, 10))
+	}
+	if _, err = os.Stat(frame); err != nil {
+		if scene > 0 {
+			return extractBestFrame(ctx, path, frame, media, n, 0)
+		}
+		return 0, errors.New("no frame extracted")
+	}
+	var t time.Duration
+	if m := showinfoTime.FindSubmatch(output); m != nil {
+		t, _ = parseSeconds(string(m[1]))
+	}
+	return t, nil
+}
+
+// ========================
+//
+//	计算均匀抽取n帧的间隔
+//	media		*MediaInfo	视频信息
+//	n			int		抽取的帧数
+//	返回值		int		每隔多少帧抽取一帧,帧数未知时为1
+func bestFrameStep(media *MediaInfo, n int) int {
+	v := media.VideoStream()
+	if v == nil {
+		return 1
+	}
+	// 没有帧数时按时长和帧率估算
+	frames := float64(v.Frames)
+	if frames <= 0 {
+		frames = media.Duration().Seconds() * v.FPS
+	}
+	if frames <= float64(n) {
+		return 1
+	}
+	return int(math.Ceil(frames / float64(n)))
+}
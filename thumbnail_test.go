@@ -0,0 +1,112 @@
+package mediaResize
+
+import (
+	"context"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func TestThumbnailTimes(t *testing.T) {
+	opts := &ThumbnailOptions{Times: []time.Duration{2 * time.Second, time.Minute}, Percents: []float64{0, 50, 100}}
+	got, err := thumbnailTimes(opts, 10*time.Second)
+	if err != nil {
+		t.Fatal(err)
+	}
+	// 超出时长的时间改为最后一秒
+	want := []time.Duration{2 * time.Second, 9 * time.Second, 0, 5 * time.Second, 9 * time.Second}
+	if len(got) != len(want) {
+		t.Fatalf("got %v", got)
+	}
+	for i := range want {
+		if got[i] != want[i] {
+			t.Errorf("time %d: got %v, want %v", i, got[i], want[i])
+		}
+	}
+	if _, err = thumbnailTimes(&ThumbnailOptions{Percents: []float64{120}}, 10*time.Second); err == nil {
+		t.Error("expected percent error")
+	}
+	if _, err = thumbnailTimes(&ThumbnailOptions{Percents: []float64{50}}, 0); err == nil {
+		t.Error("expected duration error")
+	}
+	if got, err = thumbnailTimes(&ThumbnailOptions{}, 10*time.Second); err != nil || len(got) != 0 {
+		t.Error("empty:", got, err)
+	}
+}
+
+func TestVideoThumbnails(t *testing.T) {
+	dir := t.TempDir()
+	src := filepath.Join(dir, "a.mp4")
+	newTestVideo(t, src, 320, 240, 4)
+
+	maxWHs := []MediaWH{{Width: 100, Height: 100}, {Width: 200, Height: 200}}
+	thumbs, err := VideoThumbnails(context.Background(), src, filepath.Join(dir, "poster.jpg"), []string{"jpg", "webp"}, maxWHs, 80,
+		&ThumbnailOptions{Times: []time.Duration{time.Second}, Percents: []float64{50}, Best: true})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(thumbs) != 3 || thumbs[0].Time != time.Second || thumbs[1].Time != 2*time.Second {
+		t.Fatalf("thumbs: %+v", thumbs)
+	}
+	for _, name := range []string{"poster-1.S.jpg", "poster-2.M.webp", "poster-3.S.jpg"} {
+		if _, err = os.Stat(filepath.Join(dir, name)); err != nil {
+			t.Error(err)
+		}
+	}
+	img, err := DecodeFileWidthHeight(thumbs[0].Image.Paths[0], "image/jpeg")
+	if err != nil || img.Width != 100 || img.Height != 75 {
+		t.Errorf("size: %+v %v", img, err)
+	}
+
+	// 只截取最佳帧时使用原路径
+	thumbs, err = VideoThumbnails(context.Background(), src, filepath.Join(dir, "best.jpg"), []string{"jpg"}, maxWHs[:1], 80, nil)
+	if err != nil || len(thumbs) != 1 {
+		t.Fatal(thumbs, err)
+	}
+	if _, err = os.Stat(filepath.Join(dir, "best.S.jpg")); err != nil {
+		t.Error(err)
+	}
+}
+
+func TestBestFrameStep(t *testing.T) {
+	for _, c := range []struct {
+		media *MediaInfo
+		n     int
+		want  int
+	}{
+		{&MediaInfo{}, 100, 1},
+		{&MediaInfo{Video: []VideoStream{{FPS: 25}}}, 100, 1},
+		{&MediaInfo{Format: MediaFormat{Duration: 2 * time.Second}, Video: []VideoStream{{FPS: 25}}}, 100, 1},
+		{&MediaInfo{Format: MediaFormat{Duration: 60 * time.Second}, Video: []VideoStream{{FPS: 25}}}, 100, 15},
+		{&MediaInfo{Format: MediaFormat{Duration: 60 * time.Second}, Video: []VideoStream{{FPS: 25, Frames: 1001}}}, 100, 11},
+	} {
+		if got := bestFrameStep(c.media, c.n); got != c.want {
+			t.Errorf("bestFrameStep(%+v, %d) = %d, want %d", c.media, c.n, got, c.want)
+		}
+	}
+}
+
+func TestVideoThumbnailsBlackIntro(t *testing.T) {
+	requireFFmpeg(t)
+	dir := t.TempDir()
+	src := filepath.Join(dir, "intro.mp4")
+	// 前2秒为黑屏,只比较开头25帧时会选中黑屏
+	cmd := exec.Command("ffmpeg", "-y", "-v", "error",
+		"-f", "lavfi", "-i", "color=black:size=320x240:rate=25:duration=2",
+		"-f", "lavfi", "-i", "testsrc2=size=320x240:rate=25:duration=6",
+		"-filter_complex", "[0:v][1:v]concat=n=2:v=1[v]", "-map", "[v]", "-pix_fmt", "yuv420p", src)
+	if output, err := cmd.CombinedOutput(); err != nil {
+		t.Fatal(err, string(output))
+	}
+
+	thumbs, err := VideoThumbnails(context.Background(), src, filepath.Join(dir, "best.jpg"), []string{"jpg"}, []MediaWH{{Width: 100, Height: 100}}, 80,
+		&ThumbnailOptions{Best: true, BestFrames: 25})
+	if err != nil || len(thumbs) != 1 {
+		t.Fatal(thumbs, err)
+	}
+	if thumbs[0].Time < 2*time.Second {
+		t.Errorf("best frame at %v is in the black intro", thumbs[0].Time)
+	}
+}
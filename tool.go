@@ -2,16 +2,17 @@ package mediaResize
 
 import (
 	"bytes"
-	"encoding/json"
+	"context"
 	"errors"
 	"fmt"
 	"image"
 	"image/gif"
 	"image/jpeg"
 	"image/png"
+	"io"
 	"net/http"
 	"os"
-	"os/exec"
+	"path/filepath"
 	"strings"
 
 	"golang.org/x/image/bmp"
@@ -27,6 +28,9 @@ type MediaWH struct {
 	Height int `json:"height"` //高
 }
 
+// ProbeData ffprobe输出的流宽高
+//
+// Deprecated: 使用ProbeMedia
 type ProbeData struct {
 	Streams []struct {
 		Width  int `json:"width"`
@@ -53,26 +57,17 @@ func DecodeFileWidthHeight(path string, fileType string) (*MediaWH, error) {
 	fmt.Println("extType:", extType)
 	switch fType {
 	case "video":
-		cmd := exec.Command("ffprobe", "-v", "quiet", "-print_format", "json", "-show_streams", path)
-
-		output, err := cmd.Output()
+		// 跳过音频流和封面图片,使用旋转后的显示宽高
+		info, err := ProbeMedia(context.Background(), path)
 		if err != nil {
 			return nil, err
 		}
-
-		var data ProbeData
-		err = json.Unmarshal(output, &data)
+		wh, err := info.DisplaySize()
 		if err != nil {
 			return nil, err
 		}
-
-		for _, stream := range data.Streams {
-			fmt.Printf("Width: %d, Height: %d\n", stream.Width, stream.Height)
-			return &MediaWH{
-				Width:  stream.Width,
-				Height: stream.Height,
-			}, nil
-		}
+		fmt.Printf("Width: %d, Height: %d\n", wh.Width, wh.Height)
+		return wh, nil
 	case "image":
 		img, err := imaging.Open(path)
 		if err != nil {
@@ -196,57 +191,101 @@ func imageToBytes(img image.Image, extType string) ([]byte, error) {
 	return buf.Bytes(), nil
 }
 
-func saveImage(img image.Image, path string, imgSize string, imgType string, opts int) (string, error) {
-	var (
-		dst *os.File
-		err error
-	)
-
-	pathList := strings.Split(path, ".")
-	pathList[len(pathList)-1] = imgType
-	path = strings.Join(pathList, ".")
-	path = strings.Replace(path, ".", "."+imgSize+".", -1)
+// ========================
+//
+//	生成各尺寸图片的保存路径
+//	path		string		新图片路径
+//	imgSize		string		尺寸名称
+//	imgType		string		图片格式
+//	naming		string		命名模板,为空时在文件名的每个"."前插入尺寸名称,目录不变
+//	返回值		string		保存路径
+func variantPath(path string, imgSize string, imgType string, naming string) string {
+	dir, base := filepath.Split(path)
+	if naming == "" {
+		nameList := strings.Split(base, ".")
+		nameList[len(nameList)-1] = imgType
+		base = strings.Join(nameList, ".")
+		return dir + strings.Replace(base, ".", "."+imgSize+".", -1)
+	}
+	ext := filepath.Ext(base)
+	name := strings.TrimSuffix(base, ext)
+	newPath := strings.NewReplacer(
+		"{name}", name,
+		"{ext}", strings.TrimPrefix(ext, "."),
+		"{size}", imgSize,
+		"{format}", imgType,
+	).Replace(naming)
+	if filepath.IsAbs(newPath) {
+		return newPath
+	}
+	return filepath.Join(dir, newPath)
+}
 
-	dst, err = os.Create(path)
+// ========================
+//
+//	保存图片
+//	img			image.Image	图片
+//	path		string		保存路径
+//	imgType		string		图片格式
+//	opts		int		图片质量,-1为默认值
+//	lossless	bool		webp是否使用无损压缩
+//	返回值		error		错误信息
+func saveImage(img image.Image, path string, imgType string, opts int, lossless bool) error {
+	dst, err := os.Create(path)
 	if err != nil {
-		dst.Close()
-		return path, err
+		return err
 	}
+	err = encodeImage(dst, img, imgType, opts, lossless)
+	dst.Close()
+	if err != nil {
+		os.Remove(path)
+		return err
+	}
+	return nil
+}
 
+// ========================
+//
+//	编码图片
+//	w			io.Writer	输出
+//	img			image.Image	图片
+//	imgType		string		图片格式
+//	opts		int		图片质量,-1为默认值
+//	lossless	bool		webp是否使用无损压缩
+//	返回值		error		错误信息
+func encodeImage(w io.Writer, img image.Image, imgType string, opts int, lossless bool) error {
+	var err error
 	switch strings.ToLower(imgType) {
 	case "jpg", "jpeg":
 		if opts > 100 {
-			err = jpeg.Encode(dst, img, &jpeg.Options{Quality: 100})
+			err = jpeg.Encode(w, img, &jpeg.Options{Quality: 100})
 		} else if opts < 0 {
-			err = jpeg.Encode(dst, img, nil)
+			err = jpeg.Encode(w, img, nil)
 		} else {
-			err = jpeg.Encode(dst, img, &jpeg.Options{Quality: opts})
+			err = jpeg.Encode(w, img, &jpeg.Options{Quality: opts})
 		}
 	case "webp":
 		if opts > 100 {
-			err = webp.Encode(dst, img, &webp.Options{Lossless: true, Quality: 100})
+			err = webp.Encode(w, img, &webp.Options{Lossless: lossless, Quality: 100})
+		} else if opts < 0 && lossless {
+			err = webp.Encode(w, img, &webp.Options{Lossless: true})
 		} else if opts < 0 {
-			err = webp.Encode(dst, img, &webp.Options{Lossless: true})
+			err = webp.Encode(w, img, &webp.Options{Quality: 90})
 		} else {
-			err = webp.Encode(dst, img, &webp.Options{Lossless: true, Quality: float32(opts)})
+			err = webp.Encode(w, img, &webp.Options{Lossless: lossless, Quality: float32(opts)})
 		}
 	case "png":
-		err = png.Encode(dst, img)
+		err = png.Encode(w, img)
 	case "tif", "tiff":
-		err = tiff.Encode(dst, img, nil)
+		err = tiff.Encode(w, img, nil)
 	case "gif":
-		err = gif.Encode(dst, img, nil)
+		err = gif.Encode(w, img, nil)
 	case "bmp":
-		err = bmp.Encode(dst, img)
+		err = bmp.Encode(w, img)
 	default:
-		dst.Close()
-		return path, errors.New("unknown file type")
-	}
-	dst.Close()
-	if err != nil {
-		return path, err
+		return errors.New("unknown file type")
 	}
-	return path, nil
+	return err
 }
 
 // ========================
@@ -287,6 +326,12 @@ func DetectContentType(path string) (string, error) {
 //	返回值		image.Image	新媒体文件
 //	返回值		error		错误信息
 func Resize(path string, newPath string, contentType string, codeRate int, width int, height int) (image.Image, error) {
+	// enc为nil时不会返回错误
+	codecArgs, _ := videoCodecArgs(nil, "", codeRate, nil)
+	return resize(context.Background(), path, newPath, contentType, codecArgs, width, height, "", nil, nil)
+}
+
+func resize(ctx context.Context, path string, newPath string, contentType string, codecArgs []string, width int, height int, overlay string, progress *VideoProgress, onProgress func(*VideoProgress)) (image.Image, error) {
 	Mediatypes := strings.Split(strings.ToLower(contentType), "/")
 	fType := "image"
 	if len(Mediatypes) > 1 {
@@ -301,55 +346,7 @@ func Resize(path string, newPath string, contentType string, codeRate int, width
 		newImage := imaging.Resize(img, width, height, imaging.Lanczos)
 		return newImage, nil
 	case "video":
-		if height%2 != 0 {
-			height++
-		}
-		scale := fmt.Sprintf("%dx%d", width, height)
-		cRate := fmt.Sprintf("%dk", codeRate)
-		if codeRate < 0 {
-			cRate = "1500k"
-		}
-		if _, err := os.Stat(newPath); !os.IsNotExist(err) {
-			fmt.Println("file exist:", newPath, "break")
-			file, err := os.Open(newPath)
-			if err != nil {
-				file.Close()
-				return nil, err
-			}
-			info, err := file.Stat()
-			file.Close()
-			if err != nil {
-				return nil, err
-			}
-			fmt.Println("file:", newPath, "size:", info.Size())
-			if info.Size() > 0 {
-				return nil, nil
-			} else {
-				err = os.Remove(newPath)
-				if err != nil {
-					return nil, err
-				}
-			}
-		}
-		fmt.Println("Resize scale:", scale)
-		fmt.Println("path:", path)
-		fmt.Println("newPath:", newPath)
-		fmt.Println("contentType:", contentType)
-		fmt.Println("codeRate:", cRate)
-
-		cmd := exec.Command("ffmpeg", "-i", path, "-b:v", cRate, "-s", scale, "-acodec", "copy", newPath)
-
-		// err := cmd.Run()
-		// if err != nil {
-		// 	return nil, err
-		// }
-		output, err := cmd.CombinedOutput()
-		if err != nil {
-			fmt.Println("Error:", err)
-			fmt.Println(string(output))
-			return nil, err
-		}
-
+		return nil, resizeVideo(ctx, path, newPath, contentType, codecArgs, width, height, overlay, progress, onProgress)
 	}
 	return nil, nil
 }
@@ -380,3 +377,64 @@ func calcResolutionRatio(mediaWidth int, mediaHeght int, width int, height int)
 	}
 	return int(newWidth), int(newHeight)
 }
+
+// ========================
+//
+//	使用ffmpeg缩放并压缩视频
+//	ctx			context.Context	上下文,取消时结束ffmpeg
+//	path		string		原视频路径
+//	newPath		string		新视频路径
+//	contentType	string		视频类型
+//	codecArgs	[]string	videoCodecArgs生成的编码参数
+//	width		int		缩放宽度,小于等于0时不缩放
+//	height		int		缩放高度,小于等于0时不缩放
+//	overlay		string		水印图层路径,为空时不添加水印
+//	progress	*VideoProgress	进度模板
+//	onProgress	func(*VideoProgress)	进度回调,为nil时不读取进度
+//	返回值		error		错误信息
+func resizeVideo(ctx context.Context, path string, newPath string, contentType string, codecArgs []string, width int, height int, overlay string, progress *VideoProgress, onProgress func(*VideoProgress)) error {
+	if height%2 != 0 {
+		height++
+	}
+	scale := fmt.Sprintf("%dx%d", width, height)
+	fmt.Println("Resize scale:", scale)
+	fmt.Println("path:", path)
+	fmt.Println("newPath:", newPath)
+	fmt.Println("contentType:", contentType)
+
+	// 已存在的文件总是重新生成,是否复用由缓存key决定;先写入同目录的临时文件,成功后再重命名
+	ext := filepath.Ext(newPath)
+	tmp, err := os.CreateTemp(filepath.Dir(newPath), "."+strings.TrimSuffix(filepath.Base(newPath), ext)+".*"+ext)
+	if err != nil {
+		return err
+	}
+	tmpPath := tmp.Name()
+	tmp.Close()
+
+	args := []string{"-y", "-i", path}
+	if overlay != "" {
+		// 缩放后叠加水印图层
+		args = append(args,
+			"-i", overlay,
+			"-filter_complex", fmt.Sprintf("[0:v]scale=%d:%d[v];[v][1:v]overlay=0:0[out]", width, height),
+			"-map", "[out]", "-map", "0:a?",
+		)
+	} else if width > 0 && height > 0 {
+		args = append(args, "-s", scale)
+	}
+	args = append(args, codecArgs...)
+	args = append(args, tmpPath)
+	if progress == nil {
+		progress = &VideoProgress{Path: newPath, Count: 1}
+	}
+	// 失败或取消时runFFmpeg删除临时文件
+	if err = runFFmpeg(ctx, args, progress, onProgress); err != nil {
+		fmt.Println("Error:", err)
+		return err
+	}
+	if err = os.Rename(tmpPath, newPath); err != nil {
+		os.Remove(tmpPath)
+		return err
+	}
+	return nil
+}
@@ -0,0 +1,111 @@
+package mediaResize
+
+import (
+	"image"
+	"image/color"
+
+	"github.com/disintegration/imaging"
+)
+
+// TrimOptions 去除纯色边框的参数
+type TrimOptions struct {
+	Tolerance int          `json:"tolerance,omitempty"` //颜色容差(0-255),各通道与边框颜色的差值不超过该值时视为边框
+	Padding   int          `json:"padding,omitempty"`   //去除边框后保留的边距(像素)
+	Color     *color.NRGBA `json:"color,omitempty"`     //边框颜色,为nil时使用左上角像素的颜色
+}
+
+// ========================
+//
+//	检测图片去除纯色边框后的区域
+//	img			image.Image	图片
+//	opts		*TrimOptions	去除边框参数,为nil时使用默认值
+//	返回值		image.Rectangle	保留区域,整张图片均为边框颜色时返回原图区域
+func TrimBounds(img image.Image, opts *TrimOptions) image.Rectangle {
+	if opts == nil {
+		opts = &TrimOptions{}
+	}
+	b := img.Bounds()
+	if b.Empty() {
+		return b
+	}
+	src := imaging.Clone(img)
+	w, h := src.Bounds().Dx(), src.Bounds().Dy()
+
+	ref := color.NRGBAModel.Convert(img.At(b.Min.X, b.Min.Y)).(color.NRGBA)
+	if opts.Color != nil {
+		ref = *opts.Color
+	}
+	isBorder := func(x int, y int) bool {
+		i := src.PixOffset(x, y)
+		p := src.Pix[i : i+4 : i+4]
+		// 透明像素只比较透明度
+		if ref.A == 0 || p[3] == 0 {
+			return absInt(int(p[3])-int(ref.A)) <= opts.Tolerance
+		}
+		return absInt(int(p[0])-int(ref.R)) <= opts.Tolerance &&
+			absInt(int(p[1])-int(ref.G)) <= opts.Tolerance &&
+			absInt(int(p[2])-int(ref.B)) <= opts.Tolerance &&
+			absInt(int(p[3])-int(ref.A)) <= opts.Tolerance
+	}
+	rowIsBorder := func(y int, x0 int, x1 int) bool {
+		for x := x0; x < x1; x++ {
+			if !isBorder(x, y) {
+				return false
+			}
+		}
+		return true
+	}
+	colIsBorder := func(x int, y0 int, y1 int) bool {
+		for y := y0; y < y1; y++ {
+			if !isBorder(x, y) {
+				return false
+			}
+		}
+		return true
+	}
+
+	top := 0
+	for top < h && rowIsBorder(top, 0, w) {
+		top++
+	}
+	if top == h {
+		return b
+	}
+	bottom := h
+	for bottom > top && rowIsBorder(bottom-1, 0, w) {
+		bottom--
+	}
+	left := 0
+	for left < w && colIsBorder(left, top, bottom) {
+		left++
+	}
+	right := w
+	for right > left && colIsBorder(right-1, top, bottom) {
+		right--
+	}
+
+	rect := image.Rect(left-opts.Padding, top-opts.Padding, right+opts.Padding, bottom+opts.Padding)
+	return rect.Add(b.Min).Intersect(b)
+}
+
+// ========================
+//
+//	去除图片的纯色边框
+//	img			image.Image	图片
+//	opts		*TrimOptions	去除边框参数,为nil时使用默认值
+//	返回值		image.Image	新图片
+//	返回值		image.Rectangle	保留区域(原图坐标)
+func TrimImage(img image.Image, opts *TrimOptions) (image.Image, image.Rectangle) {
+	rect := TrimBounds(img, opts)
+	if rect == img.Bounds() {
+		return img, rect
+	}
+	return imaging.Crop(img, rect), rect
+}
+
+func absInt(v int) int {
+	if v < 0 {
+		return -v
+	}
+	return v
+}
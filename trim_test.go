@@ -0,0 +1,27 @@
+package mediaResize
+
+import (
+	"image"
+	"image/color"
+	"testing"
+
+	"github.com/disintegration/imaging"
+)
+
+func TestTrimBounds(t *testing.T) {
+	img := imaging.New(100, 80, color.NRGBA{R: 255, G: 255, B: 255, A: 255})
+	img = imaging.Paste(img, imaging.New(40, 40, color.NRGBA{R: 255, A: 255}), image.Pt(20, 10))
+	img.Set(5, 5, color.NRGBA{R: 250, G: 250, B: 250, A: 255})
+
+	rect := TrimBounds(img, &TrimOptions{Tolerance: 10, Padding: 2})
+	if rect != image.Rect(18, 8, 62, 52) {
+		t.Error("unexpected trim bounds:", rect)
+	}
+
+	transparent := imaging.New(50, 50, color.NRGBA{})
+	transparent.Set(10, 20, color.NRGBA{G: 255, A: 255})
+	newImage, rect := TrimImage(transparent, nil)
+	if rect != image.Rect(10, 20, 11, 21) || newImage.Bounds().Dx() != 1 {
+		t.Error("unexpected trim bounds:", rect)
+	}
+}
@@ -0,0 +1,336 @@
+package mediaResize
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"image"
+	"io"
+	"mime/multipart"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// DefaultUploadTypes 默认允许上传的文件类型
+var DefaultUploadTypes = []string{"image/jpeg", "image/png", "image/gif", "image/webp", "video/mp4", "video/webm"}
+
+// UploadHandler 接收multipart上传,校验后保存并生成缩放后的文件
+type UploadHandler struct {
+	Dir          string      //保存目录
+	BaseURL      string      //返回的文件URL前缀,如 /media
+	Field        string      //表单字段名,为空时处理所有文件字段
+	MaxBytes     int64       //请求体最大字节数,0为32MB
+	MaxFiles     int         //单次请求最多文件数,0为不限制
+	AllowedTypes []string    //允许的文件类型,为空时使用DefaultUploadTypes,可使用 image/* 通配
+	MinWidth     int         //最小宽度,0为不限制
+	MinHeight    int         //最小高度,0为不限制
+	MaxWidth     int         //最大宽度,0为不限制
+	MaxHeight    int         //最大高度,0为不限制
+	Spec         *ResizeSpec //缩放参数,VideoFormats为空时视频只保存不缩放
+	IsPrint      bool        //是否打印处理信息
+}
+
+// UploadResponse 上传接口的JSON响应
+type UploadResponse struct {
+	Files []*UploadFile `json:"files"`
+}
+
+// UploadFile 上传的文件及生成的文件
+type UploadFile struct {
+	Field       string           `json:"field"`       //表单字段名
+	Filename    string           `json:"filename"`    //上传的文件名
+	ContentType string           `json:"contentType"` //检测到的文件类型
+	Width       int              `json:"width"`       //宽
+	Height      int              `json:"height"`      //高
+	URL         string           `json:"url"`         //原文件URL
+	Variants    []*UploadVariant `json:"variants"`    //生成的文件
+}
+
+// UploadVariant 生成的文件
+type UploadVariant struct {
+	URL    string `json:"url"`    //文件URL
+	Size   string `json:"size"`   //尺寸名称
+	Format string `json:"format"` //格式
+}
+
+// uploadError 带HTTP状态码的上传错误
+type uploadError struct {
+	status int
+	msg    string
+}
+
+func (e *uploadError) Error() string {
+	return e.msg
+}
+
+// ========================
+//
+//	创建上传处理器
+//	dir			string		保存目录
+//	baseURL		string		返回的文件URL前缀
+//	spec		*ResizeSpec	缩放参数
+//	返回值		*UploadHandler	上传处理器
+func NewUploadHandler(dir string, baseURL string, spec *ResizeSpec) *UploadHandler {
+	return &UploadHandler{Dir: dir, BaseURL: baseURL, Spec: spec}
+}
+
+func (h *UploadHandler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost && r.Method != http.MethodPut {
+		w.Header().Set("Allow", "POST, PUT")
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	maxBytes := h.MaxBytes
+	if maxBytes <= 0 {
+		maxBytes = 32 << 20
+	}
+	r.Body = http.MaxBytesReader(w, r.Body, maxBytes)
+	if err := r.ParseMultipartForm(maxBytes); err != nil {
+		var maxErr *http.MaxBytesError
+		if errors.As(err, &maxErr) {
+			writeUploadError(w, &uploadError{http.StatusRequestEntityTooLarge, "request body too large"})
+			return
+		}
+		writeUploadError(w, &uploadError{http.StatusBadRequest, err.Error()})
+		return
+	}
+	defer r.MultipartForm.RemoveAll()
+
+	headers := []*multipart.FileHeader{}
+	fields := []string{}
+	for field, fhs := range r.MultipartForm.File {
+		if h.Field != "" && field != h.Field {
+			continue
+		}
+		for _, fh := range fhs {
+			headers = append(headers, fh)
+			fields = append(fields, field)
+		}
+	}
+	if len(headers) == 0 {
+		writeUploadError(w, &uploadError{http.StatusBadRequest, "no file uploaded"})
+		return
+	}
+	if h.MaxFiles > 0 && len(headers) > h.MaxFiles {
+		writeUploadError(w, &uploadError{http.StatusBadRequest, fmt.Sprintf("too many files, at most %d", h.MaxFiles)})
+		return
+	}
+
+	res := &UploadResponse{Files: []*UploadFile{}}
+	saved := []string{}
+	for i, fh := range headers {
+		file, paths, err := h.save(fh)
+		saved = append(saved, paths...)
+		if err != nil {
+			// 任一文件失败时删除本次请求已保存的文件
+			for _, p := range saved {
+				os.Remove(p)
+			}
+			writeUploadError(w, err)
+			return
+		}
+		file.Field = fields[i]
+		res.Files = append(res.Files, file)
+	}
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusCreated)
+	json.NewEncoder(w).Encode(res)
+}
+
+// ========================
+//
+//	校验并保存单个文件,再生成缩放后的文件
+//	fh			*multipart.FileHeader	上传的文件
+//	返回值		*UploadFile		上传结果
+//	返回值		[]string		已保存的文件路径
+//	返回值		error			错误信息
+func (h *UploadHandler) save(fh *multipart.FileHeader) (*UploadFile, []string, error) {
+	src, err := fh.Open()
+	if err != nil {
+		return nil, nil, err
+	}
+	defer src.Close()
+	head := make([]byte, 512)
+	n, err := io.ReadFull(src, head)
+	if err != nil && err != io.ErrUnexpectedEOF && err != io.EOF {
+		return nil, nil, err
+	}
+	contentType := http.DetectContentType(head[:n])
+	if !h.allowed(contentType) {
+		return nil, nil, &uploadError{http.StatusUnsupportedMediaType, "unsupported file type: " + contentType}
+	}
+	if _, err = src.Seek(0, io.SeekStart); err != nil {
+		return nil, nil, err
+	}
+
+	if err = os.MkdirAll(h.Dir, os.ModePerm); err != nil {
+		return nil, nil, err
+	}
+	name, err := randomName()
+	if err != nil {
+		return nil, nil, err
+	}
+	path := filepath.Join(h.Dir, name+contentTypeExt(contentType))
+	dst, err := os.Create(path)
+	if err != nil {
+		return nil, nil, err
+	}
+	_, err = io.Copy(dst, src)
+	if cerr := dst.Close(); err == nil {
+		err = cerr
+	}
+	paths := []string{path}
+	if err != nil {
+		return nil, paths, err
+	}
+
+	res := &UploadFile{Filename: fh.Filename, ContentType: contentType, URL: h.url(path), Variants: []*UploadVariant{}}
+	mediaType := strings.SplitN(contentType, "/", 2)[0]
+	wh, err := uploadSize(path, contentType)
+	if err != nil {
+		return nil, paths, &uploadError{http.StatusUnprocessableEntity, "invalid media: " + err.Error()}
+	}
+	res.Width, res.Height = wh.Width, wh.Height
+	if (h.MinWidth > 0 && wh.Width < h.MinWidth) || (h.MinHeight > 0 && wh.Height < h.MinHeight) ||
+		(h.MaxWidth > 0 && wh.Width > h.MaxWidth) || (h.MaxHeight > 0 && wh.Height > h.MaxHeight) {
+		return nil, paths, &uploadError{http.StatusUnprocessableEntity, fmt.Sprintf("invalid dimensions: %dx%d", wh.Width, wh.Height)}
+	}
+	if h.Spec == nil {
+		return res, paths, nil
+	}
+
+	var files []ResizeResult
+	switch mediaType {
+	case "image":
+		opts := ImgOptions{}
+		if h.Spec.Image != nil {
+			opts = *h.Spec.Image
+		}
+		if opts.Naming == "" {
+			opts.Naming = "{name}.{size}.{format}"
+		}
+		r, err := ImgResizeWithOptions(path, path, h.Spec.Formats, h.Spec.MaxWHs, h.Spec.Quality, h.IsPrint, &opts)
+		paths = append(paths, r.Paths...)
+		if err != nil {
+			return nil, paths, err
+		}
+		files = r.Files
+	case "video":
+		if len(h.Spec.VideoFormats) == 0 {
+			return res, paths, nil
+		}
+		opts := VideoOptions{}
+		if h.Spec.Video != nil {
+			opts = *h.Spec.Video
+		}
+		if opts.Naming == "" {
+			opts.Naming = "{name}.{size}.{format}"
+		}
+		r, err := VideoResizeWithOptions(path, path, h.Spec.VideoFormats, h.Spec.MaxWHs, h.Spec.CodeRate, h.IsPrint, &opts)
+		paths = append(paths, r.Paths...)
+		if err != nil {
+			return nil, paths, err
+		}
+		files = r.Files
+	}
+	for _, f := range files {
+		res.Variants = append(res.Variants, &UploadVariant{URL: h.url(f.Path), Size: f.Size, Format: f.Format})
+	}
+	return res, paths, nil
+}
+
+// ========================
+//
+//	判断文件类型是否允许上传
+//	contentType	string		http.DetectContentType检测到的类型
+//	返回值		bool		是否允许
+func (h *UploadHandler) allowed(contentType string) bool {
+	types := h.AllowedTypes
+	if len(types) == 0 {
+		types = DefaultUploadTypes
+	}
+	mediaType := strings.TrimSpace(strings.SplitN(contentType, ";", 2)[0])
+	for _, t := range types {
+		if t == mediaType || (strings.HasSuffix(t, "/*") && strings.HasPrefix(mediaType, strings.TrimSuffix(t, "*"))) {
+			return true
+		}
+	}
+	return false
+}
+
+func (h *UploadHandler) url(path string) string {
+	rel, err := filepath.Rel(h.Dir, path)
+	if err != nil {
+		rel = filepath.Base(path)
+	}
+	return strings.TrimSuffix(h.BaseURL, "/") + "/" + filepath.ToSlash(rel)
+}
+
+// ========================
+//
+//	获取上传文件的宽高,图片只解析文件头
+//	path		string		文件路径
+//	contentType	string		文件类型
+//	返回值		*MediaWH	宽高
+//	返回值		error		错误信息
+func uploadSize(path string, contentType string) (*MediaWH, error) {
+	if !strings.HasPrefix(contentType, "image/") {
+		return DecodeFileWidthHeight(path, contentType)
+	}
+	file, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+	conf, _, err := image.DecodeConfig(file)
+	if err != nil {
+		return nil, err
+	}
+	return &MediaWH{Width: conf.Width, Height: conf.Height}, nil
+}
+
+func randomName() (string, error) {
+	b := make([]byte, 12)
+	if _, err := rand.Read(b); err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(b), nil
+}
+
+// ========================
+//
+//	文件类型对应的扩展名
+//	contentType	string		文件类型
+//	返回值		string		扩展名,如 .jpg
+func contentTypeExt(contentType string) string {
+	mediaType, sub, _ := strings.Cut(strings.SplitN(contentType, ";", 2)[0], "/")
+	switch sub {
+	case "jpeg":
+		return ".jpg"
+	case "x-msvideo", "avi":
+		return ".avi"
+	case "quicktime":
+		return ".mov"
+	case "":
+		return ""
+	}
+	if mediaType == "image" || mediaType == "video" || mediaType == "audio" {
+		return "." + sub
+	}
+	return ""
+}
+
+func writeUploadError(w http.ResponseWriter, err error) {
+	status := http.StatusInternalServerError
+	var uerr *uploadError
+	if errors.As(err, &uerr) {
+		status = uerr.status
+	}
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	json.NewEncoder(w).Encode(map[string]string{"error": err.Error()})
+}
@@ -0,0 +1,165 @@
+package mediaResize
+
+import (
+	"bytes"
+	"encoding/json"
+	"mime/multipart"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func uploadRequest(t *testing.T, url string, files map[string]string) *http.Response {
+	var body bytes.Buffer
+	mw := multipart.NewWriter(&body)
+	for name, path := range files {
+		data, err := os.ReadFile(path)
+		if err != nil {
+			t.Fatal(err)
+		}
+		fw, _ := mw.CreateFormFile("file", name)
+		fw.Write(data)
+	}
+	mw.Close()
+	resp, err := http.Post(url, mw.FormDataContentType(), &body)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return resp
+}
+
+func TestUploadHandler(t *testing.T) {
+	src := t.TempDir()
+	newTestImage(t, filepath.Join(src, "big.png"), 300, 200)
+	newTestImage(t, filepath.Join(src, "small.png"), 20, 20)
+	os.WriteFile(filepath.Join(src, "note.txt"), []byte("hello"), 0o644)
+
+	dir := t.TempDir()
+	h := NewUploadHandler(dir, "/media", &ResizeSpec{
+		Formats: []string{"webp"},
+		MaxWHs:  []MediaWH{{Width: 100, Height: 100}},
+		Quality: -1,
+	})
+	h.MinWidth, h.MinHeight = 50, 50
+	srv := httptest.NewServer(h)
+	defer srv.Close()
+
+	resp := uploadRequest(t, srv.URL, map[string]string{"big.png": filepath.Join(src, "big.png")})
+	var res UploadResponse
+	json.NewDecoder(resp.Body).Decode(&res)
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusCreated {
+		t.Fatal("status:", resp.StatusCode)
+	}
+	if len(res.Files) != 1 {
+		t.Fatal("files:", len(res.Files))
+	}
+	f := res.Files[0]
+	if f.Filename != "big.png" || f.ContentType != "image/png" || f.Width != 300 || f.Height != 200 {
+		t.Errorf("file: %+v", f)
+	}
+	if len(f.Variants) == 0 || f.Variants[0].Format != "webp" || f.Variants[0].Size != "S" {
+		t.Fatalf("variants: %+v", f.Variants)
+	}
+	for _, u := range append([]string{f.URL}, f.Variants[0].URL) {
+		if _, err := os.Stat(filepath.Join(dir, filepath.FromSlash(u[len("/media/"):]))); err != nil {
+			t.Error(err)
+		}
+	}
+
+	for name, status := range map[string]int{
+		"small.png": http.StatusUnprocessableEntity,
+		"note.txt":  http.StatusUnsupportedMediaType,
+	} {
+		resp := uploadRequest(t, srv.URL, map[string]string{name: filepath.Join(src, name)})
+		resp.Body.Close()
+		if resp.StatusCode != status {
+			t.Errorf("%s: status %d, want %d", name, resp.StatusCode, status)
+		}
+	}
+
+	h.MaxBytes = 100
+	resp = uploadRequest(t, srv.URL, map[string]string{"big.png": filepath.Join(src, "big.png")})
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusRequestEntityTooLarge {
+		t.Error("MaxBytes status:", resp.StatusCode)
+	}
+
+	entries, _ := os.ReadDir(dir)
+	if len(entries) != 3 {
+		t.Errorf("rejected uploads should be removed, got %d files", len(entries))
+	}
+}
+
+func TestUploadVariantLabels(t *testing.T) {
+	src := t.TempDir()
+	newTestImage(t, filepath.Join(src, "a.png"), 300, 200)
+
+	dir := t.TempDir()
+	h := NewUploadHandler(dir, "/media", &ResizeSpec{
+		Formats: []string{"webp", "jpg"},
+		MaxWHs:  []MediaWH{{Width: 100, Height: 100}, {Width: 200, Height: 200}},
+		Quality: -1,
+	})
+	srv := httptest.NewServer(h)
+	defer srv.Close()
+
+	resp := uploadRequest(t, srv.URL, map[string]string{"a.png": filepath.Join(src, "a.png")})
+	var res UploadResponse
+	json.NewDecoder(resp.Body).Decode(&res)
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusCreated || len(res.Files) != 1 {
+		t.Fatal("status:", resp.StatusCode)
+	}
+	// 每个尺寸生成webp、jpg和原图格式png
+	want := []UploadVariant{
+		{Size: "S", Format: "webp"}, {Size: "S", Format: "jpg"}, {Size: "S", Format: "png"},
+		{Size: "M", Format: "webp"}, {Size: "M", Format: "jpg"}, {Size: "M", Format: "png"},
+	}
+	variants := res.Files[0].Variants
+	if len(variants) != len(want) {
+		t.Fatalf("variants: %d, want %d", len(variants), len(want))
+	}
+	for i, v := range variants {
+		if v.Size != want[i].Size || v.Format != want[i].Format {
+			t.Errorf("variant %d: %s/%s, want %s/%s", i, v.Size, v.Format, want[i].Size, want[i].Format)
+		}
+		if suffix := "." + want[i].Size + "." + want[i].Format; !strings.HasSuffix(v.URL, suffix) {
+			t.Errorf("variant %d: url %s does not end with %s", i, v.URL, suffix)
+		}
+	}
+}
+
+func TestUploadDottedDir(t *testing.T) {
+	src := t.TempDir()
+	newTestVideo(t, filepath.Join(src, "clip.mp4"), 320, 240, 1)
+
+	// 目录中的"."不影响生成的文件路径
+	dir := filepath.Join(t.TempDir(), "example.com", "media")
+	h := NewUploadHandler(dir, "/media", &ResizeSpec{
+		Formats:      []string{"jpg"},
+		VideoFormats: []string{"mp4"},
+		MaxWHs:       []MediaWH{{Width: 160, Height: 160}},
+		CodeRate:     -1,
+	})
+	srv := httptest.NewServer(h)
+	defer srv.Close()
+
+	resp := uploadRequest(t, srv.URL, map[string]string{"clip.mp4": filepath.Join(src, "clip.mp4")})
+	var res UploadResponse
+	json.NewDecoder(resp.Body).Decode(&res)
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusCreated || len(res.Files) != 1 {
+		t.Fatal("status:", resp.StatusCode)
+	}
+	variants := res.Files[0].Variants
+	if len(variants) != 1 || variants[0].Size != "S" || variants[0].Format != "mp4" || !strings.HasSuffix(variants[0].URL, ".S.mp4") {
+		t.Fatalf("variants: %+v", variants)
+	}
+	if _, err := os.Stat(filepath.Join(dir, filepath.FromSlash(variants[0].URL[len("/media/"):]))); err != nil {
+		t.Error(err)
+	}
+}
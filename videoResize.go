@@ -1,10 +1,13 @@
 package mediaResize
 
 import (
+	"context"
+	"errors"
 	"fmt"
+	"image"
 	"net/http"
 	"os"
-	"strings"
+	"path/filepath"
 )
 
 // ========================
@@ -48,27 +51,75 @@ func VideoResizes(paths []string, newPaths []string, formats []string, maxWHs []
 //	返回值		[]string	新图片路径
 //	返回值		error		错误信息
 func VideoResize(path string, newPath string, formats []string, maxWHs []MediaWH, codeRate int, isPrint bool) ([]string, []string, []string, error) {
-	newImagePath := []string{}
-	sizes := []string{}
-	newformats := []string{}
+	res, err := VideoResizeWithOptions(path, newPath, formats, maxWHs, codeRate, isPrint, nil)
+	return res.Paths, res.Sizes, res.Formats, err
+}
+
+// ========================
+//
+//	使用可选参数处理视频
+//	path		string		原视频路径
+//	newPath		string		新视频路径
+//	formats		[]string	视频格式
+//	maxWHs		[]MediaWH	视频宽高
+//	codeRate	int		视频码率,-1为默认值:1500k
+//	isPrint		bool		是否打印错误及提示信息
+//	opts		*VideoOptions	可选参数,为nil时与VideoResize相同
+//	返回值		*VideoResult	处理结果
+//	返回值		error		错误信息
+func VideoResizeWithOptions(path string, newPath string, formats []string, maxWHs []MediaWH, codeRate int, isPrint bool, opts *VideoOptions) (*VideoResult, error) {
+	res := &VideoResult{Paths: []string{}, Sizes: []string{}, Formats: []string{}, Files: []ResizeResult{}}
+	if opts == nil {
+		opts = &VideoOptions{}
+	}
+	ctx := opts.Context
+	if ctx == nil {
+		ctx = context.Background()
+	}
+
+	// 命中缓存时直接写入缓存的文件
+	cacheKey := ""
+	if opts.Cache != nil {
+		key, err := videoCacheKey(path, formats, maxWHs, codeRate, opts)
+		if err != nil {
+			if isPrint {
+				fmt.Println("videoCacheKey failed:", err)
+			}
+		} else {
+			cacheKey = key
+			entry, paths, err := loadCacheEntry(opts.Cache, key, func(size string, format string) string {
+				return variantPath(newPath, size, format, opts.Naming)
+			})
+			if err == nil {
+				if isPrint {
+					fmt.Println("cache hit:", path)
+				}
+				res.Paths, res.Sizes, res.Formats, res.Files = paths, entry.Sizes, entry.Formats, entry.Files
+				return res, nil
+			}
+			if isPrint && !errors.Is(err, ErrCacheMiss) {
+				fmt.Println("loadCacheEntry failed:", err)
+			}
+		}
+	}
 
 	file, err := os.Open(path)
 	if err != nil {
 		// fmt.Println("os.Open failed:", err)
 		file.Close()
-		return newImagePath, sizes, newformats, err
+		return res, err
 	}
 	info, err := file.Stat()
 	if err != nil {
 		file.Close()
-		return newImagePath, sizes, newformats, err
+		return res, err
 	}
 
 	buffer := make([]byte, info.Size())
 	_, err = file.Read(buffer)
 	file.Close()
 	if err != nil {
-		return newImagePath, sizes, newformats, err
+		return res, err
 	}
 
 	contentType := http.DetectContentType(buffer)
@@ -80,18 +131,51 @@ func VideoResize(path string, newPath string, formats []string, maxWHs []MediaWH
 	// 	fExt = strings.ToLower(Mediatypes[1])
 	// }
 
-	// 解析图片宽高后，进行图片缩放
-	videowh, err := DecodeFileWidthHeight(path, contentType)
+	// 解析视频宽高及时长后，进行视频缩放
+	media, err := ProbeMedia(ctx, path)
+	if err != nil {
+		if isPrint {
+			fmt.Println("ProbeMedia failed:", err)
+		}
+		return res, err
+	}
+	videowh, err := media.DisplaySize()
 	if err != nil {
 		if isPrint {
-			fmt.Println("1 DecodeFileWidthHeight failed:", err)
+			fmt.Println("DisplaySize failed:", err)
+		}
+		return res, err
+	}
+
+	// 先校验所有格式的编码参数,避免生成部分文件后才失败
+	for _, v := range formats {
+		_, err = videoCodecArgs(opts.Encoding, v, codeRate, media)
+		if err != nil {
+			if isPrint {
+				fmt.Println("videoCodecArgs failed:", err)
+			}
+			return res, fmt.Errorf("%s: %w", v, err)
+		}
+	}
+	bitrates := newBitrateSelector(opts.Bitrates, opts.AutoBitrate, opts.Encoding, codeRate, media)
+
+	var mark image.Image
+	if opts.Watermark != nil {
+		mark, err = loadWatermark(opts.Watermark)
+		if err != nil {
+			if isPrint {
+				fmt.Println("loadWatermark failed:", err)
+			}
+			return res, err
 		}
-		return newImagePath, sizes, newformats, err
 	}
 
 	exists := map[string]bool{}
 	sizeNamei := 0
 	for i := 0; i < len(maxWHs); i++ {
+		if err := ctx.Err(); err != nil {
+			return res, err
+		}
 		var (
 			videoSize string = ""
 			w         int    = videowh.Width
@@ -120,15 +204,46 @@ func VideoResize(path string, newPath string, formats []string, maxWHs []MediaWH
 			// 不进行图片缩放
 			videoSize = "R"
 			sizeNamei--
-			sizes = append(sizes, videoSize)
+			res.Sizes = append(res.Sizes, videoSize)
 		} else {
 			w, h = calcResolutionRatio(videowh.Width, videowh.Height, maxWHs[i].Width, maxWHs[i].Height)
 			fmt.Println(">>>>> calcResolutionRatio", w, h)
-			sizes = append(sizes, videoSize)
+			res.Sizes = append(res.Sizes, videoSize)
 		}
 
 		sizeNamei++
 
+		// 生成与输出尺寸相同的水印图层
+		overlay := ""
+		if opts.Watermark != nil && opts.Watermark.appliesTo(videoSize, w) {
+			layerH := h
+			if layerH%2 != 0 {
+				layerH++
+			}
+			overlay, err = watermarkLayer(mark, opts.Watermark, w, layerH)
+			if err != nil {
+				if isPrint {
+					fmt.Println("watermarkLayer failed:", err)
+				}
+				return res, err
+			}
+		}
+
+		// 按尺寸选择码率,vbr时不超过最大码率
+		rate := bitrates.bitrate(i, w, h)
+		enc := opts.Encoding
+		if enc != nil {
+			e := *enc
+			if e.rateControl() == RateVBR && rate > e.MaxBitrate {
+				rate = e.MaxBitrate
+			}
+			e.Bitrate = rate
+			enc = &e
+		}
+		if isPrint {
+			fmt.Println("bitrate:", videoSize, rate)
+		}
+
 		// isRformat := false
 
 		// 处理视频并保存到指定地址
@@ -137,29 +252,55 @@ func VideoResize(path string, newPath string, formats []string, maxWHs []MediaWH
 			// 	isRformat = true
 			// }
 
-			pathList := strings.Split(newPath, ".")
-			pathList[len(pathList)-1] = v
-			resizePath := strings.Join(pathList, ".")
-			resizePath = strings.Replace(resizePath, ".", "."+videoSize+".", -1)
+			resizePath := variantPath(newPath, videoSize, v, opts.Naming)
+
+			// 原视频已满足要求时只重新封装
+			scaleW, scaleH := w, h
+			var codecArgs []string
+			if opts.Remux && overlay == "" && canRemux(media, w, h, v, opts.Encoding, rate) {
+				if isPrint {
+					fmt.Println("remux:", resizePath)
+				}
+				codecArgs, scaleW, scaleH = remuxArgs(opts.Encoding, v, media), 0, 0
+			} else if codecArgs, err = videoCodecArgs(enc, v, rate, media); err != nil {
+				if overlay != "" {
+					os.RemoveAll(filepath.Dir(overlay))
+				}
+				return res, fmt.Errorf("%s: %w", v, err)
+			}
 
-			_, err = Resize(path, resizePath, contentType, codeRate, w, h)
+			progress := &VideoProgress{Path: resizePath, Index: len(res.Paths), Count: len(maxWHs) * len(formats), Duration: media.Duration()}
+			_, err = resize(ctx, path, resizePath, contentType, codecArgs, scaleW, scaleH, overlay, progress, opts.OnProgress)
 			if err != nil {
+				if overlay != "" {
+					os.RemoveAll(filepath.Dir(overlay))
+				}
 				if isPrint {
 					fmt.Println("Resize failed:", err)
 				}
-				return newImagePath, sizes, newformats, err
+				return res, err
 			}
 
 			if isPrint {
 				fmt.Println("save video:", path)
 			}
-			newImagePath = append(newImagePath, resizePath)
+			res.Paths = append(res.Paths, resizePath)
+			res.Files = append(res.Files, ResizeResult{Path: resizePath, Size: videoSize, Format: v})
 
 			if _, ok := exists[v]; !ok {
-				newformats = append(newformats, v)
+				res.Formats = append(res.Formats, v)
 				exists[v] = true
 			}
 		}
+		if overlay != "" {
+			os.RemoveAll(filepath.Dir(overlay))
+		}
+	}
+	if cacheKey != "" {
+		entry := &cacheEntry{Sizes: res.Sizes, Formats: res.Formats, Files: res.Files}
+		if err = storeCacheEntry(opts.Cache, cacheKey, entry); err != nil && isPrint {
+			fmt.Println("storeCacheEntry failed:", err)
+		}
 	}
-	return newImagePath, sizes, newformats, nil
+	return res, nil
 }
@@ -0,0 +1,422 @@
+package mediaResize
+
+import (
+	"context"
+	"fmt"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/fsnotify/fsnotify"
+)
+
+// WatchOptions 监听目录的参数
+type WatchOptions struct {
+	Dir             string             //监听目录
+	OutDir          string             //输出目录,为空时输出到原文件所在目录
+	Recursive       bool               //是否监听子目录
+	ProcessExisting bool               //启动时处理目录中已有的文件
+	Debounce        time.Duration      //文件停止变化多久后开始处理,默认为1秒
+	PollInterval    time.Duration      //大于0时使用轮询代替fsnotify,fsnotify不可用时默认为2秒
+	Spec            *ResizeSpec        //缩放参数,VideoFormats为空时跳过视频
+	Manifest        *Manifest          //增量处理记录,不为nil时跳过未变化的图片
+	IsPrint         bool               //是否打印错误及提示信息
+	OnResult        func(*WatchResult) //处理完成后在处理文件的goroutine中调用
+}
+
+// WatchResult 监听到的文件的处理结果
+type WatchResult struct {
+	Path    string       //原文件路径
+	NewPath string       //新文件路径
+	Type    string       //文件类型: image, video
+	Image   *ImgResult   //图片处理结果
+	Video   *VideoResult //视频处理结果
+	Err     error        //错误信息
+}
+
+// pendingFile 等待写入完成的文件
+type pendingFile struct {
+	size     int64
+	modTime  time.Time
+	deadline time.Time
+}
+
+// watcher 监听状态,只在事件循环中读写
+type watcher struct {
+	opts      *WatchOptions
+	debounce  time.Duration
+	pending   map[string]*pendingFile
+	generated map[string]bool
+	snapshot  map[string]fs.FileInfo
+	queue     []string        //等待处理的文件
+	busy      map[string]bool //等待处理或正在处理的文件
+	fw        *fsnotify.Watcher
+}
+
+// ========================
+//
+//	监听目录,新增或修改的文件写入完成后使用ImgResize/VideoResize处理,文件在单独的goroutine中依次处理
+//	ctx取消时结束正在处理的视频,等待处理文件的goroutine退出后返回nil
+//	ctx			context.Context	上下文
+//	opts		*WatchOptions	监听参数
+//	返回值		error		错误信息
+func Watch(ctx context.Context, opts *WatchOptions) error {
+	if opts == nil || opts.Spec == nil {
+		return fmt.Errorf("watch: missing resize spec")
+	}
+	info, err := os.Stat(opts.Dir)
+	if err != nil {
+		return err
+	}
+	if !info.IsDir() {
+		return fmt.Errorf("watch: %s is not a directory", opts.Dir)
+	}
+	w := &watcher{
+		opts:      opts,
+		debounce:  opts.Debounce,
+		pending:   map[string]*pendingFile{},
+		generated: map[string]bool{},
+		busy:      map[string]bool{},
+	}
+	if w.debounce <= 0 {
+		w.debounce = time.Second
+	}
+
+	pollInterval := opts.PollInterval
+	if pollInterval <= 0 {
+		w.fw, err = fsnotify.NewWatcher()
+		if err != nil {
+			if opts.IsPrint {
+				fmt.Println("fsnotify unavailable, polling:", err)
+			}
+			pollInterval = 2 * time.Second
+		} else {
+			defer w.fw.Close()
+		}
+	}
+
+	var (
+		events <-chan fsnotify.Event
+		errs   <-chan error
+		pollC  <-chan time.Time
+	)
+	if w.fw != nil {
+		events, errs = w.fw.Events, w.fw.Errors
+		if err = w.addDir(opts.Dir, opts.ProcessExisting); err != nil {
+			return err
+		}
+	} else {
+		w.snapshot = map[string]fs.FileInfo{}
+		w.poll(opts.ProcessExisting)
+		ticker := time.NewTicker(pollInterval)
+		defer ticker.Stop()
+		pollC = ticker.C
+	}
+
+	flushInterval := w.debounce / 4
+	if flushInterval < 10*time.Millisecond {
+		flushInterval = 10 * time.Millisecond
+	}
+	flush := time.NewTicker(flushInterval)
+	defer flush.Stop()
+
+	// 处理文件的goroutine,事件循环在处理期间继续接收事件
+	work := make(chan string)
+	results := make(chan *WatchResult)
+	stopped := make(chan struct{})
+	go w.worker(ctx, work, results, stopped)
+	defer func() {
+		close(work)
+		<-stopped
+	}()
+
+	for {
+		var (
+			send chan<- string
+			next string
+		)
+		if len(w.queue) > 0 {
+			send, next = work, w.queue[0]
+		}
+		select {
+		case <-ctx.Done():
+			return nil
+		case send <- next:
+			w.queue = w.queue[1:]
+		case res := <-results:
+			w.finish(res)
+		case ev, ok := <-events:
+			if !ok {
+				return nil
+			}
+			w.handleEvent(ev)
+		case err, ok := <-errs:
+			if !ok {
+				return nil
+			}
+			if opts.IsPrint {
+				fmt.Println("watch error:", err)
+			}
+		case <-pollC:
+			w.poll(true)
+		case <-flush.C:
+			w.flush()
+		}
+	}
+}
+
+// ========================
+//
+//	监听目录,Recursive为true时包括子目录
+//	dir			string		目录
+//	schedule	bool		是否处理目录中已有的文件
+//	返回值		error		错误信息
+func (w *watcher) addDir(dir string, schedule bool) error {
+	return filepath.WalkDir(dir, func(path string, d fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+		if d.IsDir() {
+			if path != dir && (!w.opts.Recursive || w.ignored(path)) {
+				return filepath.SkipDir
+			}
+			return w.fw.Add(path)
+		}
+		if schedule {
+			w.schedule(path)
+		}
+		return nil
+	})
+}
+
+// ========================
+//
+//	处理fsnotify事件,重命名时旧路径会收到Rename,新路径会收到Create
+//	ev			fsnotify.Event	事件
+func (w *watcher) handleEvent(ev fsnotify.Event) {
+	path := filepath.Clean(ev.Name)
+	switch {
+	case ev.Has(fsnotify.Remove), ev.Has(fsnotify.Rename):
+		delete(w.pending, path)
+	case ev.Has(fsnotify.Create):
+		info, err := os.Stat(path)
+		if err != nil {
+			return
+		}
+		if info.IsDir() {
+			// 新建或移入的目录,其中已有的文件也需要处理
+			if w.opts.Recursive && !w.ignored(path) {
+				if err = w.addDir(path, true); err != nil && w.opts.IsPrint {
+					fmt.Println("watch add failed:", err)
+				}
+			}
+			return
+		}
+		w.schedule(path)
+	case ev.Has(fsnotify.Write):
+		w.schedule(path)
+	}
+}
+
+// ========================
+//
+//	轮询目录,与上次结果比较找出新增或修改的文件
+//	schedule	bool		是否处理新增或修改的文件
+func (w *watcher) poll(schedule bool) {
+	seen := map[string]bool{}
+	filepath.WalkDir(w.opts.Dir, func(path string, d fs.DirEntry, err error) error {
+		if err != nil {
+			return nil
+		}
+		if d.IsDir() {
+			if path != w.opts.Dir && (!w.opts.Recursive || w.ignored(path)) {
+				return filepath.SkipDir
+			}
+			return nil
+		}
+		info, err := d.Info()
+		if err != nil {
+			return nil
+		}
+		seen[path] = true
+		old, ok := w.snapshot[path]
+		w.snapshot[path] = info
+		if schedule && (!ok || old.Size() != info.Size() || !old.ModTime().Equal(info.ModTime())) {
+			w.schedule(path)
+		}
+		return nil
+	})
+	for path := range w.snapshot {
+		if !seen[path] {
+			delete(w.snapshot, path)
+			delete(w.pending, path)
+		}
+	}
+}
+
+// ========================
+//
+//	记录文件变化,在Debounce时间内没有变化后处理
+//	path		string		文件路径
+func (w *watcher) schedule(path string) {
+	if w.ignored(path) {
+		return
+	}
+	info, err := os.Stat(path)
+	if err != nil || info.IsDir() {
+		return
+	}
+	w.pending[path] = &pendingFile{size: info.Size(), modTime: info.ModTime(), deadline: time.Now().Add(w.debounce)}
+}
+
+// ========================
+//
+//	将已写入完成的文件加入处理队列
+func (w *watcher) flush() {
+	if len(w.busy) > 0 {
+		// 处理完成后才能识别生成的文件,处理期间不加入新文件
+		return
+	}
+	now := time.Now()
+	for path, p := range w.pending {
+		if now.Before(p.deadline) {
+			continue
+		}
+		info, err := os.Stat(path)
+		if err != nil {
+			delete(w.pending, path)
+			continue
+		}
+		if info.Size() != p.size || !info.ModTime().Equal(p.modTime) || info.Size() == 0 {
+			// 仍在写入
+			p.size, p.modTime, p.deadline = info.Size(), info.ModTime(), now.Add(w.debounce)
+			continue
+		}
+		delete(w.pending, path)
+		w.busy[path] = true
+		w.queue = append(w.queue, path)
+	}
+}
+
+// ========================
+//
+//	依次处理队列中的文件,work关闭后关闭stopped
+//	ctx			context.Context	上下文
+//	work		<-chan string	待处理的文件
+//	results		chan<- *WatchResult	处理结果,返回事件循环记录生成的文件
+//	stopped		chan<- struct{}	退出通知
+func (w *watcher) worker(ctx context.Context, work <-chan string, results chan<- *WatchResult, stopped chan<- struct{}) {
+	defer close(stopped)
+	for path := range work {
+		res := w.process(ctx, path)
+		if res == nil {
+			res = &WatchResult{Path: path}
+		} else if w.opts.OnResult != nil {
+			w.opts.OnResult(res)
+		}
+		select {
+		case results <- res:
+		case <-ctx.Done():
+		}
+	}
+}
+
+// ========================
+//
+//	处理完成后记录生成的文件,生成的文件写在监听目录中时不再处理
+//	res			*WatchResult	处理结果
+func (w *watcher) finish(res *WatchResult) {
+	delete(w.busy, res.Path)
+	var paths []string
+	if res.Image != nil {
+		paths = res.Image.Paths
+	}
+	if res.Video != nil {
+		paths = append(paths, res.Video.Paths...)
+	}
+	for _, p := range paths {
+		p = filepath.Clean(p)
+		w.generated[p] = true
+		delete(w.pending, p)
+	}
+}
+
+// ========================
+//
+//	处理单个文件,只读取w.opts
+//	ctx			context.Context	上下文,取消时结束视频编码
+//	path		string		文件路径
+//	返回值		*WatchResult	处理结果,不是图片或视频时为nil
+func (w *watcher) process(ctx context.Context, path string) *WatchResult {
+	contentType, err := DetectContentType(path)
+	if err != nil {
+		return &WatchResult{Path: path, Err: err}
+	}
+	res := &WatchResult{Path: path, NewPath: path, Type: strings.SplitN(contentType, "/", 2)[0]}
+	spec := w.opts.Spec
+	if res.Type != "image" && (res.Type != "video" || len(spec.VideoFormats) == 0) {
+		return nil
+	}
+	if w.opts.OutDir != "" {
+		rel, err := filepath.Rel(w.opts.Dir, path)
+		if err != nil {
+			res.Err = err
+			return res
+		}
+		res.NewPath = filepath.Join(w.opts.OutDir, rel)
+		if err = os.MkdirAll(filepath.Dir(res.NewPath), os.ModePerm); err != nil {
+			res.Err = err
+			return res
+		}
+	}
+	if w.opts.IsPrint {
+		fmt.Println("watch process:", path)
+	}
+
+	if res.Type == "image" {
+		resize := ImgResizeWithOptions
+		if w.opts.Manifest != nil {
+			resize = w.opts.Manifest.ImgResize
+		}
+		res.Image, res.Err = resize(path, res.NewPath, spec.Formats, spec.MaxWHs, spec.Quality, w.opts.IsPrint, spec.Image)
+		if w.opts.Manifest != nil && res.Err == nil {
+			res.Err = w.opts.Manifest.Save()
+		}
+	} else {
+		opts := VideoOptions{}
+		if spec.Video != nil {
+			opts = *spec.Video
+		}
+		opts.Context = ctx
+		res.Video, res.Err = VideoResizeWithOptions(path, res.NewPath, spec.VideoFormats, spec.MaxWHs, spec.CodeRate, w.opts.IsPrint, &opts)
+	}
+	return res
+}
+
+// ========================
+//
+//	是否忽略的路径: 生成的文件、输出目录、隐藏文件和下载中的临时文件
+//	path		string		路径
+//	返回值		bool		是否忽略
+func (w *watcher) ignored(path string) bool {
+	path = filepath.Clean(path)
+	if w.generated[path] {
+		return true
+	}
+	if w.opts.OutDir != "" {
+		if rel, err := filepath.Rel(filepath.Clean(w.opts.OutDir), path); err == nil && rel != ".." && !strings.HasPrefix(rel, ".."+string(filepath.Separator)) {
+			return true
+		}
+	}
+	name := filepath.Base(path)
+	if strings.HasPrefix(name, ".") || strings.HasSuffix(name, "~") {
+		return true
+	}
+	switch strings.ToLower(filepath.Ext(name)) {
+	case ".tmp", ".part", ".crdownload", ".download":
+		return true
+	}
+	return false
+}
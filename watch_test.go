@@ -0,0 +1,123 @@
+package mediaResize
+
+import (
+	"context"
+	"errors"
+	"os"
+	"path/filepath"
+	"sync"
+	"testing"
+	"time"
+)
+
+func TestWatch(t *testing.T) {
+	for name, poll := range map[string]time.Duration{"fsnotify": 0, "poll": 20 * time.Millisecond} {
+		t.Run(name, func(t *testing.T) {
+			dir, out := t.TempDir(), t.TempDir()
+			newTestImage(t, filepath.Join(dir, "existing.png"), 200, 100)
+			os.Mkdir(filepath.Join(dir, "sub"), os.ModePerm)
+
+			results := make(chan *WatchResult, 10)
+			ctx, cancel := context.WithCancel(context.Background())
+			done := make(chan error, 1)
+			go func() {
+				done <- Watch(ctx, &WatchOptions{
+					Dir:          dir,
+					OutDir:       out,
+					Recursive:    true,
+					Debounce:     50 * time.Millisecond,
+					PollInterval: poll,
+					Spec:         &ResizeSpec{Formats: []string{"jpg"}, MaxWHs: []MediaWH{{Width: 50, Height: 50}}, Quality: -1},
+					OnResult:     func(res *WatchResult) { results <- res },
+				})
+			}()
+			time.Sleep(100 * time.Millisecond)
+
+			// 先写入临时文件再重命名,临时文件不应被处理
+			tmp := filepath.Join(dir, "sub", "new.png.part")
+			newTestImage(t, filepath.Join(dir, "draft.png"), 200, 100)
+			os.Rename(filepath.Join(dir, "draft.png"), filepath.Join(dir, "sub", "new.png"))
+			os.WriteFile(tmp, []byte("partial"), 0o644)
+
+			select {
+			case res := <-results:
+				if res.Err != nil {
+					t.Fatal(res.Err)
+				}
+				if res.Path != filepath.Join(dir, "sub", "new.png") || res.Type != "image" {
+					t.Errorf("result: %+v", res)
+				}
+				if _, err := os.Stat(filepath.Join(out, "sub", "new.S.jpg")); err != nil {
+					t.Error(err)
+				}
+			case <-time.After(5 * time.Second):
+				t.Fatal("timeout waiting for watch result")
+			}
+			select {
+			case res := <-results:
+				t.Errorf("unexpected result: %+v", res)
+			case <-time.After(200 * time.Millisecond):
+			}
+
+			cancel()
+			select {
+			case err := <-done:
+				if err != nil {
+					t.Error("Watch:", err)
+				}
+			case <-time.After(time.Second):
+				t.Error("Watch did not stop after cancel")
+			}
+		})
+	}
+}
+
+func TestWatchCancelVideo(t *testing.T) {
+	dir := t.TempDir()
+	newTestVideo(t, filepath.Join(dir, "a.mp4"), 640, 480, 20)
+
+	started := make(chan struct{})
+	var once sync.Once
+	results := make(chan *WatchResult, 1)
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	done := make(chan error, 1)
+	go func() {
+		done <- Watch(ctx, &WatchOptions{
+			Dir:             dir,
+			OutDir:          t.TempDir(),
+			ProcessExisting: true,
+			Debounce:        10 * time.Millisecond,
+			Spec: &ResizeSpec{
+				VideoFormats: []string{"mp4"},
+				MaxWHs:       []MediaWH{{Width: 320, Height: 320}},
+				CodeRate:     -1,
+				Video:        &VideoOptions{OnProgress: func(*VideoProgress) { once.Do(func() { close(started) }) }},
+			},
+			OnResult: func(res *WatchResult) { results <- res },
+		})
+	}()
+	select {
+	case <-started:
+	case <-time.After(10 * time.Second):
+		t.Fatal("encode did not start")
+	}
+
+	// 取消时结束ffmpeg,Watch等待处理结束后返回
+	start := time.Now()
+	cancel()
+	select {
+	case err := <-done:
+		if err != nil {
+			t.Error("Watch:", err)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("Watch did not stop the encode")
+	}
+	if time.Since(start) > 5*time.Second {
+		t.Error("cancel took", time.Since(start))
+	}
+	if res := <-results; !errors.Is(res.Err, context.Canceled) {
+		t.Error("expected context.Canceled, got:", res.Err)
+	}
+}
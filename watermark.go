@@ -0,0 +1,170 @@
+package mediaResize
+
+import (
+	"errors"
+	"image"
+	"image/color"
+	"os"
+	"path/filepath"
+
+	"github.com/disintegration/imaging"
+)
+
+const (
+	GravityCenter    = "center"
+	GravityNorth     = "north"
+	GravitySouth     = "south"
+	GravityEast      = "east"
+	GravityWest      = "west"
+	GravityNorthEast = "northeast"
+	GravityNorthWest = "northwest"
+	GravitySouthEast = "southeast" // 默认
+	GravitySouthWest = "southwest"
+)
+
+// WatermarkOptions 水印参数
+type WatermarkOptions struct {
+	Path    string      `json:"path,omitempty"`    //水印图片路径
+	Image   image.Image `json:"-"`                 //水印图片,不为nil时优先于Path
+	Gravity string      `json:"gravity,omitempty"` //水印位置,默认为GravitySouthEast
+	OffsetX int         `json:"offsetX,omitempty"` //水平偏移(像素),平铺时为水平间距
+	OffsetY int         `json:"offsetY,omitempty"` //垂直偏移(像素),平铺时为垂直间距
+	Opacity float64     `json:"opacity,omitempty"` //不透明度(0-1],默认为1
+	Scale   float64     `json:"scale,omitempty"`   //水印宽度相对输出宽度的比例,0为不缩放
+	Tile    bool        `json:"tile,omitempty"`    //是否平铺
+	VariantFilter
+}
+
+// ========================
+//
+//	读取水印图片
+//	wm			*WatermarkOptions	水印参数
+//	返回值		image.Image		水印图片
+//	返回值		error			错误信息
+func loadWatermark(wm *WatermarkOptions) (image.Image, error) {
+	if wm.Image != nil {
+		return wm.Image, nil
+	}
+	if wm.Path == "" {
+		return nil, errors.New("watermark image is empty")
+	}
+	return imaging.Open(wm.Path)
+}
+
+// ========================
+//
+//	添加水印
+//	img			image.Image		图片
+//	wm			*WatermarkOptions	水印参数
+//	返回值		image.Image		新图片
+//	返回值		error			错误信息
+func ApplyWatermark(img image.Image, wm *WatermarkOptions) (image.Image, error) {
+	mark, err := loadWatermark(wm)
+	if err != nil {
+		return img, err
+	}
+	return drawWatermark(img, mark, wm), nil
+}
+
+// ========================
+//
+//	将水印绘制到图片上
+//	img			image.Image		图片
+//	mark		image.Image		水印图片
+//	wm			*WatermarkOptions	水印参数
+//	返回值		*image.NRGBA		新图片
+func drawWatermark(img image.Image, mark image.Image, wm *WatermarkOptions) *image.NRGBA {
+	b := img.Bounds()
+	if wm.Scale > 0 {
+		mw := int(float64(b.Dx()) * wm.Scale)
+		if mw < 1 {
+			mw = 1
+		}
+		mark = imaging.Resize(mark, mw, 0, imaging.Lanczos)
+	}
+	opacity := wm.Opacity
+	if opacity <= 0 || opacity > 1 {
+		opacity = 1
+	}
+	mw, mh := mark.Bounds().Dx(), mark.Bounds().Dy()
+	dst := imaging.Clone(img)
+	if mw == 0 || mh == 0 {
+		return dst
+	}
+
+	if wm.Tile {
+		stepX, stepY := mw+wm.OffsetX, mh+wm.OffsetY
+		if stepX < 1 {
+			stepX = 1
+		}
+		if stepY < 1 {
+			stepY = 1
+		}
+		for y := 0; y < b.Dy(); y += stepY {
+			for x := 0; x < b.Dx(); x += stepX {
+				dst = imaging.Overlay(dst, mark, image.Pt(x, y), opacity)
+			}
+		}
+		return dst
+	}
+	return imaging.Overlay(dst, mark, gravityPoint(wm.Gravity, b.Dx(), b.Dy(), mw, mh, wm.OffsetX, wm.OffsetY), opacity)
+}
+
+// ========================
+//
+//	根据位置计算叠加内容的左上角坐标
+//	gravity		string		位置
+//	width		int		底图宽度
+//	height		int		底图高度
+//	w		int		叠加内容宽度
+//	h		int		叠加内容高度
+//	offsetX		int		水平偏移
+//	offsetY		int		垂直偏移
+//	返回值		image.Point	左上角坐标
+func gravityPoint(gravity string, width int, height int, w int, h int, offsetX int, offsetY int) image.Point {
+	x := width - w - offsetX
+	y := height - h - offsetY
+	switch gravity {
+	case GravityCenter:
+		x, y = (width-w)/2+offsetX, (height-h)/2+offsetY
+	case GravityNorth:
+		x, y = (width-w)/2+offsetX, offsetY
+	case GravitySouth:
+		x = (width-w)/2 + offsetX
+	case GravityEast:
+		y = (height-h)/2 + offsetY
+	case GravityWest:
+		x, y = offsetX, (height-h)/2+offsetY
+	case GravityNorthEast:
+		y = offsetY
+	case GravityNorthWest:
+		x, y = offsetX, offsetY
+	case GravitySouthWest:
+		x = offsetX
+	}
+	return image.Pt(x, y)
+}
+
+// ========================
+//
+//	生成视频使用的水印图层(与输出尺寸相同的透明PNG)
+//	mark		image.Image		水印图片
+//	wm			*WatermarkOptions	水印参数
+//	width		int			输出宽度
+//	height		int			输出高度
+//	返回值		string			水印图层路径,使用后需删除
+//	返回值		error			错误信息
+func watermarkLayer(mark image.Image, wm *WatermarkOptions, width int, height int) (string, error) {
+	layer := drawWatermark(imaging.New(width, height, color.NRGBA{}), mark, wm)
+	dir, err := os.MkdirTemp("", "mediaResize")
+	if err != nil {
+		return "", err
+	}
+	path := filepath.Join(dir, "watermark.png")
+	err = imaging.Save(layer, path)
+	if err != nil {
+		os.RemoveAll(dir)
+		return "", err
+	}
+	return path, nil
+}
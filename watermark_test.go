@@ -0,0 +1,40 @@
+package mediaResize
+
+import (
+	"image/color"
+	"path/filepath"
+	"testing"
+
+	"github.com/disintegration/imaging"
+)
+
+func TestImgResizeWatermark(t *testing.T) {
+	dir := t.TempDir()
+	src := filepath.Join(dir, "wm.png")
+	if err := imaging.Save(imaging.New(400, 400, color.White), src); err != nil {
+		t.Fatal("imaging.Save:", err)
+	}
+
+	res, err := ImgResizeWithOptions(src, filepath.Join(dir, "out.png"), []string{"png"}, []MediaWH{{Width: 100, Height: 100}, {Width: 300, Height: 300}}, -1, false, &ImgOptions{
+		Watermark: &WatermarkOptions{
+			Image:         imaging.New(20, 20, color.NRGBA{R: 255, A: 255}),
+			VariantFilter: VariantFilter{Sizes: []string{"M"}},
+		},
+	})
+	if err != nil {
+		t.Fatal("ImgResizeWithOptions failed:", err)
+	}
+	if len(res.Paths) != 2 {
+		t.Fatal("unexpected paths:", res.Paths)
+	}
+	for i, want := range []color.NRGBA{{R: 255, G: 255, B: 255, A: 255}, {R: 255, A: 255}} {
+		img, err := imaging.Open(res.Paths[i])
+		if err != nil {
+			t.Fatal("imaging.Open failed:", err)
+		}
+		b := img.Bounds()
+		if got := color.NRGBAModel.Convert(img.At(b.Max.X-1, b.Max.Y-1)); got != want {
+			t.Error(res.Paths[i], "unexpected corner color:", got)
+		}
+	}
+}
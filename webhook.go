@@ -0,0 +1,295 @@
+package mediaResize
+
+import (
+	"bytes"
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+)
+
+// JobHook 任务成功或最终失败后的回调
+type JobHook interface {
+	JobDone(ctx context.Context, job *Job) error
+}
+
+// JobHookFunc 将函数作为任务回调使用
+type JobHookFunc func(ctx context.Context, job *Job) error
+
+func (f JobHookFunc) JobDone(ctx context.Context, job *Job) error {
+	return f(ctx, job)
+}
+
+// WebhookSignatureHeader 请求体HMAC-SHA256签名所在的请求头,值为 sha256=<hex>
+const WebhookSignatureHeader = "X-MediaResize-Signature"
+
+// WebhookPayload 发送给webhook的JSON
+type WebhookPayload struct {
+	Event     string         `json:"event"`             //事件: job.succeeded, job.failed
+	JobID     string         `json:"jobId"`             //任务ID
+	Status    string         `json:"status"`            //任务状态
+	Path      string         `json:"path"`              //原文件路径
+	Error     string         `json:"error,omitempty"`   //失败原因
+	Results   []ResizeResult `json:"results,omitempty"` //生成的文件
+	Timestamp time.Time      `json:"timestamp"`         //发送时间
+}
+
+// Webhook 任务结束后发送带签名的JSON通知,失败时重试,重试次数用完后写入死信文件
+type Webhook struct {
+	URL         string        //接收地址
+	Secret      []byte        //签名密钥,为空时不签名
+	Client      *http.Client  //HTTP客户端,为空时使用10秒超时的客户端
+	MaxAttempts int           //最多发送次数,默认为5
+	Backoff     time.Duration //首次重试的等待时间,之后每次加倍,默认为1秒
+	DeadLetter  string        //发送失败的通知按行追加写入的JSON文件,为空时丢弃
+
+	mu      sync.Mutex //保护死信文件的写入
+	retryMu sync.Mutex //同时只执行一个RetryDeadLetters
+}
+
+// ========================
+//
+//	创建任务结束通知
+//	url			string		接收地址
+//	secret		[]byte		签名密钥
+//	返回值		*Webhook	通知
+func NewWebhook(url string, secret []byte) *Webhook {
+	return &Webhook{URL: url, Secret: secret}
+}
+
+// ========================
+//
+//	计算请求体的签名
+//	secret		[]byte		签名密钥
+//	body		[]byte		请求体
+//	返回值		string		签名,格式为 sha256=<hex>
+func SignWebhook(secret []byte, body []byte) string {
+	mac := hmac.New(sha256.New, secret)
+	mac.Write(body)
+	return "sha256=" + hex.EncodeToString(mac.Sum(nil))
+}
+
+// ========================
+//
+//	校验接收到的请求体签名
+//	secret		[]byte		签名密钥
+//	body		[]byte		请求体
+//	signature	string		WebhookSignatureHeader请求头
+//	返回值		bool		签名是否正确
+func VerifyWebhook(secret []byte, body []byte, signature string) bool {
+	return hmac.Equal([]byte(SignWebhook(secret, body)), []byte(signature))
+}
+
+func (wh *Webhook) JobDone(ctx context.Context, job *Job) error {
+	payload := &WebhookPayload{
+		Event:     "job." + job.Status,
+		JobID:     job.ID,
+		Status:    job.Status,
+		Path:      job.Request.Path,
+		Error:     job.Error,
+		Results:   job.Results,
+		Timestamp: time.Now().UTC(),
+	}
+	return wh.Send(ctx, payload)
+}
+
+// ========================
+//
+//	发送通知,网络错误、5xx、408和429时重试,仍失败时写入死信文件
+//	ctx			context.Context	上下文,取消时停止重试并写入死信文件
+//	payload		*WebhookPayload	通知内容
+//	返回值		error		最后一次发送的错误
+func (wh *Webhook) Send(ctx context.Context, payload *WebhookPayload) error {
+	body, err := json.Marshal(payload)
+	if err != nil {
+		return err
+	}
+	maxAttempts := wh.MaxAttempts
+	if maxAttempts <= 0 {
+		maxAttempts = 5
+	}
+	backoff := wh.Backoff
+	if backoff <= 0 {
+		backoff = time.Second
+	}
+	for attempt := 1; ; attempt++ {
+		var retry bool
+		retry, err = wh.post(ctx, payload.Event, body)
+		if err == nil {
+			return nil
+		}
+		if !retry || attempt >= maxAttempts {
+			break
+		}
+		timer := time.NewTimer(backoff)
+		select {
+		case <-ctx.Done():
+			timer.Stop()
+			err = fmt.Errorf("%w (last error: %v)", ctx.Err(), err)
+			return wh.deadLetter(body, err)
+		case <-timer.C:
+		}
+		backoff *= 2
+	}
+	return wh.deadLetter(body, err)
+}
+
+// ========================
+//
+//	发送一次请求
+//	ctx			context.Context	上下文
+//	event		string		事件
+//	body		[]byte		请求体
+//	返回值		bool		失败时是否可以重试
+//	返回值		error		错误信息
+func (wh *Webhook) post(ctx context.Context, event string, body []byte) (bool, error) {
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, wh.URL, bytes.NewReader(body))
+	if err != nil {
+		return false, err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("X-MediaResize-Event", event)
+	if len(wh.Secret) > 0 {
+		req.Header.Set(WebhookSignatureHeader, SignWebhook(wh.Secret, body))
+	}
+	client := wh.Client
+	if client == nil {
+		client = &http.Client{Timeout: 10 * time.Second}
+	}
+	resp, err := client.Do(req)
+	if err != nil {
+		return ctx.Err() == nil, err
+	}
+	io.Copy(io.Discard, io.LimitReader(resp.Body, 64<<10))
+	resp.Body.Close()
+	if resp.StatusCode >= 200 && resp.StatusCode < 300 {
+		return false, nil
+	}
+	retry := resp.StatusCode >= 500 || resp.StatusCode == http.StatusRequestTimeout || resp.StatusCode == http.StatusTooManyRequests
+	return retry, fmt.Errorf("webhook: %s returned %s", wh.URL, resp.Status)
+}
+
+// ========================
+//
+//	将发送失败的通知追加到死信文件
+//	body		[]byte		请求体
+//	cause		error		发送失败的原因
+//	返回值		error		发送失败的原因,写入失败时包含写入错误
+func (wh *Webhook) deadLetter(body []byte, cause error) error {
+	if wh.DeadLetter == "" {
+		return cause
+	}
+	line, err := json.Marshal(struct {
+		URL     string          `json:"url"`
+		Error   string          `json:"error"`
+		Time    time.Time       `json:"time"`
+		Payload json.RawMessage `json:"payload"`
+	}{wh.URL, cause.Error(), time.Now().UTC(), body})
+	if err != nil {
+		return cause
+	}
+	if err = wh.appendDeadLetter(line); err != nil {
+		return fmt.Errorf("%w; dead letter: %v", cause, err)
+	}
+	return cause
+}
+
+func (wh *Webhook) appendDeadLetter(line []byte) error {
+	wh.mu.Lock()
+	defer wh.mu.Unlock()
+	if err := os.MkdirAll(filepath.Dir(wh.DeadLetter), os.ModePerm); err != nil {
+		return err
+	}
+	file, err := os.OpenFile(wh.DeadLetter, os.O_CREATE|os.O_APPEND|os.O_WRONLY, 0o644)
+	if err != nil {
+		return err
+	}
+	_, err = file.Write(append(line, '\n'))
+	if cerr := file.Close(); err == nil {
+		err = cerr
+	}
+	return err
+}
+
+// ========================
+//
+//	重新发送死信文件中的通知,发送成功的通知从文件中删除
+//	发送前将死信文件移到 DeadLetter+".retrying",全部处理后再删除,进程中途退出时下次重新发送其中的通知
+//	ctx			context.Context	上下文
+//	返回值		int		发送成功的数量
+//	返回值		error		错误信息
+func (wh *Webhook) RetryDeadLetters(ctx context.Context) (int, error) {
+	wh.retryMu.Lock()
+	defer wh.retryMu.Unlock()
+	retrying := wh.DeadLetter + ".retrying"
+	wh.mu.Lock()
+	err := wh.moveDeadLetters(retrying)
+	wh.mu.Unlock()
+	if err != nil {
+		return 0, err
+	}
+	data, err := os.ReadFile(retrying)
+	if err != nil {
+		return 0, err
+	}
+	sent := 0
+	for _, line := range strings.Split(strings.TrimSpace(string(data)), "\n") {
+		if line == "" {
+			continue
+		}
+		var letter struct {
+			Payload *WebhookPayload `json:"payload"`
+		}
+		if err = json.Unmarshal([]byte(line), &letter); err != nil || letter.Payload == nil {
+			// 无法解析的记录保留在文件中
+			wh.appendDeadLetter([]byte(line))
+			continue
+		}
+		// 失败时Send会重新写入死信文件
+		if wh.Send(ctx, letter.Payload) == nil {
+			sent++
+		}
+	}
+	return sent, os.Remove(retrying)
+}
+
+// ========================
+//
+//	将死信文件移到retrying,上次重新发送未完成时追加到其后
+//	retrying	string		重新发送中的文件路径
+//	返回值		error		错误信息,两个文件都不存在时为os.ErrNotExist
+func (wh *Webhook) moveDeadLetters(retrying string) error {
+	if _, err := os.Stat(retrying); os.IsNotExist(err) {
+		return os.Rename(wh.DeadLetter, retrying)
+	} else if err != nil {
+		return err
+	}
+	data, err := os.ReadFile(wh.DeadLetter)
+	if os.IsNotExist(err) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	file, err := os.OpenFile(retrying, os.O_APPEND|os.O_WRONLY, 0o644)
+	if err != nil {
+		return err
+	}
+	_, err = file.Write(data)
+	if cerr := file.Close(); err == nil {
+		err = cerr
+	}
+	if err != nil {
+		return err
+	}
+	return os.Remove(wh.DeadLetter)
+}
@@ -0,0 +1,288 @@
+package mediaResize
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+// webhookReceiver 记录收到的通知,前fail次返回status
+type webhookReceiver struct {
+	mu       sync.Mutex
+	secret   []byte
+	fail     int
+	status   int
+	calls    int
+	payloads []*WebhookPayload
+}
+
+func (rc *webhookReceiver) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	body, _ := io.ReadAll(r.Body)
+	rc.mu.Lock()
+	defer rc.mu.Unlock()
+	rc.calls++
+	if !VerifyWebhook(rc.secret, body, r.Header.Get(WebhookSignatureHeader)) {
+		http.Error(w, "bad signature", http.StatusUnauthorized)
+		return
+	}
+	if rc.calls <= rc.fail {
+		w.WriteHeader(rc.status)
+		return
+	}
+	payload := &WebhookPayload{}
+	if err := json.Unmarshal(body, payload); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	rc.payloads = append(rc.payloads, payload)
+}
+
+func (rc *webhookReceiver) received() []*WebhookPayload {
+	rc.mu.Lock()
+	defer rc.mu.Unlock()
+	return append([]*WebhookPayload{}, rc.payloads...)
+}
+
+func TestWebhookSignature(t *testing.T) {
+	secret := []byte("secret")
+	body := []byte(`{"jobId":"1"}`)
+	sig := SignWebhook(secret, body)
+	if !strings.HasPrefix(sig, "sha256=") || !VerifyWebhook(secret, body, sig) {
+		t.Fatal("signature:", sig)
+	}
+	if VerifyWebhook(secret, []byte(`{"jobId":"2"}`), sig) || VerifyWebhook([]byte("other"), body, sig) || VerifyWebhook(secret, body, "") {
+		t.Error("tampered signature accepted")
+	}
+}
+
+func TestWebhookRetry(t *testing.T) {
+	rc := &webhookReceiver{secret: []byte("k"), fail: 2, status: http.StatusServiceUnavailable}
+	srv := httptest.NewServer(rc)
+	defer srv.Close()
+
+	wh := NewWebhook(srv.URL, rc.secret)
+	wh.Backoff = time.Millisecond
+	if err := wh.Send(context.Background(), &WebhookPayload{Event: "job.succeeded", JobID: "1"}); err != nil {
+		t.Fatal(err)
+	}
+	if got := rc.received(); rc.calls != 3 || len(got) != 1 || got[0].JobID != "1" {
+		t.Errorf("calls %d, payloads %+v", rc.calls, got)
+	}
+
+	// 4xx不重试
+	rc.calls, rc.fail, rc.status = 0, 10, http.StatusBadRequest
+	if err := wh.Send(context.Background(), &WebhookPayload{JobID: "2"}); err == nil || rc.calls != 1 {
+		t.Errorf("400: calls %d, err %v", rc.calls, err)
+	}
+}
+
+func TestWebhookDeadLetter(t *testing.T) {
+	rc := &webhookReceiver{secret: []byte("k"), fail: 100, status: http.StatusInternalServerError}
+	srv := httptest.NewServer(rc)
+	defer srv.Close()
+
+	wh := NewWebhook(srv.URL, rc.secret)
+	wh.MaxAttempts, wh.Backoff = 2, time.Millisecond
+	wh.DeadLetter = filepath.Join(t.TempDir(), "dead", "webhook.jsonl")
+	for _, id := range []string{"1", "2"} {
+		if err := wh.Send(context.Background(), &WebhookPayload{JobID: id}); err == nil {
+			t.Fatal("expected error")
+		}
+	}
+	if rc.calls != 4 {
+		t.Errorf("calls: %d", rc.calls)
+	}
+	data, err := os.ReadFile(wh.DeadLetter)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if lines := strings.Split(strings.TrimSpace(string(data)), "\n"); len(lines) != 2 || !strings.Contains(lines[0], `"jobId":"1"`) {
+		t.Fatalf("dead letters: %s", data)
+	}
+
+	// 接收端恢复后重新发送
+	rc.fail = 0
+	sent, err := wh.RetryDeadLetters(context.Background())
+	if err != nil || sent != 2 {
+		t.Fatal(sent, err)
+	}
+	if got := rc.received(); len(got) != 2 || got[0].JobID != "1" || got[1].JobID != "2" {
+		t.Errorf("payloads: %+v", got)
+	}
+	if _, err = os.Stat(wh.DeadLetter); !os.IsNotExist(err) {
+		t.Error("dead letter file not removed:", err)
+	}
+}
+
+func TestJobQueueWebhook(t *testing.T) {
+	rc := &webhookReceiver{secret: []byte("k")}
+	srv := httptest.NewServer(rc)
+	defer srv.Close()
+
+	dir := t.TempDir()
+	src := filepath.Join(dir, "a.png")
+	newTestImage(t, src, 200, 100)
+	q, err := OpenJobQueue(filepath.Join(dir, "jobs.db"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer q.Close()
+	q.MaxAttempts, q.Backoff = 1, time.Millisecond
+	q.Hooks = []JobHook{NewWebhook(srv.URL, rc.secret)}
+	ok, err := q.Enqueue(JobRequest{Path: src, NewPath: src, Formats: []string{"jpg"}, MaxWHs: []MediaWH{{Width: 50, Height: 50}}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	bad, err := q.Enqueue(JobRequest{Type: "image", Path: filepath.Join(dir, "missing.png"), NewPath: filepath.Join(dir, "m.png"), Formats: []string{"jpg"}, MaxWHs: []MediaWH{{Width: 50, Height: 50}}})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	go q.Run(ctx)
+	waitJob(t, q, ok.ID)
+	waitJob(t, q, bad.ID)
+
+	deadline := time.Now().Add(5 * time.Second)
+	for len(rc.received()) < 2 && time.Now().Before(deadline) {
+		time.Sleep(10 * time.Millisecond)
+	}
+	byID := map[string]*WebhookPayload{}
+	for _, p := range rc.received() {
+		byID[p.JobID] = p
+	}
+	if p := byID[ok.ID]; p == nil || p.Event != "job.succeeded" || len(p.Results) != 2 || p.Results[0].Format != "jpg" {
+		t.Errorf("succeeded payload: %+v", p)
+	}
+	if p := byID[bad.ID]; p == nil || p.Event != "job.failed" || p.Error == "" {
+		t.Errorf("failed payload: %+v", p)
+	}
+}
+
+func TestWebhookRetryInterrupted(t *testing.T) {
+	rc := &webhookReceiver{secret: []byte("k"), fail: 1, status: http.StatusInternalServerError}
+	srv := httptest.NewServer(rc)
+	defer srv.Close()
+
+	wh := NewWebhook(srv.URL, rc.secret)
+	wh.MaxAttempts = 1
+	wh.DeadLetter = filepath.Join(t.TempDir(), "webhook.jsonl")
+	retrying := wh.DeadLetter + ".retrying"
+	letter := func(id string) string {
+		return `{"url":"` + srv.URL + `","error":"x","payload":{"event":"job.succeeded","jobId":"` + id + `"}}` + "\n"
+	}
+	// 上次重新发送时进程退出留下的文件与新的死信一起发送
+	os.WriteFile(retrying, []byte(letter("1")), 0o644)
+	os.WriteFile(wh.DeadLetter, []byte(letter("2")+letter("3")), 0o644)
+
+	sent, err := wh.RetryDeadLetters(context.Background())
+	if err != nil || sent != 2 {
+		t.Fatal(sent, err)
+	}
+	if _, err = os.Stat(retrying); !os.IsNotExist(err) {
+		t.Error("retrying file not removed:", err)
+	}
+	// 第一个通知发送失败,重新写入死信文件
+	data, err := os.ReadFile(wh.DeadLetter)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if lines := strings.Split(strings.TrimSpace(string(data)), "\n"); len(lines) != 1 || !strings.Contains(lines[0], `"jobId":"1"`) {
+		t.Errorf("dead letters: %s", data)
+	}
+	if got := rc.received(); len(got) != 2 || got[0].JobID != "2" || got[1].JobID != "3" {
+		t.Errorf("payloads: %+v", got)
+	}
+}
+
+func TestJobQueueHooksAsync(t *testing.T) {
+	dir := t.TempDir()
+	src := filepath.Join(dir, "a.png")
+	newTestImage(t, src, 200, 100)
+	q, err := OpenJobQueue(filepath.Join(dir, "jobs.db"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer q.Close()
+	q.Workers = 1
+	release := make(chan struct{})
+	hookErrs := make(chan error, 2)
+	q.Hooks = []JobHook{JobHookFunc(func(ctx context.Context, job *Job) error {
+		select {
+		case <-release:
+		case <-ctx.Done():
+		}
+		hookErrs <- ctx.Err()
+		return ctx.Err()
+	})}
+	req := JobRequest{Path: src, NewPath: src, Formats: []string{"jpg"}, MaxWHs: []MediaWH{{Width: 50, Height: 50}}}
+	first, _ := q.Enqueue(req)
+	second, _ := q.Enqueue(req)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan error, 1)
+	go func() { done <- q.Run(ctx) }()
+	// 第一个任务的回调阻塞时worker继续执行下一个任务
+	waitJob(t, q, first.ID)
+	if job := waitJob(t, q, second.ID); job.Status != JobSucceeded {
+		t.Errorf("second job: %+v", job)
+	}
+
+	// 关闭队列不取消回调的ctx,Run等待回调完成
+	cancel()
+	select {
+	case <-done:
+		t.Fatal("Run returned before hooks finished")
+	case <-time.After(50 * time.Millisecond):
+	}
+	close(release)
+	if err := <-done; err != nil {
+		t.Error("Run:", err)
+	}
+	for i := 0; i < 2; i++ {
+		if err := <-hookErrs; err != nil {
+			t.Error("hook ctx:", err)
+		}
+	}
+}
+
+func TestJobQueueHookTimeout(t *testing.T) {
+	dir := t.TempDir()
+	src := filepath.Join(dir, "a.png")
+	newTestImage(t, src, 200, 100)
+	q, err := OpenJobQueue(filepath.Join(dir, "jobs.db"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer q.Close()
+	q.HookTimeout = 20 * time.Millisecond
+	hookErr := make(chan error, 1)
+	q.Hooks = []JobHook{JobHookFunc(func(ctx context.Context, job *Job) error {
+		<-ctx.Done()
+		hookErr <- ctx.Err()
+		return ctx.Err()
+	})}
+	q.Enqueue(JobRequest{Path: src, NewPath: src, Formats: []string{"jpg"}, MaxWHs: []MediaWH{{Width: 50, Height: 50}}})
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	go q.Run(ctx)
+	select {
+	case err := <-hookErr:
+		if !errors.Is(err, context.DeadlineExceeded) {
+			t.Error("hook ctx:", err)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("hook did not time out")
+	}
+}